@@ -6,3 +6,6 @@ const FaviconMIME = "image/png"
 
 //go:embed favicon.png
 var Favicon []byte
+
+//go:embed login.html
+var LoginHTML []byte
@@ -0,0 +1,133 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"github.com/allape/gogger"
+	"github.com/allape/homesong/env"
+	"github.com/allape/homesong/model"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+	"time"
+)
+
+var l = gogger.New("auth")
+
+var (
+	ErrorInvalidCredentials = errors.New("invalid username or password")
+	ErrorInvalidToken       = errors.New("invalid or expired token")
+)
+
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+func Login(db *gorm.DB, username, password string) (*model.User, error) {
+	var user model.User
+	if err := db.Model(&user).Where("username = ? AND deleted_at IS NULL", username).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrorInvalidCredentials
+		}
+		return nil, err
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)); err != nil {
+		return nil, ErrorInvalidCredentials
+	}
+
+	return &user, nil
+}
+
+// NewSession returns a new random token for user, only its digest is saved
+func NewSession(db *gorm.DB, user *model.User) (string, *model.Session, error) {
+	token, err := NewToken()
+	if err != nil {
+		return "", nil, err
+	}
+
+	session := model.Session{
+		UserID:    user.ID,
+		Digest:    TokenDigest(token),
+		ExpiresAt: time.Now().Add(time.Duration(env.SessionTTL) * time.Hour),
+	}
+	if err := db.Create(&session).Error; err != nil {
+		return "", nil, err
+	}
+
+	return token, &session, nil
+}
+
+func DeleteSession(db *gorm.DB, token string) error {
+	return db.Where("digest = ?", TokenDigest(token)).Delete(&model.Session{}).Error
+}
+
+// DeleteOtherSessions signs user out everywhere but the session of token
+func DeleteOtherSessions(db *gorm.DB, user *model.User, token string) error {
+	return db.Where("user_id = ? AND digest <> ?", user.ID, TokenDigest(token)).Delete(&model.Session{}).Error
+}
+
+func FindUserBySession(db *gorm.DB, token string) (*model.User, error) {
+	var session model.Session
+	if err := db.Model(&session).Where("digest = ? AND expires_at > ?", TokenDigest(token), time.Now()).First(&session).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrorInvalidToken
+		}
+		return nil, err
+	}
+
+	var user model.User
+	if err := db.Model(&user).Where("deleted_at IS NULL").First(&user, session.UserID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrorInvalidToken
+		}
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+func NewToken() (string, error) {
+	randomBytes := make([]byte, 32)
+	if _, err := rand.Read(randomBytes); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(randomBytes), nil
+}
+
+func TokenDigest(token string) string {
+	digest := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(digest[:])
+}
+
+// EnsureAdmin creates the admin user from env if there is no user at all
+func EnsureAdmin(db *gorm.DB) error {
+	var count int64
+	if err := db.Model(&model.User{}).Count(&count).Error; err != nil {
+		return err
+	} else if count > 0 {
+		return nil
+	}
+
+	password := env.AdminPassword
+	if password == "" {
+		token, err := NewToken()
+		if err != nil {
+			return err
+		}
+		password = token[:16]
+		l.Warn().Printf("Created admin user %s with generated password: %s", env.AdminUsername, password)
+	}
+
+	hash, err := HashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	return db.Create(&model.User{Username: env.AdminUsername, Password: hash, Role: model.UserRoleAdmin}).Error
+}
@@ -0,0 +1,88 @@
+package auth
+
+import (
+	"errors"
+	"github.com/allape/homesong/env"
+	"github.com/allape/homesong/model"
+	"gorm.io/gorm"
+	"slices"
+	"strings"
+)
+
+var (
+	ErrorUnknownUser  = errors.New("unknown user")
+	ErrorUsernameUsed = errors.New("username is used by another user")
+)
+
+// OIDCExternalID identifies an OIDC user by issuer and subject, which are stable and unique, unlike usernames
+func OIDCExternalID(issuer, subject string) string {
+	return strings.TrimSuffix(issuer, "/") + "#" + subject
+}
+
+// ProxyExternalID identifies a user authenticated by a trusted proxy
+func ProxyExternalID(username string) string {
+	return "proxy#" + username
+}
+
+// ExternalUser maps an identity from OIDC or a trusted proxy onto the HomeSong user bound to externalID.
+// An unbound user named username is bound only if it has no password, which means it was created by an external login,
+// local users are bound by admins setting their externalId.
+// Unknown users are created if env.AutoCreateUsers is on, and roles follow env.AdminGroup if it is set.
+// External users have no password, so they can not log in with POST /api/auth/login.
+func ExternalUser(db *gorm.DB, externalID, username string, groups []string) (*model.User, error) {
+	username = strings.TrimSpace(username)
+	if externalID == "" || username == "" {
+		return nil, ErrorUnknownUser
+	}
+
+	role := model.UserRoleListener
+	if env.AdminGroup != "" && slices.Contains(groups, env.AdminGroup) {
+		role = model.UserRoleAdmin
+	}
+
+	var user model.User
+	err := db.Model(&user).Where("external_id = ? AND deleted_at IS NULL", externalID).First(&user).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		err = db.Model(&user).Where("username = ? AND deleted_at IS NULL", username).First(&user).Error
+		if err == nil {
+			if user.Password != "" || user.ExternalID != "" {
+				return nil, ErrorUsernameUsed
+			}
+			if err := db.Model(&user).UpdateColumn("external_id", externalID).Error; err != nil {
+				return nil, err
+			}
+			user.ExternalID = externalID
+		}
+	}
+	if err == nil {
+		if env.AdminGroup != "" && user.Role != role {
+			if err := db.Model(&user).UpdateColumn("role", role).Error; err != nil {
+				return nil, err
+			}
+			user.Role = role
+		}
+		return &user, nil
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	if !env.AutoCreateUsers {
+		return nil, ErrorUnknownUser
+	}
+
+	user = model.User{Username: username, Role: role, ExternalID: externalID}
+	if err := db.Create(&user).Error; err != nil {
+		return nil, err
+	}
+
+	l.Info().Printf("Created %s user %s from external identity", user.Role, user.Username)
+
+	return &user, nil
+}
+
+// SplitGroups splits groups sent by a proxy, separated by comma, semicolon or space
+func SplitGroups(groups string) []string {
+	return strings.FieldsFunc(groups, func(r rune) bool {
+		return r == ',' || r == ';' || r == ' '
+	})
+}
@@ -0,0 +1,91 @@
+package auth
+
+import (
+	"errors"
+	"github.com/allape/homesong/env"
+	"github.com/allape/homesong/model"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"testing"
+)
+
+func TestExternalUser(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&model.User{}); err != nil {
+		t.Fatal(err)
+	}
+
+	local := model.User{Username: "abba", Password: "hash", Role: model.UserRoleAdmin}
+	unbound := model.User{Username: "benny", Role: model.UserRoleListener}
+	if err := db.Create(&local).Error; err != nil {
+		t.Fatal(err)
+	} else if err := db.Create(&unbound).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	env.AdminGroup, env.AutoCreateUsers = "", true
+	issuer := "https://sso.example.com/"
+
+	for _, c := range []struct {
+		name       string
+		externalID string
+		username   string
+		groups     []string
+		id         int
+		role       model.UserRole
+		err        error
+	}{
+		{"local users are not taken over", OIDCExternalID(issuer, "1"), "abba", nil, 0, "", ErrorUsernameUsed},
+		{"users without password are bound", OIDCExternalID(issuer, "2"), "benny", nil, int(unbound.ID), model.UserRoleListener, nil},
+		{"bound users are found by identity", OIDCExternalID(issuer, "2"), "renamed", nil, int(unbound.ID), model.UserRoleListener, nil},
+		{"bound users are not bound again", ProxyExternalID("benny"), "benny", nil, 0, "", ErrorUsernameUsed},
+		{"unknown users are created", ProxyExternalID("agnetha"), "agnetha", nil, -1, model.UserRoleListener, nil},
+		{"roles are kept without admin group", ProxyExternalID("agnetha"), "agnetha", []string{"admins"}, -1, model.UserRoleListener, nil},
+		{"empty usernames are unknown", ProxyExternalID(""), " ", nil, 0, "", ErrorUnknownUser},
+	} {
+		user, err := ExternalUser(db, c.externalID, c.username, c.groups)
+		if !errors.Is(err, c.err) {
+			t.Fatal(c.name, "unexpected error", err)
+		} else if err != nil {
+			continue
+		}
+		if user.ExternalID != c.externalID || user.Role != c.role || user.Password != "" {
+			t.Fatal(c.name, "unexpected user", user)
+		} else if c.id > 0 && int(user.ID) != c.id {
+			t.Fatal(c.name, "unexpected user", user)
+		}
+	}
+
+	env.AdminGroup = "admins"
+
+	user, err := ExternalUser(db, ProxyExternalID("agnetha"), "agnetha", []string{"users", "admins"})
+	if err != nil {
+		t.Fatal(err)
+	} else if user.Role != model.UserRoleAdmin {
+		t.Fatal("members of admin group should be promoted", user)
+	}
+
+	user, err = ExternalUser(db, ProxyExternalID("agnetha"), "agnetha", []string{"users"})
+	if err != nil {
+		t.Fatal(err)
+	} else if user.Role != model.UserRoleListener {
+		t.Fatal("others should be demoted", user)
+	}
+
+	env.AdminGroup, env.AutoCreateUsers = "", false
+
+	if _, err := ExternalUser(db, ProxyExternalID("frida"), "frida", nil); !errors.Is(err, ErrorUnknownUser) {
+		t.Fatal("unknown users should not be created", err)
+	}
+
+	var count int64
+	if err := db.Model(&model.User{}).Count(&count).Error; err != nil {
+		t.Fatal(err)
+	} else if count != 3 {
+		t.Fatal("unexpected count of users", count)
+	}
+}
@@ -0,0 +1,154 @@
+package auth
+
+import (
+	"errors"
+	"github.com/allape/gocrud"
+	"github.com/allape/homesong/env"
+	"github.com/allape/homesong/model"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"net/http"
+	"strings"
+)
+
+const (
+	CookieName  = "homesong_token"
+	UserKey     = "homesong_user"
+	ApiTokenKey = "homesong_api_token"
+	LoginPath   = "/login"
+	TokenQuery  = "token"
+)
+
+// Anonymous is the user of every request when auth is disabled
+var Anonymous = &model.User{Username: "anonymous", Role: model.UserRoleAdmin}
+
+var (
+	ErrorUnauthorized = errors.New("unauthorized")
+	ErrorForbidden    = errors.New("forbidden")
+)
+
+// GetToken looks for the token in Authorization header, then in cookie,
+// API tokens may also be sent as ?token=, so that urls can be pasted into players
+func GetToken(context *gin.Context) string {
+	if authorization := context.GetHeader("Authorization"); authorization != "" {
+		if token, ok := strings.CutPrefix(authorization, "Bearer "); ok {
+			return strings.TrimSpace(token)
+		}
+	}
+
+	if cookie, err := context.Cookie(CookieName); err == nil {
+		return cookie
+	}
+
+	if token := context.Query(TokenQuery); IsApiToken(token) {
+		return token
+	}
+
+	return ""
+}
+
+// CurrentUser returns the user set by Authenticate, or Anonymous
+func CurrentUser(context *gin.Context) *model.User {
+	if user, ok := context.Get(UserKey); ok {
+		return user.(*model.User)
+	}
+	return Anonymous
+}
+
+// CurrentApiToken returns the API token of current request, or nil for sessions
+func CurrentApiToken(context *gin.Context) *model.ApiToken {
+	if apiToken, ok := context.Get(ApiTokenKey); ok {
+		return apiToken.(*model.ApiToken)
+	}
+	return nil
+}
+
+func authenticate(db *gorm.DB, context *gin.Context) (*model.User, error) {
+	if !env.EnableAuth {
+		return Anonymous, nil
+	}
+
+	if user, err := RemoteUser(db, context); err != nil || user != nil {
+		return user, err
+	}
+
+	token := GetToken(context)
+	if token == "" {
+		return nil, ErrorUnauthorized
+	}
+
+	if !IsApiToken(token) {
+		return FindUserBySession(db, token)
+	}
+
+	user, apiToken, err := FindUserByApiToken(db, token)
+	if err != nil {
+		return nil, err
+	} else if err := checkScopes(context, apiToken); err != nil {
+		return nil, err
+	}
+
+	context.Set(ApiTokenKey, apiToken)
+
+	return user, nil
+}
+
+func authenticateErrorStatus(err error) int {
+	if errors.Is(err, ErrorForbidden) {
+		return http.StatusForbidden
+	}
+	return http.StatusUnauthorized
+}
+
+// Authenticate rejects requests without a valid token, when auth is enabled
+func Authenticate(db *gorm.DB) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		user, err := authenticate(db, context)
+		if err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.FromStatus(authenticateErrorStatus(err)), err)
+			return
+		}
+		context.Set(UserKey, user)
+		context.Next()
+	}
+}
+
+// RedirectToLogin works like Authenticate, but redirects to LoginPath instead, for pages
+func RedirectToLogin(db *gorm.DB) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		user, err := authenticate(db, context)
+		if err != nil {
+			context.Redirect(http.StatusFound, LoginPath)
+			context.Abort()
+			return
+		}
+		context.Set(UserKey, user)
+		context.Next()
+	}
+}
+
+// AdminOnly rejects requests from non admin users, must be used after Authenticate
+func AdminOnly() gin.HandlerFunc {
+	return func(context *gin.Context) {
+		if !CurrentUser(context).IsAdmin() {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.FromStatus(http.StatusForbidden), ErrorForbidden)
+			return
+		}
+		context.Next()
+	}
+}
+
+// AdminOnlyWrites lets everyone read, but only admins modify, must be used after Authenticate
+func AdminOnlyWrites() gin.HandlerFunc {
+	return func(context *gin.Context) {
+		switch context.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+		default:
+			if !CurrentUser(context).IsAdmin() {
+				gocrud.MakeErrorResponse(context, gocrud.RestCoder.FromStatus(http.StatusForbidden), ErrorForbidden)
+				return
+			}
+		}
+		context.Next()
+	}
+}
@@ -0,0 +1,278 @@
+package auth
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/allape/homesong/env"
+	"github.com/allape/homesong/model"
+	"gorm.io/gorm"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrorOIDCDisabled     = errors.New("oidc is disabled")
+	ErrorInvalidIDToken   = errors.New("invalid id token")
+	ErrorMissingUsername  = errors.New("username claim not found")
+	ErrorOIDCStateInvalid = errors.New("invalid oidc state")
+)
+
+// OIDCStateCookieName stores "<state>.<nonce>" during the authorization code flow
+const OIDCStateCookieName = "homesong_oidc_state"
+
+var oidcClient = &http.Client{Timeout: 10 * time.Second}
+
+type OIDCProvider struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserinfoEndpoint      string `json:"userinfo_endpoint"`
+}
+
+type OIDCTokenResponse struct {
+	AccessToken string `json:"access_token"`
+	IDToken     string `json:"id_token"`
+	TokenType   string `json:"token_type"`
+}
+
+type OIDCClaims map[string]any
+
+func OIDCEnabled() bool {
+	return env.OIDCIssuer != "" && env.OIDCClientID != "" && env.OIDCRedirectURL != ""
+}
+
+var (
+	oidcProviderLocker sync.Mutex
+	oidcProvider       *OIDCProvider
+)
+
+// GetOIDCProvider fetches the discovery document of env.OIDCIssuer, and caches it once succeeded
+func GetOIDCProvider(ctx context.Context) (*OIDCProvider, error) {
+	if !OIDCEnabled() {
+		return nil, ErrorOIDCDisabled
+	}
+
+	oidcProviderLocker.Lock()
+	defer oidcProviderLocker.Unlock()
+
+	if oidcProvider != nil {
+		return oidcProvider, nil
+	}
+
+	wellKnown := strings.TrimSuffix(env.OIDCIssuer, "/") + "/.well-known/openid-configuration"
+
+	var provider OIDCProvider
+	if err := oidcGetJSON(ctx, wellKnown, "", &provider); err != nil {
+		return nil, err
+	}
+
+	if strings.TrimSuffix(provider.Issuer, "/") != strings.TrimSuffix(env.OIDCIssuer, "/") {
+		return nil, fmt.Errorf("issuer mismatch: expected %s, got %s", env.OIDCIssuer, provider.Issuer)
+	} else if provider.AuthorizationEndpoint == "" || provider.TokenEndpoint == "" {
+		return nil, fmt.Errorf("incomplete discovery document from %s", wellKnown)
+	}
+
+	oidcProvider = &provider
+
+	return oidcProvider, nil
+}
+
+// OIDCAuthCodeURL returns the url to redirect to, with the state and nonce which should be checked in callback
+func OIDCAuthCodeURL(ctx context.Context) (string, string, string, error) {
+	provider, err := GetOIDCProvider(ctx)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	state, err := NewToken()
+	if err != nil {
+		return "", "", "", err
+	}
+
+	nonce, err := NewToken()
+	if err != nil {
+		return "", "", "", err
+	}
+
+	authURL, err := url.Parse(provider.AuthorizationEndpoint)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	query := authURL.Query()
+	query.Set("response_type", "code")
+	query.Set("client_id", env.OIDCClientID)
+	query.Set("redirect_uri", env.OIDCRedirectURL)
+	query.Set("scope", env.OIDCScopes)
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	authURL.RawQuery = query.Encode()
+
+	return authURL.String(), state, nonce, nil
+}
+
+// OIDCExchange trades the authorization code for the claims of the id token, merged with userinfo.
+// The id token comes straight from the token endpoint over TLS, so its signature is not verified,
+// as allowed by OpenID Connect Core 3.1.3.7, but issuer, audience, expiry and nonce are.
+func OIDCExchange(ctx context.Context, code, nonce string) (OIDCClaims, error) {
+	provider, err := GetOIDCProvider(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", env.OIDCRedirectURL)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, provider.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(env.OIDCClientID), url.QueryEscape(env.OIDCClientSecret))
+
+	var token OIDCTokenResponse
+	if err := oidcDo(req, &token); err != nil {
+		return nil, err
+	}
+
+	claims, err := ParseIDToken(token.IDToken)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := claims.Verify(provider.Issuer, env.OIDCClientID, nonce); err != nil {
+		return nil, err
+	}
+
+	if provider.UserinfoEndpoint != "" && token.AccessToken != "" {
+		var userinfo OIDCClaims
+		if err := oidcGetJSON(ctx, provider.UserinfoEndpoint, token.AccessToken, &userinfo); err != nil {
+			l.Warn().Printf("Failed to fetch userinfo: %v", err)
+		} else if userinfo.String("sub") == claims.String("sub") {
+			for key, value := range userinfo {
+				if _, ok := claims[key]; !ok {
+					claims[key] = value
+				}
+			}
+		}
+	}
+
+	return claims, nil
+}
+
+// OIDCUser maps claims onto the HomeSong user bound to the issuer and subject,
+// env.OIDCUsernameClaim and env.OIDCGroupsClaim name and promote it
+func OIDCUser(db *gorm.DB, claims OIDCClaims) (*model.User, error) {
+	username := claims.String(env.OIDCUsernameClaim)
+	if username == "" {
+		return nil, ErrorMissingUsername
+	}
+	subject := claims.String("sub")
+	if subject == "" {
+		return nil, ErrorUnknownUser
+	}
+	return ExternalUser(db, OIDCExternalID(claims.String("iss"), subject), username, claims.Strings(env.OIDCGroupsClaim))
+}
+
+// ParseIDToken decodes the payload of a JWT without checking its signature
+func ParseIDToken(idToken string) (OIDCClaims, error) {
+	parts := strings.Split(idToken, ".")
+	if len(parts) != 3 {
+		return nil, ErrorInvalidIDToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrorInvalidIDToken, err)
+	}
+
+	var claims OIDCClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrorInvalidIDToken, err)
+	}
+
+	return claims, nil
+}
+
+func (c OIDCClaims) Verify(issuer, clientID, nonce string) error {
+	if c.String("iss") != issuer {
+		return fmt.Errorf("%w: issuer mismatch", ErrorInvalidIDToken)
+	} else if !slices.Contains(c.Strings("aud"), clientID) {
+		return fmt.Errorf("%w: audience mismatch", ErrorInvalidIDToken)
+	} else if c.String("nonce") != nonce {
+		return fmt.Errorf("%w: nonce mismatch", ErrorInvalidIDToken)
+	}
+
+	exp, ok := c["exp"].(float64)
+	if !ok || time.Unix(int64(exp), 0).Before(time.Now()) {
+		return fmt.Errorf("%w: expired", ErrorInvalidIDToken)
+	}
+
+	return nil
+}
+
+func (c OIDCClaims) String(key string) string {
+	value, _ := c[key].(string)
+	return value
+}
+
+// Strings accepts both a single string and an array of strings, like "aud" or "groups"
+func (c OIDCClaims) Strings(key string) []string {
+	switch value := c[key].(type) {
+	case string:
+		return []string{value}
+	case []any:
+		var values []string
+		for _, v := range value {
+			if s, ok := v.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+func oidcGetJSON(ctx context.Context, u, accessToken string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if accessToken != "" {
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+	}
+	return oidcDo(req, v)
+}
+
+func oidcDo(req *http.Request, v any) error {
+	res, err := oidcClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = res.Body.Close()
+	}()
+
+	body, err := io.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s %s: %d %s", req.Method, req.URL, res.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	return json.Unmarshal(body, v)
+}
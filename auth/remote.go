@@ -0,0 +1,71 @@
+package auth
+
+import (
+	"github.com/allape/homesong/env"
+	"github.com/allape/homesong/model"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+)
+
+var trustedProxies = sync.OnceValue(func() []netip.Prefix {
+	var prefixes []netip.Prefix
+	for _, cidr := range strings.Split(env.TrustedProxies, ",") {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			l.Warn().Printf("Ignored invalid trusted proxy %s: %v", cidr, err)
+			continue
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	return prefixes
+})
+
+// IsTrustedProxy checks the direct peer of the request, headers like X-Forwarded-For are ignored on purpose
+func IsTrustedProxy(context *gin.Context) bool {
+	host, _, err := net.SplitHostPort(context.Request.RemoteAddr)
+	if err != nil {
+		host = context.Request.RemoteAddr
+	}
+
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+
+	for _, prefix := range trustedProxies() {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// RemoteUser returns the user named in env.RemoteUserHeader, or nil if proxy login is disabled,
+// the header is absent, or the request does not come from a trusted proxy
+func RemoteUser(db *gorm.DB, context *gin.Context) (*model.User, error) {
+	if env.RemoteUserHeader == "" {
+		return nil, nil
+	}
+
+	username := strings.TrimSpace(context.GetHeader(env.RemoteUserHeader))
+	if username == "" || !IsTrustedProxy(context) {
+		return nil, nil
+	}
+
+	var groups []string
+	if env.RemoteGroupsHeader != "" {
+		groups = SplitGroups(context.GetHeader(env.RemoteGroupsHeader))
+	}
+
+	return ExternalUser(db, ProxyExternalID(username), username, groups)
+}
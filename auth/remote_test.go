@@ -0,0 +1,33 @@
+package auth
+
+import (
+	"github.com/allape/homesong/env"
+	"github.com/gin-gonic/gin"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIsTrustedProxy(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	env.TrustedProxies = "127.0.0.1/32, ::1/128,10.0.0.0/8,invalid"
+
+	for remoteAddr, expected := range map[string]bool{
+		"127.0.0.1:1234":         true,
+		"[::1]:1234":             true,
+		"[::ffff:10.1.2.3]:1234": true,
+		"10.255.0.1:80":          true,
+		"192.168.1.2:1234":       false,
+		"[2001:db8::1]:1234":     false,
+		"127.0.0.1":              true,
+		"localhost:1234":         false,
+		"":                       false,
+	} {
+		context, _ := gin.CreateTestContext(httptest.NewRecorder())
+		context.Request = httptest.NewRequest("GET", "/", nil)
+		context.Request.RemoteAddr = remoteAddr
+		context.Request.Header.Set("X-Forwarded-For", "127.0.0.1")
+		if actual := IsTrustedProxy(context); actual != expected {
+			t.Fatal("unexpected trust of", remoteAddr, actual)
+		}
+	}
+}
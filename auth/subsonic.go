@@ -0,0 +1,113 @@
+package auth
+
+import (
+	"encoding/hex"
+	"errors"
+	"github.com/allape/homesong/env"
+	"github.com/allape/homesong/model"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrorMissingCredentials    = errors.New("required parameter is missing: u and p, or apiKey")
+	ErrorTokenAuthNotSupported = errors.New("token authentication is not supported, use password, or an api token as password or apiKey")
+	ErrorConflictingAuth       = errors.New("u can not be used with apiKey")
+	ErrorInvalidApiKey         = errors.New("invalid api key")
+)
+
+// passwordCacheTTL keeps verified subsonic passwords in memory, since they come with every request and bcrypt is slow
+const passwordCacheTTL = 10 * time.Minute
+
+type cachedPassword struct {
+	UserID    uint64
+	ExpiresAt time.Time
+}
+
+var passwordCache sync.Map
+
+// ForgetSubsonicPasswords drops verified passwords, it should be called once a password changes
+func ForgetSubsonicPasswords() {
+	passwordCache.Clear()
+}
+
+// SubsonicLogin authenticates a subsonic request with ?apiKey= from OpenSubsonic,
+// or with ?u= and ?p= (plain or "enc:" hex encoded), p being the password or an API token of u.
+// Token authentication with ?t= and ?s= needs the plain password, which is never stored, so it is not supported.
+func SubsonicLogin(db *gorm.DB, context *gin.Context) (*model.User, *model.ApiToken, error) {
+	if !env.EnableAuth {
+		return Anonymous, nil, nil
+	}
+
+	if user, err := RemoteUser(db, context); err != nil || user != nil {
+		return user, nil, err
+	}
+
+	username := context.Request.FormValue("u")
+
+	if apiKey := context.Request.FormValue("apiKey"); apiKey != "" {
+		if username != "" {
+			return nil, nil, ErrorConflictingAuth
+		} else if !IsApiToken(apiKey) {
+			return nil, nil, ErrorInvalidApiKey
+		}
+		user, apiToken, err := FindUserByApiToken(db, apiKey)
+		if errors.Is(err, ErrorInvalidToken) {
+			return nil, nil, ErrorInvalidApiKey
+		}
+		return user, apiToken, err
+	}
+
+	password := context.Request.FormValue("p")
+	if username == "" || password == "" {
+		if context.Request.FormValue("t") != "" {
+			return nil, nil, ErrorTokenAuthNotSupported
+		}
+		return nil, nil, ErrorMissingCredentials
+	}
+
+	if encoded, ok := strings.CutPrefix(password, "enc:"); ok {
+		decoded, err := hex.DecodeString(encoded)
+		if err != nil {
+			return nil, nil, ErrorInvalidCredentials
+		}
+		password = string(decoded)
+	}
+
+	if IsApiToken(password) {
+		user, apiToken, err := FindUserByApiToken(db, password)
+		if errors.Is(err, ErrorInvalidToken) || (err == nil && user.Username != username) {
+			return nil, nil, ErrorInvalidCredentials
+		}
+		return user, apiToken, err
+	}
+
+	key := TokenDigest(username + "\x00" + password)
+	if cached, ok := passwordCache.Load(key); ok && cached.(cachedPassword).ExpiresAt.After(time.Now()) {
+		var user model.User
+		if err := db.Model(&user).Where("username = ? AND deleted_at IS NULL", username).First(&user, cached.(cachedPassword).UserID).Error; err == nil {
+			return &user, nil, nil
+		}
+		passwordCache.Delete(key)
+	}
+
+	user, err := Login(db, username, password)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	passwordCache.Store(key, cachedPassword{UserID: uint64(user.ID), ExpiresAt: time.Now().Add(passwordCacheTTL)})
+
+	return user, nil, nil
+}
+
+// CheckScopes tells whether apiToken is allowed to access current route, nil apiToken is always allowed
+func CheckScopes(context *gin.Context, apiToken *model.ApiToken) error {
+	if apiToken == nil {
+		return nil
+	}
+	return checkScopes(context, apiToken)
+}
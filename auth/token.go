@@ -0,0 +1,118 @@
+package auth
+
+import (
+	"errors"
+	"github.com/allape/homesong/model"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ApiTokenPrefix tells API tokens apart from session tokens
+const ApiTokenPrefix = "hs_"
+
+// lastUsedInterval throttles updates of ApiToken.LastUsedAt, streaming may send lots of range requests
+const lastUsedInterval = time.Minute
+
+func IsApiToken(token string) bool {
+	return strings.HasPrefix(token, ApiTokenPrefix)
+}
+
+// NewApiToken returns a new random token for user, only its digest is saved
+func NewApiToken(db *gorm.DB, user *model.User, name string, scopes []model.TokenScope, expiresAt *time.Time) (string, *model.ApiToken, error) {
+	random, err := NewToken()
+	if err != nil {
+		return "", nil, err
+	}
+	token := ApiTokenPrefix + random
+
+	scopeNames := make([]string, len(scopes))
+	for i, scope := range scopes {
+		scopeNames[i] = string(scope)
+	}
+
+	apiToken := model.ApiToken{
+		UserID:    user.ID,
+		Name:      name,
+		Prefix:    token[:len(ApiTokenPrefix)+6],
+		Digest:    TokenDigest(token),
+		Scopes:    strings.Join(scopeNames, ","),
+		ExpiresAt: expiresAt,
+	}
+	if err := db.Create(&apiToken).Error; err != nil {
+		return "", nil, err
+	}
+
+	return token, &apiToken, nil
+}
+
+func FindUserByApiToken(db *gorm.DB, token string) (*model.User, *model.ApiToken, error) {
+	var apiToken model.ApiToken
+	if err := db.Model(&apiToken).Where("digest = ? AND (expires_at IS NULL OR expires_at > ?)", TokenDigest(token), time.Now()).First(&apiToken).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, ErrorInvalidToken
+		}
+		return nil, nil, err
+	}
+
+	var user model.User
+	if err := db.Model(&user).Where("deleted_at IS NULL").First(&user, apiToken.UserID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, ErrorInvalidToken
+		}
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	if apiToken.LastUsedAt == nil || apiToken.LastUsedAt.Add(lastUsedInterval).Before(now) {
+		if err := db.Model(&apiToken).UpdateColumn("last_used_at", now).Error; err != nil {
+			l.Warn().Printf("Failed to update last used time of token %d: %v", apiToken.ID, err)
+		}
+		apiToken.LastUsedAt = &now
+	}
+
+	return &user, &apiToken, nil
+}
+
+var (
+	routeScopesLocker sync.RWMutex
+	routeScopes       = map[string][]model.TokenScope{}
+)
+
+// AcceptScopes lets API tokens with any of scopes access a route, besides model.TokenScopeAdmin.
+// Routes not registered here require model.TokenScopeRead for GET and HEAD, and model.TokenScopeAdmin for others.
+func AcceptScopes(group *gin.RouterGroup, method, relativePath string, scopes ...model.TokenScope) {
+	routeScopesLocker.Lock()
+	defer routeScopesLocker.Unlock()
+	fullPath := strings.TrimSuffix(group.BasePath(), "/") + relativePath
+	routeScopes[method+" "+fullPath] = scopes
+}
+
+// AcceptedScopes returns the scopes an API token needs one of to access current route
+func AcceptedScopes(context *gin.Context) []model.TokenScope {
+	routeScopesLocker.RLock()
+	scopes, ok := routeScopes[context.Request.Method+" "+context.FullPath()]
+	routeScopesLocker.RUnlock()
+
+	if ok {
+		return append(scopes, model.TokenScopeAdmin)
+	}
+
+	switch context.Request.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return []model.TokenScope{model.TokenScopeRead, model.TokenScopeAdmin}
+	default:
+		return []model.TokenScope{model.TokenScopeAdmin}
+	}
+}
+
+func checkScopes(context *gin.Context, apiToken *model.ApiToken) error {
+	if slices.ContainsFunc(AcceptedScopes(context), apiToken.HasScope) {
+		return nil
+	}
+	return ErrorForbidden
+}
@@ -0,0 +1,55 @@
+package auth
+
+import (
+	"github.com/allape/homesong/model"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCheckScopes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	engine := gin.New()
+	group := engine.Group("/api/play")
+	AcceptScopes(group, http.MethodPut, "", model.TokenScopeStream)
+
+	handler := func(context *gin.Context) {
+		if err := checkScopes(context, &model.ApiToken{Scopes: context.GetHeader("X-Scopes")}); err != nil {
+			context.Status(http.StatusForbidden)
+			return
+		}
+		context.Status(http.StatusOK)
+	}
+	group.GET("", handler)
+	group.PUT("", handler)
+	group.DELETE("/:id", handler)
+
+	for _, c := range []struct {
+		method string
+		path   string
+		scopes string
+		status int
+	}{
+		{http.MethodGet, "/api/play", "read", http.StatusOK},
+		{http.MethodGet, "/api/play", "stream", http.StatusForbidden},
+		{http.MethodGet, "/api/play", "admin", http.StatusOK},
+		{http.MethodPut, "/api/play", "stream", http.StatusOK},
+		{http.MethodPut, "/api/play", "read,stream", http.StatusOK},
+		{http.MethodPut, "/api/play", "read", http.StatusForbidden},
+		{http.MethodPut, "/api/play", "admin", http.StatusOK},
+		{http.MethodDelete, "/api/play/1", "stream", http.StatusForbidden},
+		{http.MethodDelete, "/api/play/1", "read,upload", http.StatusForbidden},
+		{http.MethodDelete, "/api/play/1", "admin", http.StatusOK},
+		{http.MethodGet, "/api/play", "", http.StatusForbidden},
+	} {
+		request := httptest.NewRequest(c.method, c.path, nil)
+		request.Header.Set("X-Scopes", c.scopes)
+		recorder := httptest.NewRecorder()
+		engine.ServeHTTP(recorder, request)
+		if recorder.Code != c.status {
+			t.Fatal("unexpected status of", c.method, c.path, c.scopes, recorder.Code)
+		}
+	}
+}
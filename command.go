@@ -0,0 +1,101 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/allape/homesong/ingest"
+	"github.com/allape/homesong/model"
+	"gorm.io/gorm"
+	"os"
+)
+
+// homesong import [-tags=true] [-log=import.log] <folder>
+func runImportCommand(db *gorm.DB, args []string) {
+	flagSet := flag.NewFlagSet("import", flag.ExitOnError)
+	importTags := flagSet.Bool("tags", true, "import title, artist and album from tags")
+	logFile := flagSet.String("log", "import.log", "progress log, files finished in it are skipped on next run")
+	_ = flagSet.Parse(args)
+
+	folder := flagSet.Arg(0)
+	if folder == "" {
+		_, _ = fmt.Fprintln(os.Stderr, "Usage: homesong import [-tags=true] [-log=import.log] <folder>")
+		flagSet.PrintDefaults()
+		os.Exit(2)
+	}
+
+	report, err := ingest.ImportFolder(db, folder, ingest.ImportConfig{
+		ImportTags: *importTags,
+		LogFile:    *logFile,
+	}, func(record ingest.ImportRecord) {
+		switch record.Status {
+		case ingest.Imported, ingest.Duplicated:
+			fmt.Printf("[%s] %s -> song %d\n", record.Status, record.Path, record.SongID)
+		case ingest.Failed:
+			fmt.Printf("[%s] %s: %s\n", record.Status, record.Path, record.Error)
+		default:
+			fmt.Printf("[%s] %s\n", record.Status, record.Path)
+		}
+	})
+	if err != nil {
+		l.Error().Fatalf("Failed to import %s: %v", folder, err)
+	}
+
+	fmt.Printf(
+		"Imported: %d, Duplicated: %d, Skipped: %d, Failed: %d, Resumed: %d\n",
+		report.Imported, report.Duplicated, report.Skipped, report.Failed, report.Resumed,
+	)
+
+	if report.Failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// homesong fingerprint, computes fingerprints for songs uploaded without them
+func runFingerprintCommand(db *gorm.DB) {
+	var songs []model.Song
+	if err := db.Model(&songs).Where("deleted_at IS NULL AND filename != '' AND id NOT IN (SELECT song_fingerprints.song_id FROM song_fingerprints)").Find(&songs).Error; err != nil {
+		l.Error().Fatalf("Failed to find songs without fingerprint: %v", err)
+	}
+
+	failed := 0
+	for i := range songs {
+		if err := ingest.FillFingerprint(db, &songs[i]); err != nil {
+			failed++
+			fmt.Printf("[failed] song %d: %v\n", songs[i].ID, err)
+		} else {
+			fmt.Printf("[fingerprinted] song %d\n", songs[i].ID)
+		}
+	}
+
+	fmt.Printf("Fingerprinted: %d, Failed: %d\n", len(songs)-failed, failed)
+
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// homesong lyrics, links lyrics embedded in files of songs, which are not linked yet
+func runLyricsCommand(db *gorm.DB) {
+	var songs []model.Song
+	if err := db.Model(&songs).Where("deleted_at IS NULL AND filename != ''").Find(&songs).Error; err != nil {
+		l.Error().Fatalf("Failed to find songs: %v", err)
+	}
+
+	linked, failed := 0, 0
+	for i := range songs {
+		created, err := ingest.LinkEmbeddedLyrics(db, &songs[i])
+		if err != nil {
+			failed++
+			fmt.Printf("[failed] song %d: %v\n", songs[i].ID, err)
+		} else if len(created) > 0 {
+			linked += len(created)
+			fmt.Printf("[linked] song %d: %d lyrics\n", songs[i].ID, len(created))
+		}
+	}
+
+	fmt.Printf("Songs: %d, Linked lyrics: %d, Failed: %d\n", len(songs), linked, failed)
+
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
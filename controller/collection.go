@@ -1,14 +1,19 @@
 package controller
 
 import (
+	"errors"
 	"fmt"
 	"github.com/allape/gocrud"
+	"github.com/allape/homesong/auth"
+	"github.com/allape/homesong/ffmpeg"
+	"github.com/allape/homesong/ingest"
 	"github.com/allape/homesong/model"
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 	"net/http"
 	"net/url"
 	"slices"
+	"strconv"
 	"strings"
 )
 
@@ -28,6 +33,30 @@ func SetupCollectionController(group *gin.RouterGroup, db *gorm.DB) error {
 			"orderBy_index":     gocrud.SortBy("index"),
 			"orderBy_createdAt": gocrud.SortBy("created_at"),
 			"orderBy_updatedAt": gocrud.SortBy("updated_at"),
+			"in_ownerId":        gocrud.KeywordIDIn("owner_id", gocrud.OverflowedArrayTrimmerFilter[gocrud.ID](DefaultPageSize)),
+			"in_visibility":     gocrud.KeywordIn("visibility", nil),
+		},
+		WillPage: func(pageNum *int64, pageSize *int64, context *gin.Context, db *gorm.DB) *gorm.DB {
+			return whereCollectionVisible(db, auth.CurrentUser(context), "collections")
+		},
+		WillCount: func(context *gin.Context, db *gorm.DB) *gorm.DB {
+			return whereCollectionVisible(db, auth.CurrentUser(context), "collections")
+		},
+		DidGetOne: func(record *model.Collection, context *gin.Context, db *gorm.DB) {
+			if !record.VisibleTo(auth.CurrentUser(context)) {
+				gocrud.MakeErrorResponse(context, gocrud.RestCoder.NotFound(), "collection not found")
+				return
+			}
+		},
+		WillDelete: func(context *gin.Context, db *gorm.DB) {
+			var exist model.Collection
+			if err := db.Model(&exist).First(&exist, context.Param("id")).Error; err != nil {
+				gocrud.MakeErrorResponse(context, gocrud.RestCoder.NotFound(), err)
+				return
+			} else if !exist.ManageableBy(auth.CurrentUser(context)) {
+				gocrud.MakeErrorResponse(context, gocrud.RestCoder.FromStatus(http.StatusForbidden), auth.ErrorForbidden)
+				return
+			}
 		},
 		OnDelete: gocrud.NewSoftDeleteHandler[model.Collection](gocrud.RestCoder),
 		WillSave: func(record *model.Collection, context *gin.Context, db *gorm.DB) {
@@ -40,8 +69,70 @@ func SetupCollectionController(group *gin.RouterGroup, db *gorm.DB) error {
 				return
 			}
 
+			user := auth.CurrentUser(context)
+
+			if record.ID == 0 {
+				if record.Type == model.CollectionTypeFavorite || (!record.IsPlaylist() && !user.IsAdmin()) {
+					gocrud.MakeErrorResponse(context, gocrud.RestCoder.FromStatus(http.StatusForbidden), auth.ErrorForbidden)
+					return
+				}
+				record.OwnerID = gocrud.Ternary(record.IsPlaylist(), user.ID, 0)
+			} else {
+				var current model.Collection
+				if err := db.Model(&current).First(&current, record.ID).Error; err != nil {
+					gocrud.MakeErrorResponse(context, gocrud.RestCoder.NotFound(), err)
+					return
+				} else if !current.EditableBy(user) || (current.Type != record.Type && !current.ManageableBy(user)) {
+					gocrud.MakeErrorResponse(context, gocrud.RestCoder.FromStatus(http.StatusForbidden), auth.ErrorForbidden)
+					return
+				}
+				if !current.ManageableBy(user) {
+					record.Visibility = current.Visibility
+				}
+				record.OwnerID = current.OwnerID
+			}
+
+			if record.Type == model.CollectionTypeSmart {
+				rules, err := parseSmartRules(record.Rules)
+				if err != nil {
+					gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), err)
+					return
+				}
+				for _, value := range rules["in_collectionId"] {
+					ids := gocrud.IDsFromCommaSeparatedString(value)
+					visible, err := visibleCollectionIds(db, user, ids)
+					if err != nil {
+						gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
+						return
+					} else if len(visible) != len(ids) {
+						gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), "collection not found")
+						return
+					}
+				}
+				record.Rules = rules.Encode()
+			} else {
+				record.Rules = ""
+			}
+
+			if record.Visibility == "" {
+				record.Visibility = model.VisibilityShared
+			} else if !slices.Contains(model.Visibilities, record.Visibility) {
+				gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), "visibility not found")
+				return
+			}
+
+			existDB := db.Model(&model.Collection{}).Where("`name` = ? AND `type` = ?", record.Name, record.Type)
+			if record.IsPlaylist() {
+				// playlists of different users may share names
+				existDB = existDB.Where("owner_id = ?", record.OwnerID)
+			} else if record.Type == model.CollectionTypeAlbum {
+				// so do albums of different artists
+				record.AlbumArtist = strings.TrimSpace(record.AlbumArtist)
+				existDB = existDB.Where("album_artist = ?", record.AlbumArtist)
+			}
+
 			var exist model.Collection
-			if err := db.Model(&exist).Where("`name` = ? AND `type` = ?", record.Name, record.Type).First(&exist).Error; err == nil && exist.ID != record.ID {
+			if err := existDB.First(&exist).Error; err == nil && exist.ID != record.ID {
 				gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), "name already exists")
 				return
 			}
@@ -52,37 +143,19 @@ func SetupCollectionController(group *gin.RouterGroup, db *gorm.DB) error {
 	}
 
 	// may require a lock
-	group.PUT("/create-or-get/by-artist-names/:names", func(context *gin.Context) {
+	group.PUT("/create-or-get/by-artist-names/:names", auth.AdminOnly(), func(context *gin.Context) {
 		names := gocrud.StringArrayFromCommaSeparatedString(context.Param("names"))
 		if len(names) == 0 {
 			gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), "names not found")
 			return
 		}
 
-		var exists []model.Collection
-		if err := db.Model(&exists).Where("type = 'artist' AND name IN ?", names).Find(&exists).Error; err != nil {
+		exists, err := ingest.CreateOrGetCollections(db, model.CollectionTypeArtist, names)
+		if err != nil {
 			gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
 			return
 		}
 
-	out:
-		for _, name := range names {
-			for _, exist := range exists {
-				if exist.Name == name {
-					continue out
-				}
-			}
-
-			var artist = model.Collection{Type: model.CollectionTypeArtist, Name: name}
-
-			if err := db.Model(&artist).Create(&artist).Error; err != nil {
-				gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
-				return
-			}
-
-			exists = append(exists, artist)
-		}
-
 		context.JSON(http.StatusOK, gocrud.R[[]model.Collection]{Code: gocrud.RestCoder.OK(), Data: exists})
 	})
 
@@ -90,9 +163,22 @@ func SetupCollectionController(group *gin.RouterGroup, db *gorm.DB) error {
 	group.GET("/random/:collectionId", func(context *gin.Context) {
 		collectionId := gocrud.Pick(gocrud.IDsFromCommaSeparatedString(context.Param("collectionId")), 0, 0)
 
+		var collection *model.Collection
+		if collectionId != 0 {
+			var ok bool
+			if collection, ok = findVisibleCollection(context, db, collectionId); !ok {
+				return
+			}
+		}
+
 		var song model.Song
 
-		if collectionId == 0 {
+		if collection != nil && collection.Type == model.CollectionTypeSmart {
+			if err := db.Model(&song).Where("id IN (SELECT id FROM (?) AS smart_songs)", smartSongsDB(db, collection).Select("songs.id")).Order(randomOrder(db)).First(&song).Error; err != nil {
+				gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
+				return
+			}
+		} else if collectionId == 0 {
 			if err := db.Model(&song).Where("songs.deleted_at IS NULL").Order("rand() DESC").First(&song).Error; err != nil {
 				gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
 				return
@@ -111,6 +197,84 @@ func SetupCollectionController(group *gin.RouterGroup, db *gorm.DB) error {
 		context.JSON(http.StatusOK, gocrud.R[model.Song]{Code: gocrud.RestCoder.OK(), Data: song})
 	})
 
+	// ?profile=mp3, POST with ?withToken=true&expiresIn=24 to embed a token
+	for _, format := range []PlaylistFormat{PlaylistFormatM3U8, PlaylistFormatXSPF} {
+		group.GET("/:id/export."+string(format), func(context *gin.Context) {
+			exportCollection(context, db, format)
+		})
+		group.POST("/:id/export."+string(format), func(context *gin.Context) {
+			exportCollection(context, db, format)
+		})
+	}
+
+	// multipart form with file, name and visibility
+	group.PUT("/import", func(context *gin.Context) {
+		importPlaylist(context, db)
+	})
+
+	unmatchedGroup := group.Group("/unmatched")
+
+	unmatchedGroup.GET("/:collectionId", func(context *gin.Context) {
+		collectionId := gocrud.Pick(gocrud.IDsFromCommaSeparatedString(context.Param("collectionId")), 0, 0)
+		collection, ok := findVisibleCollection(context, db, collectionId)
+		if !ok {
+			return
+		}
+
+		var entries []model.UnmatchedEntry
+		if err := db.Model(&entries).Where("collection_id = ?", collection.ID).Order("line, id").Find(&entries).Error; err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
+			return
+		}
+
+		context.JSON(http.StatusOK, gocrud.R[[]model.UnmatchedEntry]{Code: gocrud.RestCoder.OK(), Data: entries})
+	})
+
+	// resolve an entry by adding the song to its playlist
+	unmatchedGroup.PUT("/:id/:songId", func(context *gin.Context) {
+		entry, collection, ok := findUnmatchedEntry(context, db)
+		if !ok {
+			return
+		}
+
+		songId := gocrud.Pick(gocrud.IDsFromCommaSeparatedString(context.Param("songId")), 0, 0)
+		if songId == 0 {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), "songId not found")
+			return
+		}
+
+		collectionSongs, err := addSongsToCollection(db, collection, []gocrud.ID{songId})
+		if err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
+			return
+		} else if len(collectionSongs) == 0 {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.NotFound(), "song not found")
+			return
+		}
+
+		if err := db.Delete(entry).Error; err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
+			return
+		}
+
+		context.JSON(http.StatusOK, gocrud.R[[]model.CollectionSong]{Code: gocrud.RestCoder.OK(), Data: collectionSongs})
+	})
+
+	// dismiss an entry
+	unmatchedGroup.DELETE("/:id", func(context *gin.Context) {
+		entry, _, ok := findUnmatchedEntry(context, db)
+		if !ok {
+			return
+		}
+
+		if err := db.Delete(entry).Error; err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
+			return
+		}
+
+		context.JSON(http.StatusOK, gocrud.R[bool]{Code: gocrud.RestCoder.OK(), Data: true})
+	})
+
 	collectionSongGroup := group.Group("/song")
 	err = gocrud.New(collectionSongGroup, db, gocrud.Crud[model.CollectionSong]{
 		EnableGetAll:  true,
@@ -124,13 +288,198 @@ func SetupCollectionController(group *gin.RouterGroup, db *gorm.DB) error {
 			"in_collectionId": gocrud.KeywordIDIn("collection_id", gocrud.OverflowedArrayTrimmerFilter[gocrud.ID](DefaultPageSize)),
 			"in_role":         gocrud.KeywordIDIn("role", gocrud.OverflowedArrayTrimmerFilter[gocrud.ID](DefaultPageSize)),
 		},
+		WillGetAll: func(context *gin.Context, db *gorm.DB) *gorm.DB {
+			visible := whereCollectionVisible(db.Session(&gorm.Session{NewDB: true}).Model(&model.Collection{}).Select("id"), auth.CurrentUser(context), "collections")
+			return db.Where("collection_id IN (?)", visible).Order("collection_id, position, created_at")
+		},
 	})
 	if err != nil {
 		return err
 	}
 
+	// ?songIds=1,2,3
+	collectionSongGroup.PUT("/add/:collectionId", func(context *gin.Context) {
+		collectionId := gocrud.Pick(gocrud.IDsFromCommaSeparatedString(context.Param("collectionId")), 0, 0)
+		collection, ok := findEditableCollection(context, db, collectionId)
+		if !ok {
+			return
+		}
+
+		collectionSongs, err := addSongsToCollection(db, collection, gocrud.IDsFromCommaSeparatedString(context.Query("songIds")))
+		if err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
+			return
+		}
+
+		context.JSON(http.StatusOK, gocrud.R[[]model.CollectionSong]{Code: gocrud.RestCoder.OK(), Data: collectionSongs})
+	})
+
+	// ?songIds=1,2,3
+	collectionSongGroup.DELETE("/remove/:collectionId", func(context *gin.Context) {
+		collectionId := gocrud.Pick(gocrud.IDsFromCommaSeparatedString(context.Param("collectionId")), 0, 0)
+		collection, ok := findEditableCollection(context, db, collectionId)
+		if !ok {
+			return
+		}
+
+		songIds := gocrud.IDsFromCommaSeparatedString(context.Query("songIds"))
+		if len(songIds) == 0 {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), "songIds not found")
+			return
+		}
+
+		res := db.Where("collection_id = ? AND song_id IN ?", collection.ID, songIds).Delete(&model.CollectionSong{})
+		if res.Error != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), res.Error)
+			return
+		}
+
+		context.JSON(http.StatusOK, gocrud.R[bool]{Code: gocrud.RestCoder.OK(), Data: res.RowsAffected > 0})
+	})
+
+	// ?songIds=3,1,2, listed songs are moved to the beginning in that order, others keep their order after them
+	collectionSongGroup.PUT("/reorder/:collectionId", func(context *gin.Context) {
+		collectionId := gocrud.Pick(gocrud.IDsFromCommaSeparatedString(context.Param("collectionId")), 0, 0)
+		collection, ok := findEditableCollection(context, db, collectionId)
+		if !ok {
+			return
+		}
+
+		collectionSongs, err := findCollectionSongs(db, collection.ID)
+		if err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
+			return
+		}
+
+		songIds := collectionSongIds(collectionSongs)
+
+		var ordered []gocrud.ID
+		for _, songId := range gocrud.IDsFromCommaSeparatedString(context.Query("songIds")) {
+			if slices.Contains(songIds, songId) && !slices.Contains(ordered, songId) {
+				ordered = append(ordered, songId)
+			}
+		}
+		if len(ordered) == 0 {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), "songIds not found")
+			return
+		}
+
+		collectionSongs, err = saveCollectionOrder(db, collection.ID, collectionSongs, insertSongIds(songIds, ordered, 1))
+		if err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
+			return
+		}
+
+		context.JSON(http.StatusOK, gocrud.R[[]model.CollectionSong]{Code: gocrud.RestCoder.OK(), Data: collectionSongs})
+	})
+
+	// ?songIds=4,5&position=2, songs are added or moved before the 1 based position, or to the end without position
+	collectionSongGroup.PUT("/insert/:collectionId", func(context *gin.Context) {
+		collectionId := gocrud.Pick(gocrud.IDsFromCommaSeparatedString(context.Param("collectionId")), 0, 0)
+		collection, ok := findEditableCollection(context, db, collectionId)
+		if !ok {
+			return
+		}
+
+		inserting := gocrud.IDsFromCommaSeparatedString(context.Query("songIds"))
+		added, err := addSongsToCollection(db, collection, inserting)
+		if err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
+			return
+		} else if len(added) == 0 {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), "songIds not found")
+			return
+		}
+
+		collectionSongs, err := findCollectionSongs(db, collection.ID)
+		if err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
+			return
+		}
+
+		position, _ := strconv.Atoi(context.Query("position"))
+		addedIds := collectionSongIds(added)
+		var moving []gocrud.ID
+		for _, songId := range inserting {
+			if slices.Contains(addedIds, songId) && !slices.Contains(moving, songId) {
+				moving = append(moving, songId)
+			}
+		}
+		songIds := insertSongIds(collectionSongIds(collectionSongs), moving, position)
+
+		collectionSongs, err = saveCollectionOrder(db, collection.ID, collectionSongs, songIds)
+		if err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
+			return
+		}
+
+		context.JSON(http.StatusOK, gocrud.R[[]model.CollectionSong]{Code: gocrud.RestCoder.OK(), Data: collectionSongs})
+	})
+
+	// ?from=3&count=2&to=1, moves count songs starting at the 1 based position from, so that the first of them ends up at to
+	collectionSongGroup.PUT("/move/:collectionId", func(context *gin.Context) {
+		collectionId := gocrud.Pick(gocrud.IDsFromCommaSeparatedString(context.Param("collectionId")), 0, 0)
+		collection, ok := findEditableCollection(context, db, collectionId)
+		if !ok {
+			return
+		}
+
+		collectionSongs, err := findCollectionSongs(db, collection.ID)
+		if err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
+			return
+		}
+
+		songIds := collectionSongIds(collectionSongs)
+
+		from, _ := strconv.Atoi(context.Query("from"))
+		to, _ := strconv.Atoi(context.Query("to"))
+		count, err := strconv.Atoi(context.DefaultQuery("count", "1"))
+		if err != nil || count < 1 || from < 1 || from > len(songIds) || to < 1 {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), "invalid from, count or to")
+			return
+		}
+
+		moving := slices.Clone(songIds[from-1 : min(from-1+count, len(songIds))])
+
+		collectionSongs, err = saveCollectionOrder(db, collection.ID, collectionSongs, insertSongIds(songIds, moving, to))
+		if err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
+			return
+		}
+
+		context.JSON(http.StatusOK, gocrud.R[[]model.CollectionSong]{Code: gocrud.RestCoder.OK(), Data: collectionSongs})
+	})
+
+	// ?disc=1&track=3, for songs of albums
+	collectionSongGroup.PUT("/track/:collectionId/:songId", func(context *gin.Context) {
+		collectionId := gocrud.Pick(gocrud.IDsFromCommaSeparatedString(context.Param("collectionId")), 0, 0)
+		collection, ok := findEditableCollection(context, db, collectionId)
+		if !ok {
+			return
+		}
+
+		songId := gocrud.Pick(gocrud.IDsFromCommaSeparatedString(context.Param("songId")), 0, 0)
+		disc, err1 := strconv.ParseInt(context.DefaultQuery("disc", "0"), 10, 32)
+		track, err2 := strconv.ParseInt(context.DefaultQuery("track", "0"), 10, 32)
+		if err1 != nil || err2 != nil || disc < 0 || track < 0 {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), "invalid disc or track")
+			return
+		}
+
+		res := db.Model(&model.CollectionSong{}).
+			Where("collection_id = ? AND song_id = ?", collection.ID, songId).
+			UpdateColumns(map[string]any{"disc": disc, "track": track})
+		if res.Error != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), res.Error)
+			return
+		}
+
+		context.JSON(http.StatusOK, gocrud.R[bool]{Code: gocrud.RestCoder.OK(), Data: res.RowsAffected > 0})
+	})
+
 	// ?collectionIds=
-	collectionSongGroup.PUT("/save-by-song/:songId/:role", func(context *gin.Context) {
+	collectionSongGroup.PUT("/save-by-song/:songId/:role", auth.AdminOnly(), func(context *gin.Context) {
 		songId := gocrud.Pick[gocrud.ID](gocrud.IDsFromCommaSeparatedString(context.Param("songId")), 0, 0)
 		if songId == 0 {
 			gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), "songId not found")
@@ -169,10 +518,16 @@ func SetupCollectionController(group *gin.RouterGroup, db *gorm.DB) error {
 		collectionSongs := make([]model.CollectionSong, len(collections))
 		if len(collections) > 0 {
 			for i, collection := range collections {
+				position, err := collectionSongPosition(db, collection.ID, song.ID)
+				if err != nil {
+					gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
+					return
+				}
 				collectionSongs[i] = model.CollectionSong{
 					SongID:       song.ID,
 					CollectionID: collection.ID,
 					Role:         role,
+					Position:     position,
 				}
 			}
 
@@ -185,5 +540,366 @@ func SetupCollectionController(group *gin.RouterGroup, db *gorm.DB) error {
 		context.JSON(http.StatusOK, gocrud.R[[]model.CollectionSong]{Code: gocrud.RestCoder.OK(), Data: collectionSongs})
 	})
 
+	group.GET("/favorite", func(context *gin.Context) {
+		favorite, err := getOrCreateFavorite(db, auth.CurrentUser(context))
+		if err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
+			return
+		}
+		context.JSON(http.StatusOK, gocrud.R[model.Collection]{Code: gocrud.RestCoder.OK(), Data: *favorite})
+	})
+
+	group.PUT("/favorite/:songId", func(context *gin.Context) {
+		favorite, err := getOrCreateFavorite(db, auth.CurrentUser(context))
+		if err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
+			return
+		}
+
+		collectionSongs, err := addSongsToCollection(db, favorite, gocrud.IDsFromCommaSeparatedString(context.Param("songId")))
+		if err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
+			return
+		}
+
+		context.JSON(http.StatusOK, gocrud.R[[]model.CollectionSong]{Code: gocrud.RestCoder.OK(), Data: collectionSongs})
+	})
+
+	group.DELETE("/favorite/:songId", func(context *gin.Context) {
+		favorite, err := getOrCreateFavorite(db, auth.CurrentUser(context))
+		if err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
+			return
+		}
+
+		songIds := gocrud.IDsFromCommaSeparatedString(context.Param("songId"))
+		if len(songIds) == 0 {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), "songId not found")
+			return
+		}
+
+		res := db.Where("collection_id = ? AND song_id IN ?", favorite.ID, songIds).Delete(&model.CollectionSong{})
+		if res.Error != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), res.Error)
+			return
+		}
+
+		context.JSON(http.StatusOK, gocrud.R[bool]{Code: gocrud.RestCoder.OK(), Data: res.RowsAffected > 0})
+	})
+
+	return nil
+}
+
+// whereCollectionVisible filters out private collections of other users, table is the name or alias of collections
+func whereCollectionVisible(db *gorm.DB, user *model.User, table string) *gorm.DB {
+	if user.IsAdmin() {
+		return db
+	}
+	return db.Where(
+		fmt.Sprintf("(%[1]s.owner_id = 0 OR %[1]s.owner_id = ? OR %[1]s.visibility != ?)", table),
+		user.ID, model.VisibilityPrivate,
+	)
+}
+
+// visibleCollectionIds keeps ids of collections visible to user, in their original order
+func visibleCollectionIds(db *gorm.DB, user *model.User, ids []gocrud.ID) ([]gocrud.ID, error) {
+	var visible []gocrud.ID
+	if len(ids) == 0 {
+		return visible, nil
+	}
+	if err := whereCollectionVisible(db.Model(&model.Collection{}).Where("id IN ?", ids), user, "collections").Pluck("id", &visible).Error; err != nil {
+		return nil, err
+	}
+	return slices.DeleteFunc(slices.Clone(ids), func(id gocrud.ID) bool {
+		return !slices.Contains(visible, id)
+	}), nil
+}
+
+// onlyVisibleCollections narrows in_collectionId of the query down to collections visible to current user,
+// responds not found if none of them is visible, rather than dropping the condition
+func onlyVisibleCollections(db *gorm.DB) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		query := context.Request.URL.Query()
+		values, ok := query["in_collectionId"]
+		if !ok {
+			context.Next()
+			return
+		}
+
+		for i, value := range values {
+			ids := gocrud.IDsFromCommaSeparatedString(value)
+			if len(ids) == 0 {
+				continue
+			}
+			visible, err := visibleCollectionIds(db, auth.CurrentUser(context), ids)
+			if err != nil {
+				gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
+				context.Abort()
+				return
+			} else if len(visible) == 0 {
+				gocrud.MakeErrorResponse(context, gocrud.RestCoder.NotFound(), "collection not found")
+				context.Abort()
+				return
+			}
+			values[i] = joinIds(visible)
+		}
+
+		context.Request.URL.RawQuery = query.Encode()
+		context.Next()
+	}
+}
+
+func joinIds(ids []gocrud.ID) string {
+	values := make([]string, len(ids))
+	for i, id := range ids {
+		values[i] = strconv.FormatUint(uint64(id), 10)
+	}
+	return strings.Join(values, ",")
+}
+
+// findVisibleCollection makes an error response and returns false if the collection is not visible to current user
+func findVisibleCollection(context *gin.Context, db *gorm.DB, id gocrud.ID) (*model.Collection, bool) {
+	if id == 0 {
+		gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), "collectionId not found")
+		return nil, false
+	}
+
+	var collection model.Collection
+	if err := db.Model(&collection).Where("deleted_at IS NULL").First(&collection, id).Error; err != nil {
+		gocrud.MakeErrorResponse(context, gocrud.RestCoder.NotFound(), err)
+		return nil, false
+	} else if !collection.VisibleTo(auth.CurrentUser(context)) {
+		gocrud.MakeErrorResponse(context, gocrud.RestCoder.NotFound(), "collection not found")
+		return nil, false
+	}
+
+	return &collection, true
+}
+
+// findEditableCollection works like findVisibleCollection, and also checks whether current user can edit its songs
+func findEditableCollection(context *gin.Context, db *gorm.DB, id gocrud.ID) (*model.Collection, bool) {
+	collection, ok := findVisibleCollection(context, db, id)
+	if !ok {
+		return nil, false
+	} else if !collection.EditableBy(auth.CurrentUser(context)) {
+		gocrud.MakeErrorResponse(context, gocrud.RestCoder.FromStatus(http.StatusForbidden), auth.ErrorForbidden)
+		return nil, false
+	} else if collection.Type == model.CollectionTypeSmart {
+		gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), "songs of smart playlists follow their rules")
+		return nil, false
+	}
+	return collection, true
+}
+
+// addSongsToCollection appends songs to collection with model.Reserved role, songs already in it are kept as is
+func addSongsToCollection(db *gorm.DB, collection *model.Collection, songIds []gocrud.ID) ([]model.CollectionSong, error) {
+	var exists []model.CollectionSong
+	if err := db.Model(&exists).Where("collection_id = ? AND song_id IN ?", collection.ID, songIds).Find(&exists).Error; err != nil {
+		return nil, err
+	}
+
+	var songs []model.Song
+	if err := db.Model(&songs).Where("id IN ? AND deleted_at IS NULL", songIds).Find(&songs).Error; err != nil {
+		return nil, err
+	}
+
+	position, err := ingest.NextPosition(db, collection.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	var collectionSongs []model.CollectionSong
+	for _, songId := range songIds {
+		if !slices.ContainsFunc(songs, func(song model.Song) bool {
+			return song.ID == songId
+		}) || slices.ContainsFunc(exists, func(exist model.CollectionSong) bool {
+			return exist.SongID == songId
+		}) || slices.ContainsFunc(collectionSongs, func(collectionSong model.CollectionSong) bool {
+			return collectionSong.SongID == songId
+		}) {
+			continue
+		}
+		collectionSongs = append(collectionSongs, model.CollectionSong{
+			SongID:       songId,
+			CollectionID: collection.ID,
+			Role:         model.Reserved,
+			Position:     position + int32(len(collectionSongs)),
+		})
+	}
+
+	if len(collectionSongs) > 0 {
+		if err := db.Create(&collectionSongs).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	return append(exists, collectionSongs...), nil
+}
+
+// getOrCreateFavorite returns the favorite collection of user, and creates it for the first time
+func getOrCreateFavorite(db *gorm.DB, user *model.User) (*model.Collection, error) {
+	var favorite model.Collection
+	err := db.Model(&favorite).Where("type = ? AND owner_id = ? AND deleted_at IS NULL", model.CollectionTypeFavorite, user.ID).First(&favorite).Error
+	if err == nil {
+		return &favorite, nil
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	favorite = model.Collection{
+		Type:       model.CollectionTypeFavorite,
+		Name:       "Favorites",
+		OwnerID:    user.ID,
+		Visibility: model.VisibilityPrivate,
+	}
+	if err := db.Create(&favorite).Error; err != nil {
+		return nil, err
+	}
+
+	return &favorite, nil
+}
+
+// collectionSongPosition returns the position of a song in a collection with another role, or the position after the last song
+func collectionSongPosition(db *gorm.DB, collectionId, songId gocrud.ID) (int32, error) {
+	var exist model.CollectionSong
+	err := db.Model(&exist).Where("collection_id = ? AND song_id = ? AND position > 0", collectionId, songId).First(&exist).Error
+	if err == nil {
+		return exist.Position, nil
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, err
+	}
+	return ingest.NextPosition(db, collectionId)
+}
+
+// findCollectionSongs returns songs of a collection by position, roles of the same song are next to each other
+func findCollectionSongs(db *gorm.DB, collectionId gocrud.ID) ([]model.CollectionSong, error) {
+	var collectionSongs []model.CollectionSong
+	err := db.Model(&collectionSongs).
+		Where("collection_id = ?", collectionId).
+		Order("position, created_at, song_id").
+		Find(&collectionSongs).Error
+	return collectionSongs, err
+}
+
+// collectionSongIds returns distinct song ids of collectionSongs in order
+func collectionSongIds(collectionSongs []model.CollectionSong) []gocrud.ID {
+	var songIds []gocrud.ID
+	for _, collectionSong := range collectionSongs {
+		if !slices.Contains(songIds, collectionSong.SongID) {
+			songIds = append(songIds, collectionSong.SongID)
+		}
+	}
+	return songIds
+}
+
+// saveCollectionOrder renumbers songs of a collection from 1 in the order of songIds, and returns them in the new order
+func saveCollectionOrder(db *gorm.DB, collectionId gocrud.ID, collectionSongs []model.CollectionSong, songIds []gocrud.ID) ([]model.CollectionSong, error) {
+	err := db.Transaction(func(tx *gorm.DB) error {
+		for i, songId := range songIds {
+			position := int32(i + 1)
+			if !slices.ContainsFunc(collectionSongs, func(collectionSong model.CollectionSong) bool {
+				return collectionSong.SongID == songId && collectionSong.Position != position
+			}) {
+				continue
+			}
+			err := tx.Model(&model.CollectionSong{}).
+				Where("collection_id = ? AND song_id = ?", collectionId, songId).
+				UpdateColumn("position", position).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return findCollectionSongs(db, collectionId)
+}
+
+// insertSongIds moves inserting into songIds before the 1 based position, or to the end if position is out of range
+func insertSongIds(songIds []gocrud.ID, inserting []gocrud.ID, position int) []gocrud.ID {
+	rest := slices.DeleteFunc(slices.Clone(songIds), func(songId gocrud.ID) bool {
+		return slices.Contains(inserting, songId)
+	})
+	index := position - 1
+	if index < 0 || index > len(rest) {
+		index = len(rest)
+	}
+	return slices.Insert(rest, index, inserting...)
+}
+
+// collectionSongRow is a song of a collection with columns of the song needed for sorting
+type collectionSongRow struct {
+	model.CollectionSong
+	Index       int32
+	FFProbeInfo string
+}
+
+// BackfillCollectionPositions numbers songs of collections created before positions existed,
+// albums by disc and track numbers from tags then Song.Index, others by the time songs were added
+func BackfillCollectionPositions(db *gorm.DB) error {
+	var collectionIds []gocrud.ID
+	if err := db.Model(&model.CollectionSong{}).Where("position = 0").Distinct().Pluck("collection_id", &collectionIds).Error; err != nil {
+		return err
+	}
+
+	for _, collectionId := range collectionIds {
+		var collection model.Collection
+		if err := db.Model(&collection).First(&collection, collectionId).Error; err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		var rows []collectionSongRow
+		err := db.Table("collection_songs").
+			Select("collection_songs.*, songs.`index`, songs.ff_probe_info").
+			Joins("LEFT JOIN songs ON songs.id = collection_songs.song_id").
+			Where("collection_songs.collection_id = ?", collectionId).
+			Order("collection_songs.position = 0, collection_songs.position, collection_songs.created_at, collection_songs.song_id").
+			Scan(&rows).Error
+		if err != nil {
+			return err
+		}
+
+		if collection.Type == model.CollectionTypeAlbum {
+			for i, row := range rows {
+				if row.Track > 0 || row.FFProbeInfo == "" {
+					continue
+				}
+				if ffprobe, err := ffmpeg.ParseFFProbeJson(row.FFProbeInfo); err == nil {
+					tags := ffprobe.SongTags()
+					rows[i].Disc, rows[i].Track = int32(tags.Disc), int32(tags.Track)
+				}
+			}
+			slices.SortStableFunc(rows, func(a, b collectionSongRow) int {
+				if a.Disc != b.Disc {
+					return int(a.Disc - b.Disc)
+				}
+				return int(gocrud.Ternary(a.Track > 0, a.Track, a.Index) - gocrud.Ternary(b.Track > 0, b.Track, b.Index))
+			})
+		}
+
+		err = db.Transaction(func(tx *gorm.DB) error {
+			positions := map[gocrud.ID]int32{}
+			for _, row := range rows {
+				position, ok := positions[row.SongID]
+				if !ok {
+					position = int32(len(positions) + 1)
+					positions[row.SongID] = position
+				}
+				err := tx.Model(&model.CollectionSong{}).
+					Where("collection_id = ? AND song_id = ? AND role = ?", row.CollectionID, row.SongID, row.Role).
+					UpdateColumns(map[string]any{"position": position, "disc": row.Disc, "track": row.Track}).Error
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
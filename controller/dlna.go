@@ -0,0 +1,626 @@
+package controller
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"github.com/allape/gocrud"
+	"github.com/allape/homesong/asset"
+	"github.com/allape/homesong/auth"
+	"github.com/allape/homesong/dlna"
+	"github.com/allape/homesong/env"
+	"github.com/allape/homesong/ffmpeg"
+	"github.com/allape/homesong/model"
+	"github.com/allape/homesong/subsonic"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"net"
+	"net/http"
+	"net/netip"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// containers above collections, collections and songs use ids made by subsonic.NewID
+const (
+	dlnaRootID      = "0"
+	dlnaArtistsID   = "artists"
+	dlnaAlbumsID    = "albums"
+	dlnaPlaylistsID = "playlists"
+	dlnaSongsID     = "songs"
+)
+
+var dlnaFolders = []struct {
+	ID    string
+	Title string
+}{
+	{dlnaArtistsID, "Artists"},
+	{dlnaAlbumsID, "Albums"},
+	{dlnaPlaylistsID, "Playlists"},
+	{dlnaSongsID, "Songs"},
+}
+
+var dlnaCollectionFolders = map[model.CollectionType]string{
+	model.CollectionTypeArtist: dlnaArtistsID,
+	model.CollectionTypeAlbum:  dlnaAlbumsID,
+	model.CollectionTypeSong:   dlnaPlaylistsID,
+	model.CollectionTypeSmart:  dlnaPlaylistsID,
+}
+
+var dlnaContainerClasses = map[model.CollectionType]string{
+	model.CollectionTypeArtist: dlna.ClassMusicArtist,
+	model.CollectionTypeAlbum:  dlna.ClassMusicAlbum,
+	model.CollectionTypeSong:   dlna.ClassPlaylist,
+	model.CollectionTypeSmart:  dlna.ClassPlaylist,
+}
+
+// dlnaSourceProtocols are what GetProtocolInfo reports, the files may have any of them
+var dlnaSourceProtocols = []string{
+	"http-get:*:audio/mpeg:*",
+	"http-get:*:audio/flac:*",
+	"http-get:*:audio/x-flac:*",
+	"http-get:*:audio/mp4:*",
+	"http-get:*:audio/aac:*",
+	"http-get:*:audio/ogg:*",
+	"http-get:*:audio/wav:*",
+	"http-get:*:audio/x-wav:*",
+	"http-get:*:image/jpeg:*",
+	"http-get:*:image/png:*",
+}
+
+// dlnaGuest browses when auth is enabled and HOME_SONG_DLNA_USERNAME is empty
+var dlnaGuest = &model.User{Username: "dlna", Role: model.UserRoleListener}
+
+// dlnaUUID is the unique device name of this server
+var dlnaUUID = gocrud.Ternary(env.DLNAUUID != "", env.DLNAUUID, dlna.NewUUID(env.DLNAFriendlyName))
+
+type dlnaError struct {
+	Code        int
+	Description string
+}
+
+func (e *dlnaError) Error() string {
+	return e.Description
+}
+
+// isLANAddr tells whether remoteAddr comes from a private network, as renderers can not log in
+func isLANAddr(remoteAddr string) bool {
+	addrPort, err := netip.ParseAddrPort(remoteAddr)
+	if err != nil {
+		return false
+	}
+	addr := addrPort.Addr().Unmap()
+	return addr.IsLoopback() || addr.IsPrivate() || addr.IsLinkLocalUnicast()
+}
+
+// isProxied tells whether the request is forwarded by a reverse proxy, whose peer address says nothing about the client.
+// Renderers connect directly, and proxies are not expected in front of the own listener of DLNA.
+func isProxied(context *gin.Context) bool {
+	for _, header := range []string{"X-Forwarded-For", "X-Real-Ip", "Forwarded"} {
+		if context.GetHeader(header) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func dlnaAuthenticate(db *gorm.DB) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		if !isLANAddr(context.Request.RemoteAddr) || isProxied(context) {
+			context.AbortWithStatus(http.StatusForbidden)
+			return
+		}
+
+		if !env.EnableAuth {
+			context.Next()
+			return
+		}
+
+		user := dlnaGuest
+		if env.DLNAUsername != "" {
+			user = &model.User{}
+			if err := db.Model(user).Where("username = ? AND deleted_at IS NULL", env.DLNAUsername).First(user).Error; err != nil {
+				l.Warn().Printf("Failed to find dlna user %s: %v", env.DLNAUsername, err)
+				context.AbortWithStatus(http.StatusForbidden)
+				return
+			}
+		}
+
+		context.Set(auth.UserKey, user)
+		context.Next()
+	}
+}
+
+// NewSSDPServer announces the device description under basePath
+func NewSSDPServer(basePath string) *dlna.SSDPServer {
+	_, port, err := net.SplitHostPort(env.DLNABindAddr)
+	if err != nil || port == "" {
+		port = "80"
+	}
+
+	return &dlna.SSDPServer{
+		UUID: dlnaUUID,
+		Location: func(ip net.IP) string {
+			if env.DLNABaseURL != "" {
+				return strings.TrimSuffix(env.DLNABaseURL, "/") + basePath + dlna.DescriptionPath
+			}
+			return "http://" + net.JoinHostPort(ip.String(), port) + basePath + dlna.DescriptionPath
+		},
+	}
+}
+
+// dlnaSystemUpdateID changes when songs or collections change, so renderers know their caches are outdated
+func dlnaSystemUpdateID(db *gorm.DB) uint32 {
+	var (
+		song       model.Song
+		collection model.Collection
+	)
+	db.Model(&song).Select("updated_at").Order("updated_at DESC").Limit(1).Find(&song)
+	db.Model(&collection).Select("updated_at").Order("updated_at DESC").Limit(1).Find(&collection)
+	return uint32(max(song.UpdatedAt.Unix(), collection.UpdatedAt.Unix()))
+}
+
+// dlnaStreamHeaders are set as is, since some renderers match header names case-sensitively
+func dlnaStreamHeaders(context *gin.Context, protocolInfo string) {
+	header := context.Writer.Header()
+	header["transferMode.dlna.org"] = []string{"Streaming"}
+	header["contentFeatures.dlna.org"] = []string{dlna.ContentFeatures(protocolInfo)}
+}
+
+// dlnaPage cuts a page of StartingIndex and RequestedCount out of a total, 0 RequestedCount means all
+func dlnaPage(total, start, count int) (int, int) {
+	start = min(start, total)
+	end := total
+	if count > 0 {
+		end = min(start+count, total)
+	}
+	return start, end
+}
+
+func dlnaCollectionContainer(collection model.Collection, stats map[gocrud.ID]collectionStat, baseURL string) dlna.Container {
+	container := dlna.Container{
+		Object: dlna.Object{
+			ID:         collectionID(&collection),
+			ParentID:   dlnaCollectionFolders[collection.Type],
+			Restricted: 1,
+			Title:      collection.Name,
+			Class:      dlnaContainerClasses[collection.Type],
+		},
+		ChildCount: stats[collection.ID].SongCount,
+	}
+	if collection.Type == model.CollectionTypeAlbum && collection.Cover != "" {
+		container.AlbumArtURI = baseURL + "/cover/" + container.ID
+	}
+	return container
+}
+
+func dlnaSongItems(db *gorm.DB, songs []model.Song, parentID, baseURL string) ([]dlna.Item, error) {
+	items := make([]dlna.Item, 0, len(songs))
+	if len(songs) == 0 {
+		return items, nil
+	}
+
+	songIds := make([]gocrud.ID, len(songs))
+	for i, song := range songs {
+		songIds[i] = song.ID
+	}
+
+	songCollections, err := findSongCollections(db, songIds)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, song := range songs {
+		item := dlna.Item{
+			Object: dlna.Object{
+				ID:          subsonic.SongID(song.ID),
+				ParentID:    parentID,
+				Restricted:  1,
+				Title:       song.Name,
+				Class:       dlna.ClassMusicTrack,
+				TrackNumber: int(song.Index),
+			},
+		}
+
+		artist, album := artistAndAlbumOf(songCollections, song.ID)
+		if album != nil {
+			item.Album = album.Name
+			if album.Track > 0 {
+				item.TrackNumber = int(album.Track)
+			}
+		}
+		if artist != nil {
+			item.Artist = artist.Name
+			item.Creator = artist.Name
+		}
+
+		if song.Cover != "" {
+			item.AlbumArtURI = baseURL + "/cover/" + item.ID
+		}
+
+		ext := path.Ext(song.Filename)
+		item.Res = append(item.Res, dlna.Res{
+			ProtocolInfo:    dlna.FileProtocolInfo(song.MIME),
+			Duration:        dlna.FormatDuration(song.Duration),
+			Bitrate:         song.BitRate / 8,
+			SampleFrequency: int64(song.SampleRate),
+			NrAudioChannels: int64(song.Channels),
+			URL:             baseURL + "/media/" + strconv.FormatUint(uint64(song.ID), 10) + ext,
+		})
+
+		// renderers which can not play the original file pick the mp3 one
+		if song.Codec != "mp3" {
+			profile := ffmpeg.Profiles[ffmpeg.DefaultProfile]
+			item.Res = append(item.Res, dlna.Res{
+				ProtocolInfo: dlna.TranscodeProtocolInfo(profile.MIME),
+				Duration:     dlna.FormatDuration(song.Duration),
+				URL:          baseURL + "/hotwire/" + strconv.FormatUint(uint64(song.ID), 10) + profile.Ext,
+			})
+		}
+
+		items = append(items, item)
+	}
+
+	return items, nil
+}
+
+// dlnaBrowse returns the DIDL-Lite result and TotalMatches of Browse
+func dlnaBrowse(db *gorm.DB, user *model.User, baseURL, objectID, flag string, start, count int) (*dlna.DIDLLite, int, error) {
+	didl := dlna.NewDIDLLite()
+	metadata := flag == "BrowseMetadata"
+	if !metadata && flag != "BrowseDirectChildren" {
+		return nil, 0, &dlnaError{dlna.ErrorInvalidArgs, "invalid BrowseFlag: " + flag}
+	}
+
+	collectionDB := func(collectionTypes ...model.CollectionType) *gorm.DB {
+		collectionDB := db.Model(&model.Collection{}).Where("collections.type IN ? AND collections.deleted_at IS NULL", collectionTypes)
+		if slices.Contains(collectionTypes, model.CollectionTypeSong) {
+			collectionDB = whereCollectionVisible(collectionDB, user, "collections")
+		}
+		return collectionDB
+	}
+	songDB := func() *gorm.DB {
+		return db.Model(&model.Song{}).Where("deleted_at IS NULL AND filename != ''")
+	}
+
+	folderCount := func(id string) (int, error) {
+		var count int64
+		var err error
+		switch id {
+		case dlnaArtistsID:
+			err = collectionDB(model.CollectionTypeArtist).Count(&count).Error
+		case dlnaAlbumsID:
+			err = collectionDB(model.CollectionTypeAlbum).Count(&count).Error
+		case dlnaPlaylistsID:
+			err = collectionDB(collectionKinds[subsonic.KindPlaylist]...).Count(&count).Error
+		case dlnaSongsID:
+			err = songDB().Count(&count).Error
+		}
+		return int(count), err
+	}
+
+	if objectID == dlnaRootID {
+		if metadata {
+			didl.Containers = append(didl.Containers, dlna.Container{
+				Object:     dlna.Object{ID: dlnaRootID, ParentID: "-1", Restricted: 1, Title: env.DLNAFriendlyName, Class: dlna.ClassStorageFolder},
+				ChildCount: len(dlnaFolders),
+			})
+			return didl, 1, nil
+		}
+
+		from, to := dlnaPage(len(dlnaFolders), start, count)
+		for _, folder := range dlnaFolders[from:to] {
+			childCount, err := folderCount(folder.ID)
+			if err != nil {
+				return nil, 0, err
+			}
+			didl.Containers = append(didl.Containers, dlna.Container{
+				Object:     dlna.Object{ID: folder.ID, ParentID: dlnaRootID, Restricted: 1, Title: folder.Title, Class: dlna.ClassStorageFolder},
+				ChildCount: childCount,
+			})
+		}
+		return didl, len(dlnaFolders), nil
+	}
+
+	for _, folder := range dlnaFolders {
+		if folder.ID != objectID {
+			continue
+		}
+
+		total, err := folderCount(folder.ID)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		if metadata {
+			didl.Containers = append(didl.Containers, dlna.Container{
+				Object:     dlna.Object{ID: folder.ID, ParentID: dlnaRootID, Restricted: 1, Title: folder.Title, Class: dlna.ClassStorageFolder},
+				ChildCount: total,
+			})
+			return didl, 1, nil
+		}
+
+		from, to := dlnaPage(total, start, count)
+		if from == to {
+			return didl, total, nil
+		}
+
+		if folder.ID == dlnaSongsID {
+			var songs []model.Song
+			if err := songDB().Order("name, id").Offset(from).Limit(to - from).Find(&songs).Error; err != nil {
+				return nil, 0, err
+			}
+			if didl.Items, err = dlnaSongItems(db, songs, folder.ID, baseURL); err != nil {
+				return nil, 0, err
+			}
+			return didl, total, nil
+		}
+
+		var collectionTypes []model.CollectionType
+		for t, id := range dlnaCollectionFolders {
+			if id == folder.ID {
+				collectionTypes = append(collectionTypes, t)
+			}
+		}
+
+		var collections []model.Collection
+		if err := collectionDB(collectionTypes...).Order("collections.name, collections.id").Offset(from).Limit(to - from).Find(&collections).Error; err != nil {
+			return nil, 0, err
+		}
+
+		collectionIds := make([]gocrud.ID, len(collections))
+		for i, collection := range collections {
+			collectionIds[i] = collection.ID
+		}
+		stats, err := findCollectionStats(db, collectionIds)
+		if err != nil {
+			return nil, 0, err
+		}
+		if err := fillSmartStats(db, collections, stats); err != nil {
+			return nil, 0, err
+		}
+
+		for _, collection := range collections {
+			didl.Containers = append(didl.Containers, dlnaCollectionContainer(collection, stats, baseURL))
+		}
+		return didl, total, nil
+	}
+
+	kind, id := subsonic.ParseID(objectID)
+	if kind == subsonic.KindSong {
+		song, err := findPlayableSong(db, id)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, 0, &dlnaError{dlna.ErrorNoSuchObject, "no such object: " + objectID}
+		} else if err != nil {
+			return nil, 0, err
+		}
+		if !metadata {
+			return didl, 0, nil
+		}
+		if didl.Items, err = dlnaSongItems(db, []model.Song{*song}, dlnaSongsID, baseURL); err != nil {
+			return nil, 0, err
+		}
+		return didl, 1, nil
+	}
+
+	collectionTypes, ok := collectionKinds[kind]
+	if !ok {
+		return nil, 0, &dlnaError{dlna.ErrorNoSuchObject, "no such object: " + objectID}
+	}
+
+	collection, err := findCollectionOfTypes(db, id, collectionTypes...)
+	if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && !collection.VisibleTo(user)) {
+		return nil, 0, &dlnaError{dlna.ErrorNoSuchObject, "no such object: " + objectID}
+	} else if err != nil {
+		return nil, 0, err
+	}
+
+	songs, err := findSongsOfCollection(db, collection)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if metadata {
+		stats := map[gocrud.ID]collectionStat{collection.ID: {CollectionID: collection.ID, SongCount: len(songs)}}
+		didl.Containers = append(didl.Containers, dlnaCollectionContainer(*collection, stats, baseURL))
+		return didl, 1, nil
+	}
+
+	from, to := dlnaPage(len(songs), start, count)
+	if didl.Items, err = dlnaSongItems(db, songs[from:to], objectID, baseURL); err != nil {
+		return nil, 0, err
+	}
+	return didl, len(songs), nil
+}
+
+func SetupDLNAController(group *gin.RouterGroup, db *gorm.DB) error {
+	group.Use(dlnaAuthenticate(db))
+
+	description, err := dlna.Description(dlnaUUID, env.DLNAFriendlyName, group.BasePath(), &dlna.Icon{
+		Mimetype: asset.FaviconMIME,
+		Width:    75,
+		Height:   75,
+		Depth:    24,
+		URL:      "/favicon.ico",
+	})
+	if err != nil {
+		return err
+	}
+
+	writeXML := func(context *gin.Context, status int, body []byte) {
+		context.Header("Server", dlna.ServerName)
+		context.Data(status, `text/xml; charset="utf-8"`, body)
+	}
+
+	group.GET(dlna.DescriptionPath, func(context *gin.Context) {
+		writeXML(context, http.StatusOK, description)
+	})
+	group.GET(dlna.ContentDirectorySCPDPath, func(context *gin.Context) {
+		writeXML(context, http.StatusOK, []byte(dlna.ContentDirectorySCPD))
+	})
+	group.GET(dlna.ConnectionManagerSCPDPath, func(context *gin.Context) {
+		writeXML(context, http.StatusOK, []byte(dlna.ConnectionManagerSCPD))
+	})
+
+	// eventing is not supported, but some renderers refuse servers which reject subscriptions
+	for _, eventPath := range []string{dlna.ContentDirectoryEventPath, dlna.ConnectionManagerEventPath} {
+		group.Handle("SUBSCRIBE", eventPath, func(context *gin.Context) {
+			sid := context.GetHeader("SID")
+			if sid == "" {
+				random := make([]byte, 16)
+				_, _ = rand.Read(random)
+				sid = "uuid:" + hex.EncodeToString(random)
+			}
+			context.Header("SID", sid)
+			context.Header("TIMEOUT", "Second-"+strconv.Itoa(dlna.SSDPMaxAge))
+			context.Status(http.StatusOK)
+		})
+		group.Handle("UNSUBSCRIBE", eventPath, func(context *gin.Context) {
+			context.Status(http.StatusOK)
+		})
+	}
+
+	group.POST(dlna.ContentDirectoryControlPath, func(context *gin.Context) {
+		action, args, err := dlna.ParseAction(context.Request.Body)
+		if err != nil {
+			writeXML(context, http.StatusInternalServerError, dlna.FaultResponse(dlna.ErrorInvalidAction, err.Error()))
+			return
+		}
+
+		switch action {
+		case "Browse":
+			start, ok1 := dlna.ParseIndex(args["StartingIndex"])
+			count, ok2 := dlna.ParseIndex(args["RequestedCount"])
+			if !ok1 || !ok2 {
+				writeXML(context, http.StatusInternalServerError, dlna.FaultResponse(dlna.ErrorInvalidArgs, "invalid StartingIndex or RequestedCount"))
+				return
+			}
+
+			baseURL := "http://" + context.Request.Host + group.BasePath()
+			didl, total, err := dlnaBrowse(db, auth.CurrentUser(context), baseURL, args["ObjectID"], args["BrowseFlag"], start, count)
+			if err != nil {
+				var upnpErr *dlnaError
+				if errors.As(err, &upnpErr) {
+					writeXML(context, http.StatusInternalServerError, dlna.FaultResponse(upnpErr.Code, upnpErr.Description))
+				} else {
+					l.Error().Printf("Failed to browse %s: %v", args["ObjectID"], err)
+					writeXML(context, http.StatusInternalServerError, dlna.FaultResponse(dlna.ErrorActionFailed, err.Error()))
+				}
+				return
+			}
+
+			result, err := didl.String()
+			if err != nil {
+				writeXML(context, http.StatusInternalServerError, dlna.FaultResponse(dlna.ErrorActionFailed, err.Error()))
+				return
+			}
+
+			writeXML(context, http.StatusOK, dlna.ActionResponse(dlna.ContentDirectoryType, action,
+				dlna.Arg{Name: "Result", Value: result},
+				dlna.Arg{Name: "NumberReturned", Value: strconv.Itoa(didl.Len())},
+				dlna.Arg{Name: "TotalMatches", Value: strconv.Itoa(total)},
+				dlna.Arg{Name: "UpdateID", Value: strconv.FormatUint(uint64(dlnaSystemUpdateID(db)), 10)},
+			))
+		case "GetSearchCapabilities":
+			writeXML(context, http.StatusOK, dlna.ActionResponse(dlna.ContentDirectoryType, action, dlna.Arg{Name: "SearchCaps"}))
+		case "GetSortCapabilities":
+			writeXML(context, http.StatusOK, dlna.ActionResponse(dlna.ContentDirectoryType, action, dlna.Arg{Name: "SortCaps"}))
+		case "GetSystemUpdateID":
+			writeXML(context, http.StatusOK, dlna.ActionResponse(dlna.ContentDirectoryType, action,
+				dlna.Arg{Name: "Id", Value: strconv.FormatUint(uint64(dlnaSystemUpdateID(db)), 10)},
+			))
+		default:
+			writeXML(context, http.StatusInternalServerError, dlna.FaultResponse(dlna.ErrorInvalidAction, "invalid action: "+action))
+		}
+	})
+
+	group.POST(dlna.ConnectionManagerControlPath, func(context *gin.Context) {
+		action, _, err := dlna.ParseAction(context.Request.Body)
+		if err != nil {
+			writeXML(context, http.StatusInternalServerError, dlna.FaultResponse(dlna.ErrorInvalidAction, err.Error()))
+			return
+		}
+
+		switch action {
+		case "GetProtocolInfo":
+			writeXML(context, http.StatusOK, dlna.ActionResponse(dlna.ConnectionManagerType, action,
+				dlna.Arg{Name: "Source", Value: strings.Join(dlnaSourceProtocols, ",")},
+				dlna.Arg{Name: "Sink"},
+			))
+		case "GetCurrentConnectionIDs":
+			writeXML(context, http.StatusOK, dlna.ActionResponse(dlna.ConnectionManagerType, action, dlna.Arg{Name: "ConnectionIDs", Value: "0"}))
+		case "GetCurrentConnectionInfo":
+			writeXML(context, http.StatusOK, dlna.ActionResponse(dlna.ConnectionManagerType, action,
+				dlna.Arg{Name: "RcsID", Value: "-1"},
+				dlna.Arg{Name: "AVTransportID", Value: "-1"},
+				dlna.Arg{Name: "ProtocolInfo"},
+				dlna.Arg{Name: "PeerConnectionManager"},
+				dlna.Arg{Name: "PeerConnectionID", Value: "-1"},
+				dlna.Arg{Name: "Direction", Value: "Output"},
+				dlna.Arg{Name: "Status", Value: "OK"},
+			))
+		default:
+			writeXML(context, http.StatusInternalServerError, dlna.FaultResponse(dlna.ErrorInvalidAction, "invalid action: "+action))
+		}
+	})
+
+	// ids in urls come with extensions, since some renderers guess formats from them
+	findSong := func(context *gin.Context) (*model.Song, bool) {
+		id, err := strconv.ParseUint(strings.TrimSuffix(context.Param("id"), path.Ext(context.Param("id"))), 10, 64)
+		if err != nil {
+			context.Status(http.StatusNotFound)
+			return nil, false
+		}
+		song, err := findPlayableSong(db, gocrud.ID(id))
+		if err != nil {
+			context.Status(http.StatusNotFound)
+			return nil, false
+		}
+		return song, true
+	}
+
+	// HEAD is used by renderers to probe the stream, which is not a play
+	media := func(context *gin.Context) {
+		song, ok := findSong(context)
+		if !ok {
+			return
+		}
+		dlnaStreamHeaders(context, dlna.FileProtocolInfo(song.MIME))
+		serveSongFile(db, context, song, gocrud.Ternary(context.Request.Method == http.MethodGet, model.PlaySourceDLNA, ""))
+	}
+	group.GET("/media/:id", media)
+	group.HEAD("/media/:id", media)
+
+	hotwire := func(context *gin.Context) {
+		song, ok := findSong(context)
+		if !ok {
+			return
+		}
+
+		profile := ffmpeg.Profiles[ffmpeg.DefaultProfile]
+		dlnaStreamHeaders(context, dlna.TranscodeProtocolInfo(profile.MIME))
+		if context.Request.Method == http.MethodHead {
+			context.Header("Content-Type", profile.MIME)
+			context.Status(http.StatusOK)
+			return
+		}
+
+		recordPlayEvent(db, context, song.ID, model.PlaySourceDLNA)
+		serveTranscodedSong(context, song, profile, songPassthrough(song, profile))
+	}
+	group.GET("/hotwire/:id", hotwire)
+	group.HEAD("/hotwire/:id", hotwire)
+
+	group.GET("/cover/:id", func(context *gin.Context) {
+		filename := findCoverFile(db, auth.CurrentUser(context), context.Param("id"))
+		if filename == "" {
+			context.Status(http.StatusNotFound)
+			return
+		}
+		context.File(filename)
+	})
+
+	return nil
+}
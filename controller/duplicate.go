@@ -0,0 +1,208 @@
+package controller
+
+import (
+	"fmt"
+	"github.com/allape/gocrud"
+	"github.com/allape/homesong/model"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const DefaultDurationTolerance = 2.0 // in seconds
+
+type DuplicateGroup struct {
+	Key   string       `json:"key"`
+	Songs []model.Song `json:"songs"`
+}
+
+func SetupDuplicateController(group *gin.RouterGroup, db *gorm.DB) error {
+	group.GET("/by-digest", func(context *gin.Context) {
+		var songs []model.Song
+		if err := db.Model(&songs).Where(
+			"deleted_at IS NULL AND digest IN (SELECT digest FROM songs WHERE deleted_at IS NULL AND digest != '' GROUP BY digest HAVING COUNT(*) > 1)",
+		).Order("digest ASC").Order("id ASC").Find(&songs).Error; err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
+			return
+		}
+
+		groups := make([]DuplicateGroup, 0)
+		for _, song := range songs {
+			if len(groups) > 0 && groups[len(groups)-1].Key == song.Digest {
+				groups[len(groups)-1].Songs = append(groups[len(groups)-1].Songs, song)
+			} else {
+				groups = append(groups, DuplicateGroup{Key: song.Digest, Songs: []model.Song{song}})
+			}
+		}
+
+		context.JSON(http.StatusOK, gocrud.R[[]DuplicateGroup]{Code: gocrud.RestCoder.OK(), Data: groups})
+	})
+
+	// ?tolerance=2, max difference of duration in seconds
+	group.GET("/by-metadata", func(context *gin.Context) {
+		tolerance, err := strconv.ParseFloat(context.DefaultQuery("tolerance", ""), 64)
+		if err != nil || tolerance < 0 {
+			tolerance = DefaultDurationTolerance
+		}
+
+		groups, err := findDuplicatesByMetadata(db, tolerance)
+		if err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
+			return
+		}
+
+		context.JSON(http.StatusOK, gocrud.R[[]DuplicateGroup]{Code: gocrud.RestCoder.OK(), Data: groups})
+	})
+
+	// ?songIds=2,3, merge these songs into :id
+	group.PUT("/merge/:id", func(context *gin.Context) {
+		id := gocrud.Pick(gocrud.IDsFromCommaSeparatedString(context.Param("id")), 0, 0)
+		if id == 0 {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), "id not found")
+			return
+		}
+
+		songIds := slices.DeleteFunc(gocrud.IDsFromCommaSeparatedString(context.Query("songIds")), func(songId gocrud.ID) bool {
+			return songId == id
+		})
+		if len(songIds) == 0 {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), "songIds not found")
+			return
+		}
+
+		var song model.Song
+		if err := db.Model(&song).Where("deleted_at IS NULL").First(&song, id).Error; err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.NotFound(), err)
+			return
+		}
+
+		if err := db.Transaction(func(tx *gorm.DB) error {
+			return MergeSongs(tx, song.ID, songIds)
+		}); err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
+			return
+		}
+
+		context.JSON(http.StatusOK, gocrud.R[model.Song]{Code: gocrud.RestCoder.OK(), Data: song})
+	})
+
+	return nil
+}
+
+// findDuplicatesByMetadata groups songs of the same name and singers, whose durations differ by at most tolerance in a row
+func findDuplicatesByMetadata(db *gorm.DB, tolerance float64) ([]DuplicateGroup, error) {
+	var songs []model.Song
+	if err := db.Model(&songs).Where("deleted_at IS NULL").Order("duration ASC").Order("id ASC").Find(&songs).Error; err != nil {
+		return nil, err
+	}
+
+	var singers []model.CollectionSong
+	if err := db.Model(&singers).Where("role = ?", model.Singer).Find(&singers).Error; err != nil {
+		return nil, err
+	}
+
+	singerIds := make(map[gocrud.ID][]string)
+	for _, singer := range singers {
+		singerIds[singer.SongID] = append(singerIds[singer.SongID], strconv.FormatUint(uint64(singer.CollectionID), 10))
+	}
+
+	// songs are sorted by duration, so each bucket is sorted too
+	buckets := make(map[string][]model.Song)
+	var keys []string
+	for _, song := range songs {
+		ids := singerIds[song.ID]
+		slices.Sort(ids)
+		key := fmt.Sprintf("%s|%s", strings.ToLower(strings.TrimSpace(song.Name)), strings.Join(ids, ","))
+		if _, ok := buckets[key]; !ok {
+			keys = append(keys, key)
+		}
+		buckets[key] = append(buckets[key], song)
+	}
+
+	groups := make([]DuplicateGroup, 0)
+	for _, key := range keys {
+		var current []model.Song
+		for _, song := range buckets[key] {
+			if len(current) > 0 && math.Abs(song.Duration-current[len(current)-1].Duration) > tolerance {
+				if len(current) > 1 {
+					groups = append(groups, DuplicateGroup{Key: key, Songs: current})
+				}
+				current = nil
+			}
+			current = append(current, song)
+		}
+		if len(current) > 1 {
+			groups = append(groups, DuplicateGroup{Key: key, Songs: current})
+		}
+	}
+
+	return groups, nil
+}
+
+// MergeSongs moves collection links, lyrics links and play events of songIds onto id, then soft deletes songIds
+func MergeSongs(db *gorm.DB, id gocrud.ID, songIds []gocrud.ID) error {
+	var collectionSongs []model.CollectionSong
+	if err := db.Model(&collectionSongs).Where("song_id IN ?", append(songIds, id)).Find(&collectionSongs).Error; err != nil {
+		return err
+	}
+
+	var missingCollectionSongs []model.CollectionSong
+	for _, collectionSong := range collectionSongs {
+		if collectionSong.SongID == id || slices.ContainsFunc(collectionSongs, func(exist model.CollectionSong) bool {
+			return exist.SongID == id && exist.CollectionID == collectionSong.CollectionID && exist.Role == collectionSong.Role
+		}) || slices.ContainsFunc(missingCollectionSongs, func(missing model.CollectionSong) bool {
+			return missing.CollectionID == collectionSong.CollectionID && missing.Role == collectionSong.Role
+		}) {
+			continue
+		}
+		collectionSong.SongID = id
+		missingCollectionSongs = append(missingCollectionSongs, collectionSong)
+	}
+
+	if err := db.Where("song_id IN ?", songIds).Delete(&model.CollectionSong{}).Error; err != nil {
+		return err
+	}
+	if len(missingCollectionSongs) > 0 {
+		if err := db.Create(&missingCollectionSongs).Error; err != nil {
+			return err
+		}
+	}
+
+	var songLyrics []model.SongLyrics
+	if err := db.Model(&songLyrics).Where("song_id IN ?", append(songIds, id)).Find(&songLyrics).Error; err != nil {
+		return err
+	}
+
+	var missingSongLyrics []model.SongLyrics
+	for _, songLyric := range songLyrics {
+		if songLyric.SongID == id || slices.ContainsFunc(songLyrics, func(exist model.SongLyrics) bool {
+			return exist.SongID == id && exist.LyricsID == songLyric.LyricsID
+		}) || slices.ContainsFunc(missingSongLyrics, func(missing model.SongLyrics) bool {
+			return missing.LyricsID == songLyric.LyricsID
+		}) {
+			continue
+		}
+		songLyric.SongID = id
+		missingSongLyrics = append(missingSongLyrics, songLyric)
+	}
+
+	if err := db.Where("song_id IN ?", songIds).Delete(&model.SongLyrics{}).Error; err != nil {
+		return err
+	}
+	if len(missingSongLyrics) > 0 {
+		if err := db.Create(&missingSongLyrics).Error; err != nil {
+			return err
+		}
+	}
+
+	if err := db.Model(&model.PlayEvent{}).Where("song_id IN ?", songIds).UpdateColumn("song_id", id).Error; err != nil {
+		return err
+	}
+
+	return db.Model(&model.Song{}).Where("id IN ? AND deleted_at IS NULL", songIds).UpdateColumn("deleted_at", time.Now()).Error
+}
@@ -0,0 +1,104 @@
+package controller
+
+import (
+	"github.com/allape/gocrud"
+	"github.com/allape/homesong/model"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"slices"
+	"testing"
+)
+
+func TestMergeDuplicates(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&model.Song{}, &model.CollectionSong{}, &model.SongLyrics{}, &model.PlayEvent{}); err != nil {
+		t.Fatal(err)
+	}
+
+	songs := []model.Song{
+		{Name: "Waterloo", Duration: 170},
+		{Name: "waterloo ", Duration: 171.5},
+		{Name: "Waterloo", Duration: 180}, // longer than the tolerance
+		{Name: "Waterloo", Duration: 171}, // of other singers
+	}
+	if err := db.Create(&songs).Error; err != nil {
+		t.Fatal(err)
+	}
+	kept, merged := songs[0].ID, songs[1].ID
+
+	const artistId, albumId, playlistId gocrud.ID = 1, 2, 3
+	collectionSongs := []model.CollectionSong{
+		{SongID: kept, CollectionID: artistId, Role: model.Singer},
+		{SongID: kept, CollectionID: albumId, Role: model.Reserved},
+		{SongID: merged, CollectionID: artistId, Role: model.Singer},
+		{SongID: merged, CollectionID: albumId, Role: model.Reserved},
+		{SongID: merged, CollectionID: playlistId, Role: model.Reserved},
+		{SongID: songs[2].ID, CollectionID: artistId, Role: model.Singer},
+	}
+	if err := db.Create(&collectionSongs).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Create(&model.SongLyrics{SongID: merged, LyricsID: 1}).Error; err != nil {
+		t.Fatal(err)
+	}
+	events := []model.PlayEvent{{SongID: kept}, {SongID: merged}, {SongID: merged}}
+	if err := db.Create(&events).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	groups, err := findDuplicatesByMetadata(db, DefaultDurationTolerance)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(groups) != 1 || len(groups[0].Songs) != 2 || groups[0].Songs[0].ID != kept || groups[0].Songs[1].ID != merged {
+		t.Fatal("unexpected groups", groups)
+	}
+
+	if err := db.Transaction(func(tx *gorm.DB) error {
+		return MergeSongs(tx, kept, []gocrud.ID{merged})
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	var links []model.CollectionSong
+	if err := db.Model(&links).Where("song_id IN ?", []gocrud.ID{kept, merged}).Order("collection_id ASC").Find(&links).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(links) != 3 || slices.ContainsFunc(links, func(link model.CollectionSong) bool {
+		return link.SongID != kept
+	}) || links[0].CollectionID != artistId || links[1].CollectionID != albumId || links[2].CollectionID != playlistId {
+		t.Fatal("unexpected collection links", links)
+	}
+
+	var lyricsCount, eventCount int64
+	if err := db.Model(&model.SongLyrics{}).Where("song_id = ? AND lyrics_id = ?", kept, 1).Count(&lyricsCount).Error; err != nil {
+		t.Fatal(err)
+	} else if lyricsCount != 1 {
+		t.Fatal("lyrics should be linked to the kept song")
+	}
+	if err := db.Model(&model.PlayEvent{}).Where("song_id = ?", kept).Count(&eventCount).Error; err != nil {
+		t.Fatal(err)
+	} else if eventCount != 3 {
+		t.Fatal("unexpected play events of the kept song", eventCount)
+	}
+
+	var after []model.Song
+	if err := db.Model(&after).Order("id ASC").Find(&after).Error; err != nil {
+		t.Fatal(err)
+	}
+	for _, song := range after {
+		if deleted := song.DeletedAt != nil; deleted != (song.ID == merged) {
+			t.Fatal("unexpected deletion of song", song.ID, song.DeletedAt)
+		}
+	}
+
+	groups, err = findDuplicatesByMetadata(db, DefaultDurationTolerance)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(groups) != 0 {
+		t.Fatal("merged songs should not be grouped", groups)
+	}
+}
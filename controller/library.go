@@ -0,0 +1,187 @@
+package controller
+
+import (
+	"github.com/allape/gocrud"
+	"github.com/allape/homesong/env"
+	"github.com/allape/homesong/model"
+	"github.com/allape/homesong/subsonic"
+	"gorm.io/gorm"
+	"os"
+	"path"
+	"slices"
+)
+
+// songCollection is a song linked to an artist or an album
+type songCollection struct {
+	SongID       gocrud.ID
+	CollectionID gocrud.ID
+	Type         model.CollectionType
+	Role         model.Role
+	Name         string
+	Cover        string
+	Disc         int32
+	Track        int32
+}
+
+// collectionStat sums songs up by collection
+type collectionStat struct {
+	CollectionID gocrud.ID
+	SongCount    int
+	Duration     float64
+}
+
+// artistAndAlbumOf picks the artist and the album of a song from songCollections, singers come before other roles
+func artistAndAlbumOf(songCollections []songCollection, songId gocrud.ID) (*songCollection, *songCollection) {
+	var artist, album *songCollection
+	for i, songCollection := range songCollections {
+		if songCollection.SongID != songId {
+			continue
+		}
+		switch songCollection.Type {
+		case model.CollectionTypeAlbum:
+			if album == nil {
+				album = &songCollections[i]
+			}
+		case model.CollectionTypeArtist:
+			if artist == nil || (artist.Role != model.Singer && songCollection.Role == model.Singer) {
+				artist = &songCollections[i]
+			}
+		}
+	}
+	return artist, album
+}
+
+// randomOrder works for both MySQL and SQLite
+func randomOrder(db *gorm.DB) string {
+	if db.Dialector.Name() == "sqlite" {
+		return "RANDOM()"
+	}
+	return "RAND()"
+}
+
+// findSongCollections returns artists and albums of songs
+func findSongCollections(db *gorm.DB, songIds []gocrud.ID) ([]songCollection, error) {
+	var collectionSongs []songCollection
+	if len(songIds) == 0 {
+		return collectionSongs, nil
+	}
+	err := db.Table("collection_songs").
+		Select("collection_songs.song_id, collections.id AS collection_id, collections.type, collection_songs.role, collections.name, collections.cover, collection_songs.disc, collection_songs.track").
+		Joins("JOIN collections ON collections.id = collection_songs.collection_id").
+		Where("collection_songs.song_id IN ? AND collections.deleted_at IS NULL AND collections.type IN ?", songIds, []model.CollectionType{model.CollectionTypeArtist, model.CollectionTypeAlbum}).
+		Order("collection_songs.created_at").
+		Scan(&collectionSongs).Error
+	return collectionSongs, err
+}
+
+// findCollectionStats counts songs and sums durations up by collection
+func findCollectionStats(db *gorm.DB, collectionIds []gocrud.ID) (map[gocrud.ID]collectionStat, error) {
+	stats := map[gocrud.ID]collectionStat{}
+	if len(collectionIds) == 0 {
+		return stats, nil
+	}
+	var rows []collectionStat
+	err := db.Table("collection_songs").
+		Select("collection_songs.collection_id, COUNT(*) AS song_count, COALESCE(SUM(songs.duration), 0) AS duration").
+		Joins("JOIN songs ON songs.id = collection_songs.song_id AND songs.deleted_at IS NULL").
+		Where("collection_songs.collection_id IN ?", collectionIds).
+		Group("collection_songs.collection_id").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		stats[row.CollectionID] = row
+	}
+	return stats, nil
+}
+
+// findSongsOfCollection returns songs of a collection by their positions in it, or by the rules of a smart playlist
+func findSongsOfCollection(db *gorm.DB, collection *model.Collection) ([]model.Song, error) {
+	var songs []model.Song
+	if collection.Type == model.CollectionTypeSmart {
+		err := smartSongsDB(db, collection).Find(&songs).Error
+		return songs, err
+	}
+
+	err := db.Model(&model.Song{}).
+		Select("songs.*").
+		Joins("JOIN collection_songs ON collection_songs.song_id = songs.id").
+		Where("collection_songs.collection_id = ? AND songs.deleted_at IS NULL", collection.ID).
+		Order("collection_songs.position, collection_songs.created_at, songs.id").
+		Find(&songs).Error
+	return songs, err
+}
+
+// findCollectionOfTypes finds a collection which is not deleted and is one of types
+func findCollectionOfTypes(db *gorm.DB, id gocrud.ID, types ...model.CollectionType) (*model.Collection, error) {
+	var collection model.Collection
+	if err := db.Model(&collection).Where("type IN ? AND deleted_at IS NULL", types).First(&collection, id).Error; err != nil {
+		return nil, err
+	}
+	return &collection, nil
+}
+
+// findPlayableSong finds a song which is not deleted and has a file
+func findPlayableSong(db *gorm.DB, id gocrud.ID) (*model.Song, error) {
+	var song model.Song
+	if err := db.Model(&song).Where("deleted_at IS NULL").First(&song, id).Error; err != nil {
+		return nil, err
+	} else if song.Filename == "" {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return &song, nil
+}
+
+// collectionKinds maps kinds of ids made by subsonic.NewID to collection types
+var collectionKinds = map[string][]model.CollectionType{
+	subsonic.KindArtist:   {model.CollectionTypeArtist},
+	subsonic.KindAlbum:    {model.CollectionTypeAlbum},
+	subsonic.KindPlaylist: {model.CollectionTypeSong, model.CollectionTypeSmart},
+}
+
+// collectionID makes an id like subsonic.NewID for a collection
+func collectionID(collection *model.Collection) string {
+	for kind, collectionTypes := range collectionKinds {
+		if slices.Contains(collectionTypes, collection.Type) {
+			return subsonic.NewID(kind, collection.ID)
+		}
+	}
+	return ""
+}
+
+// findCoverFile returns the cover file of a song or a collection with an id made by subsonic.NewID, or empty if there is none.
+// Albums and playlists without a cover use the cover of their first song which has one.
+func findCoverFile(db *gorm.DB, user *model.User, id string) string {
+	kind, number := subsonic.ParseID(id)
+
+	cover := ""
+	if kind == subsonic.KindSong {
+		if song, err := findPlayableSong(db, number); err == nil {
+			cover = song.Cover
+		}
+	} else if collectionTypes, ok := collectionKinds[kind]; ok {
+		collection, err := findCollectionOfTypes(db, number, collectionTypes...)
+		if err != nil || !collection.VisibleTo(user) {
+			return ""
+		}
+		cover = collection.Cover
+		if cover == "" && kind != subsonic.KindArtist {
+			if songs, err := findSongsOfCollection(db, collection); err == nil {
+				if i := slices.IndexFunc(songs, func(song model.Song) bool { return song.Cover != "" }); i != -1 {
+					cover = songs[i].Cover
+				}
+			}
+		}
+	}
+
+	if cover == "" {
+		return ""
+	}
+
+	filename := path.Join(env.StaticFolder, path.Clean("/"+cover))
+	if stat, err := os.Stat(filename); err != nil || stat.IsDir() {
+		return ""
+	}
+	return filename
+}
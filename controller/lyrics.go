@@ -1,19 +1,43 @@
 package controller
 
 import (
+	"errors"
 	"github.com/allape/gocrud"
+	"github.com/allape/homesong/lyrics"
 	"github.com/allape/homesong/model"
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"slices"
+	"strconv"
 	"strings"
 )
 
+// LyricsUploadMaxSize limits uploaded lyrics files
+const LyricsUploadMaxSize = 1 << 20
+
+type mergedLyrics struct {
+	Original     model.Lyrics        `json:"original"`
+	Translations []model.Lyrics      `json:"translations"`
+	Lines        []lyrics.MergedLine `json:"lines"`
+}
+
+type lyricsLineAt struct {
+	Index int          `json:"index"` // -1 before the first line
+	Line  *lyrics.Line `json:"line"`
+}
+
 func SetupLyricsController(group *gin.RouterGroup, db *gorm.DB) error {
 	err := gocrud.New(group, db, gocrud.Crud[model.Lyrics]{
 		DefaultPageSize: DefaultPageSize,
 		SearchHandlers: map[string]gocrud.SearchHandler{
 			"like_name":         gocrud.KeywordLike("name", nil),
 			"in_id":             gocrud.KeywordIDIn("id", gocrud.OverflowedArrayTrimmerFilter[gocrud.ID](DefaultPageSize)),
+			"in_language":       gocrud.KeywordIn("language", nil),
+			"in_kind":           gocrud.KeywordIn("kind", nil),
 			"deleted":           gocrud.NewSoftDeleteSearchHandler("lyrics"),
 			"orderBy_index":     gocrud.SortBy("index"),
 			"orderBy_createdAt": gocrud.SortBy("created_at"),
@@ -22,11 +46,301 @@ func SetupLyricsController(group *gin.RouterGroup, db *gorm.DB) error {
 		OnDelete: gocrud.NewSoftDeleteHandler[model.Lyrics](gocrud.RestCoder),
 		WillSave: func(record *model.Lyrics, context *gin.Context, db *gorm.DB) {
 			record.Name = strings.TrimSpace(record.Name)
+			record.Language = strings.TrimSpace(record.Language)
+			if record.Kind == "" {
+				record.Kind = model.LyricsKindOriginal
+			} else if !slices.Contains(model.LyricsKinds, record.Kind) {
+				gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), "kind not found")
+				return
+			}
+			if _, _, err := lyrics.Parse(record.Content); err != nil {
+				gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), err)
+				return
+			}
 		},
 	})
 	if err != nil {
 		return err
 	}
 
+	// ?at=12.5, the line active at the playback offset in seconds, only for synced lyrics
+	group.GET("/:id/lines", func(context *gin.Context) {
+		record, ok := findLyrics(context, db, gocrud.Pick(gocrud.IDsFromCommaSeparatedString(context.Param("id")), 0, 0))
+		if !ok {
+			return
+		}
+
+		lines, synced := parseSavedLyrics(record.Content)
+
+		atValue, ok := context.GetQuery("at")
+		if !ok {
+			context.JSON(http.StatusOK, gocrud.R[[]lyrics.Line]{Code: gocrud.RestCoder.OK(), Data: lines})
+			return
+		}
+
+		at, err := strconv.ParseFloat(atValue, 64)
+		if err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), "invalid at")
+			return
+		} else if !synced {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), "lyrics are not synced")
+			return
+		}
+
+		lineAt := lyricsLineAt{Index: lyrics.LineAt(lines, at)}
+		if lineAt.Index >= 0 {
+			lineAt.Line = &lines[lineAt.Index]
+		}
+		context.JSON(http.StatusOK, gocrud.R[lyricsLineAt]{Code: gocrud.RestCoder.OK(), Data: lineAt})
+	})
+
+	for _, format := range lyrics.Formats {
+		group.GET("/:id/export."+string(format), func(context *gin.Context) {
+			exportLyrics(context, db, format)
+		})
+	}
+
+	group.PUT("/upload", func(context *gin.Context) {
+		uploadLyrics(context, db)
+	})
+
+	return nil
+}
+
+// findLyrics returns the lyrics which is not deleted, or writes the error response
+func findLyrics(context *gin.Context, db *gorm.DB, id gocrud.ID) (*model.Lyrics, bool) {
+	if id == 0 {
+		gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), "id not found")
+		return nil, false
+	}
+
+	var record model.Lyrics
+	if err := db.Model(&record).Where("deleted_at IS NULL").First(&record, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.NotFound(), err)
+		} else {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
+		}
+		return nil, false
+	}
+
+	return &record, true
+}
+
+// parseSavedLyrics parses content as lyrics.Parse does,
+// lyrics saved before timestamps were validated are read as plain lines
+func parseSavedLyrics(content string) ([]lyrics.Line, bool) {
+	lines, synced, err := lyrics.Parse(content)
+	if err != nil {
+		return lyrics.PlainLines(content), false
+	}
+	return lines, synced
+}
+
+// exportLyrics renders lyrics as the format, e.g. WebVTT for a <track> of an <audio>
+func exportLyrics(context *gin.Context, db *gorm.DB, format lyrics.Format) {
+	record, ok := findLyrics(context, db, gocrud.Pick(gocrud.IDsFromCommaSeparatedString(context.Param("id")), 0, 0))
+	if !ok {
+		return
+	}
+
+	lines, synced := parseSavedLyrics(record.Content)
+
+	body, err := lyrics.Write(format, record.Name, lines, synced)
+	if err != nil {
+		gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), err)
+		return
+	}
+
+	context.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+		"filename": record.Name + "." + string(format),
+	}))
+	context.Data(http.StatusOK, format.ContentType(), body)
+}
+
+// uploadLyrics reads a multipart file of any lyrics format, and saves it as a new lyrics,
+// or as the content of the lyrics of form value id.
+// Synced lyrics other than LRC are converted to LRC.
+func uploadLyrics(context *gin.Context, db *gorm.DB) {
+	fileHeader, err := context.FormFile("file")
+	if err != nil {
+		gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), err)
+		return
+	} else if fileHeader.Size > LyricsUploadMaxSize {
+		gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), "file too large")
+		return
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), err)
+		return
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	content, err := io.ReadAll(io.LimitReader(file, LyricsUploadMaxSize))
+	if err != nil {
+		gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), err)
+		return
+	}
+
+	format := lyrics.DetectFormat(fileHeader.Filename, content)
+	lines, _, err := lyrics.Read(format, content)
+	if err != nil {
+		gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), err)
+		return
+	}
+
+	record := &model.Lyrics{}
+	if id := gocrud.Pick(gocrud.IDsFromCommaSeparatedString(context.PostForm("id")), 0, 0); id != 0 {
+		var ok bool
+		if record, ok = findLyrics(context, db, id); !ok {
+			return
+		}
+	}
+
+	if name := strings.TrimSpace(context.PostForm("name")); name != "" {
+		record.Name = name
+	} else if record.Name == "" {
+		record.Name = strings.TrimSpace(strings.TrimSuffix(path.Base(fileHeader.Filename), path.Ext(fileHeader.Filename)))
+	}
+
+	if language, ok := context.GetPostForm("language"); ok {
+		record.Language = strings.TrimSpace(language)
+	}
+	if kind := model.LyricsKind(context.PostForm("kind")); kind != "" {
+		if !slices.Contains(model.LyricsKinds, kind) {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), "kind not found")
+			return
+		}
+		record.Kind = kind
+	} else if record.Kind == "" {
+		record.Kind = model.LyricsKindOriginal
+	}
+
+	if format == lyrics.FormatText || format == lyrics.FormatLRC {
+		// kept as is for tags of LRC
+		record.Content = strings.TrimPrefix(string(content), "\xef\xbb\xbf")
+	} else {
+		record.Content = string(lyrics.WriteLRC("", lines))
+	}
+
+	if err := db.Save(record).Error; err != nil {
+		gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
+		return
+	}
+
+	context.JSON(http.StatusOK, gocrud.R[*model.Lyrics]{Code: gocrud.RestCoder.OK(), Data: record})
+}
+
+// mergeSongLyrics pairs lines of the original lyrics of a song with lines of its translations and romanizations,
+// ?originalId= picks the original, the first original lyrics by index by default,
+// ?languages=zh,ja and ?kinds=translation filter the translations,
+// and ?format=lrc writes a bilingual LRC with translations following their lines instead
+func mergeSongLyrics(context *gin.Context, db *gorm.DB) {
+	id := gocrud.Pick(gocrud.IDsFromCommaSeparatedString(context.Param("id")), 0, 0)
+	if id == 0 {
+		gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), "id not found")
+		return
+	}
+
+	var lyricsArr []model.Lyrics
+	if err := db.Model(&lyricsArr).Where(
+		"deleted_at IS NULL AND id IN (SELECT song_lyrics.lyrics_id FROM song_lyrics WHERE song_lyrics.song_id = ?)",
+		id,
+	).Order("`index` ASC").Order("`updated_at` DESC").Find(&lyricsArr).Error; err != nil {
+		gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
+		return
+	}
+
+	originalId := gocrud.Pick(gocrud.IDsFromCommaSeparatedString(context.Query("originalId")), 0, 0)
+	originalIndex := slices.IndexFunc(lyricsArr, func(record model.Lyrics) bool {
+		if originalId != 0 {
+			return record.ID == originalId
+		}
+		return record.Kind == model.LyricsKindOriginal
+	})
+	if originalIndex == -1 {
+		gocrud.MakeErrorResponse(context, gocrud.RestCoder.NotFound(), "original lyrics not found")
+		return
+	}
+
+	kinds := []model.LyricsKind{model.LyricsKindTranslation, model.LyricsKindRomanization}
+	if value := context.Query("kinds"); value != "" {
+		kinds = nil
+		for _, kind := range strings.Split(value, ",") {
+			kinds = append(kinds, model.LyricsKind(strings.TrimSpace(kind)))
+		}
+	}
+
+	var languages []string
+	if value := context.Query("languages"); value != "" {
+		languages = strings.Split(value, ",")
+	}
+
+	merged := mergedLyrics{Original: lyricsArr[originalIndex], Translations: []model.Lyrics{}}
+	for i, record := range lyricsArr {
+		if i != originalIndex && slices.Contains(kinds, record.Kind) && matchLanguage(record.Language, languages) {
+			merged.Translations = append(merged.Translations, record)
+		}
+	}
+
+	original, synced := parseSavedLyrics(merged.Original.Content)
+
+	translations := make([][]lyrics.Line, len(merged.Translations))
+	for i, record := range merged.Translations {
+		translations[i], _ = parseSavedLyrics(record.Content)
+	}
+
+	merged.Lines = lyrics.Merge(original, translations...)
+
+	if lyrics.Format(context.Query("format")) == lyrics.FormatLRC {
+		body, err := lyrics.Write(lyrics.FormatLRC, merged.Original.Name, lyrics.Flatten(merged.Lines), synced)
+		if err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
+			return
+		}
+		context.Data(http.StatusOK, lyrics.FormatLRC.ContentType(), body)
+		return
+	}
+
+	context.JSON(http.StatusOK, gocrud.R[mergedLyrics]{Code: gocrud.RestCoder.OK(), Data: merged})
+}
+
+// matchLanguage tells whether language is one of languages, or a variant of one like zh-Hans of zh,
+// every language matches if languages is empty.
+// ISO 639-2 codes saved from ID3 frames match their ISO 639-1 codes, like eng of en.
+func matchLanguage(language string, languages []string) bool {
+	if len(languages) == 0 {
+		return true
+	}
+	language = lyrics.NormalizeLanguage(language)
+	for _, expected := range languages {
+		expected = lyrics.NormalizeLanguage(expected)
+		if strings.EqualFold(language, expected) ||
+			(len(language) > len(expected) && strings.EqualFold(language[:len(expected)+1], expected+"-")) {
+			return true
+		}
+	}
+	return false
+}
+
+// BackfillLyricsLanguages normalizes ISO 639-2 languages saved from ID3 frames, like eng into en
+func BackfillLyricsLanguages(db *gorm.DB) error {
+	var languages []string
+	if err := db.Model(&model.Lyrics{}).Where("language IS NOT NULL AND language <> ?", "").Distinct().Pluck("language", &languages).Error; err != nil {
+		return err
+	}
+
+	for _, language := range languages {
+		if normalized := lyrics.NormalizeLanguage(language); normalized != language {
+			if err := db.Model(&model.Lyrics{}).Where("language = ?", language).Update("language", normalized).Error; err != nil {
+				return err
+			}
+		}
+	}
+
 	return nil
 }
@@ -0,0 +1,276 @@
+package controller
+
+import (
+	"github.com/allape/gocrud"
+	"github.com/allape/homesong/auth"
+	"github.com/allape/homesong/model"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const DefaultPlayStatLimit = 50
+
+type PlayStat struct {
+	Song         model.Song `json:"song"`
+	PlayCount    int64      `json:"playCount"`
+	LastPlayedAt *time.Time `json:"lastPlayedAt"`
+}
+
+// whereOwnPlayEvents lets admins see the history of everyone, and others only their own one
+func whereOwnPlayEvents(db *gorm.DB, user *model.User) *gorm.DB {
+	if user.IsAdmin() {
+		return db
+	}
+	return db.Where("user_id = ?", user.ID)
+}
+
+func SetupPlayController(group *gin.RouterGroup, db *gorm.DB) error {
+	auth.AcceptScopes(group, http.MethodPut, "", model.TokenScopeStream)
+	err := gocrud.New(group, db, gocrud.Crud[model.PlayEvent]{
+		DefaultPageSize: DefaultPageSize,
+		SearchHandlers: map[string]gocrud.SearchHandler{
+			"in_songId":         gocrud.KeywordIDIn("song_id", gocrud.OverflowedArrayTrimmerFilter[gocrud.ID](DefaultPageSize)),
+			"in_userId":         gocrud.KeywordIDIn("user_id", gocrud.OverflowedArrayTrimmerFilter[gocrud.ID](DefaultPageSize)),
+			"in_source":         gocrud.KeywordIn("source", nil),
+			"like_client":       gocrud.KeywordLike("client", nil),
+			"gte_createdAt":     gocrud.KeywordStatement("created_at", gocrud.OperatorGte, nil),
+			"lte_createdAt":     gocrud.KeywordStatement("created_at", gocrud.OperatorLte, nil),
+			"orderBy_createdAt": gocrud.SortBy("created_at"),
+		},
+		WillPage: func(pageNum *int64, pageSize *int64, context *gin.Context, db *gorm.DB) *gorm.DB {
+			return whereOwnPlayEvents(db, auth.CurrentUser(context))
+		},
+		WillCount: func(context *gin.Context, db *gorm.DB) *gorm.DB {
+			return whereOwnPlayEvents(db, auth.CurrentUser(context))
+		},
+		DidGetOne: func(record *model.PlayEvent, context *gin.Context, db *gorm.DB) {
+			if user := auth.CurrentUser(context); record.UserID != user.ID && !user.IsAdmin() {
+				gocrud.MakeErrorResponse(context, gocrud.RestCoder.NotFound(), "play event not found")
+				return
+			}
+		},
+		WillSave: func(record *model.PlayEvent, context *gin.Context, db *gorm.DB) {
+			user := auth.CurrentUser(context)
+
+			if record.ID != 0 {
+				var exist model.PlayEvent
+				if err := db.Model(&exist).First(&exist, record.ID).Error; err != nil {
+					gocrud.MakeErrorResponse(context, gocrud.RestCoder.NotFound(), err)
+					return
+				} else if exist.UserID != user.ID && !user.IsAdmin() {
+					gocrud.MakeErrorResponse(context, gocrud.RestCoder.FromStatus(http.StatusForbidden), auth.ErrorForbidden)
+					return
+				}
+				record.UserID = exist.UserID
+				record.CreatedAt = exist.CreatedAt
+			} else {
+				record.UserID = user.ID
+			}
+
+			if record.SongID == 0 {
+				gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), "songId is required")
+				return
+			}
+
+			if err := db.Model(&model.Song{}).First(&model.Song{}, record.SongID).Error; err != nil {
+				gocrud.MakeErrorResponse(context, gocrud.RestCoder.NotFound(), err)
+				return
+			}
+
+			if record.Source == "" {
+				record.Source = model.PlaySourceReport
+			}
+			record.Client = strings.TrimSpace(record.Client)
+			if record.Client == "" {
+				record.Client = context.Request.UserAgent()
+			}
+		},
+		WillDelete: func(context *gin.Context, db *gorm.DB) {
+			user := auth.CurrentUser(context)
+
+			var exist model.PlayEvent
+			if err := db.Model(&exist).First(&exist, context.Param("id")).Error; err != nil {
+				gocrud.MakeErrorResponse(context, gocrud.RestCoder.NotFound(), err)
+				return
+			} else if exist.UserID != user.ID && !user.IsAdmin() {
+				gocrud.MakeErrorResponse(context, gocrud.RestCoder.FromStatus(http.StatusForbidden), auth.ErrorForbidden)
+				return
+			}
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	// ?collectionId=1&userId=1&limit=50
+	group.GET("/most-played", func(context *gin.Context) {
+		if !playStatsCollectionVisible(context, db) {
+			return
+		}
+		stats, err := findPlayStats(db, auth.CurrentUser(context), context.Request.URL.Query(), "play_count DESC, last_event_id DESC")
+		if err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
+			return
+		}
+		context.JSON(http.StatusOK, gocrud.R[[]PlayStat]{Code: gocrud.RestCoder.OK(), Data: stats})
+	})
+
+	// ?collectionId=1&userId=1&limit=50
+	group.GET("/recently-played", func(context *gin.Context) {
+		if !playStatsCollectionVisible(context, db) {
+			return
+		}
+		stats, err := findPlayStats(db, auth.CurrentUser(context), context.Request.URL.Query(), "last_event_id DESC")
+		if err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
+			return
+		}
+		context.JSON(http.StatusOK, gocrud.R[[]PlayStat]{Code: gocrud.RestCoder.OK(), Data: stats})
+	})
+
+	// ?collectionId=1&userId=1&limit=50
+	group.GET("/never-played", func(context *gin.Context) {
+		if !playStatsCollectionVisible(context, db) {
+			return
+		}
+		stats, err := findNeverPlayed(db, auth.CurrentUser(context), context.Request.URL.Query())
+		if err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
+			return
+		}
+		context.JSON(http.StatusOK, gocrud.R[[]PlayStat]{Code: gocrud.RestCoder.OK(), Data: stats})
+	})
+
+	return nil
+}
+
+// playStatsCollectionVisible checks ?collectionId= against the visibility of collections, or writes not found
+func playStatsCollectionVisible(context *gin.Context, db *gorm.DB) bool {
+	if context.Query("collectionId") == "" {
+		return true
+	}
+	_, ok := findVisibleCollection(context, db, gocrud.Pick(gocrud.IDsFromCommaSeparatedString(context.Query("collectionId")), 0, 0))
+	return ok
+}
+
+func playStatLimit(query url.Values) int {
+	limit, err := strconv.Atoi(query.Get("limit"))
+	if err != nil || limit <= 0 {
+		return DefaultPlayStatLimit
+	}
+	return min(limit, DefaultPageSize)
+}
+
+// playEventsOf scopes play events to ?userId=, within those visible to user
+func playEventsOf(db *gorm.DB, user *model.User, query url.Values) *gorm.DB {
+	eventDB := whereOwnPlayEvents(db.Model(&model.PlayEvent{}), user)
+	if userId := gocrud.Pick(gocrud.IDsFromCommaSeparatedString(query.Get("userId")), 0, 0); userId != 0 {
+		eventDB = eventDB.Where("user_id = ?", userId)
+	}
+	return eventDB
+}
+
+// findNeverPlayed returns songs without play events visible to user, newest first
+func findNeverPlayed(db *gorm.DB, user *model.User, query url.Values) ([]PlayStat, error) {
+	songDB := db.Model(&model.Song{}).
+		Where("deleted_at IS NULL").
+		Where("id NOT IN (?)", playEventsOf(db, user, query).Select("song_id"))
+	if collectionId := gocrud.Pick(gocrud.IDsFromCommaSeparatedString(query.Get("collectionId")), 0, 0); collectionId != 0 {
+		songDB = songDB.Where("id IN (SELECT collection_songs.song_id FROM collection_songs WHERE collection_songs.collection_id = ?)", collectionId)
+	}
+
+	var songs []model.Song
+	if err := songDB.Order("created_at DESC").Limit(playStatLimit(query)).Find(&songs).Error; err != nil {
+		return nil, err
+	}
+
+	stats := make([]PlayStat, len(songs))
+	for i, song := range songs {
+		stats[i] = PlayStat{Song: song}
+	}
+	return stats, nil
+}
+
+// findPlayStats aggregates play events visible to user of songs that are not deleted, skipped plays are not counted
+func findPlayStats(db *gorm.DB, user *model.User, query url.Values, order string) ([]PlayStat, error) {
+	type aggregation struct {
+		SongID      gocrud.ID
+		PlayCount   int64
+		LastEventID gocrud.ID
+	}
+
+	aggregationDB := playEventsOf(db, user, query).
+		Select("song_id, COUNT(*) AS play_count, MAX(id) AS last_event_id").
+		Where("skipped = ?", false).
+		Where("song_id IN (SELECT songs.id FROM songs WHERE songs.deleted_at IS NULL)")
+	if collectionId := gocrud.Pick(gocrud.IDsFromCommaSeparatedString(query.Get("collectionId")), 0, 0); collectionId != 0 {
+		aggregationDB = aggregationDB.Where("song_id IN (SELECT collection_songs.song_id FROM collection_songs WHERE collection_songs.collection_id = ?)", collectionId)
+	}
+
+	var aggregations []aggregation
+	if err := aggregationDB.Group("song_id").Order(order).Limit(playStatLimit(query)).Scan(&aggregations).Error; err != nil {
+		return nil, err
+	}
+
+	stats := make([]PlayStat, 0, len(aggregations))
+	if len(aggregations) == 0 {
+		return stats, nil
+	}
+
+	songIds := make([]gocrud.ID, len(aggregations))
+	eventIds := make([]gocrud.ID, len(aggregations))
+	for i, a := range aggregations {
+		songIds[i] = a.SongID
+		eventIds[i] = a.LastEventID
+	}
+
+	var songs []model.Song
+	if err := db.Model(&songs).Where("id IN ?", songIds).Find(&songs).Error; err != nil {
+		return nil, err
+	}
+
+	var events []model.PlayEvent
+	if err := db.Model(&events).Where("id IN ?", eventIds).Find(&events).Error; err != nil {
+		return nil, err
+	}
+
+	for _, a := range aggregations {
+		for _, song := range songs {
+			if song.ID != a.SongID {
+				continue
+			}
+
+			stat := PlayStat{Song: song, PlayCount: a.PlayCount}
+			for _, event := range events {
+				if event.ID == a.LastEventID {
+					stat.LastPlayedAt = gocrud.Pointer(event.CreatedAt)
+				}
+			}
+			stats = append(stats, stat)
+		}
+	}
+
+	return stats, nil
+}
+
+// recordPlayEvent records a play started by a streaming endpoint,
+// clients that report plays on their own should request with ?record=false
+func recordPlayEvent(db *gorm.DB, context *gin.Context, songId gocrud.ID, source model.PlaySource) {
+	if context.Query("record") == "false" {
+		return
+	}
+
+	client := context.Query("client")
+	if client == "" {
+		client = context.Request.UserAgent()
+	}
+
+	if err := db.Create(&model.PlayEvent{SongID: songId, UserID: auth.CurrentUser(context).ID, Source: source, Client: client}).Error; err != nil {
+		l.Warn().Printf("failed to record play event of song %d: %v", songId, err)
+	}
+}
@@ -0,0 +1,76 @@
+package controller
+
+import (
+	"github.com/allape/gocrud"
+	"github.com/allape/homesong/model"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"net/url"
+	"testing"
+)
+
+func TestPlayStatsOfListener(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&model.Song{}, &model.CollectionSong{}, &model.PlayEvent{}); err != nil {
+		t.Fatal(err)
+	}
+
+	songs := []model.Song{{Name: "Waterloo"}, {Name: "Mamma Mia"}, {Name: "Fernando"}}
+	if err := db.Create(&songs).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	admin := &model.User{Base: gocrud.Base{ID: 1}, Role: model.UserRoleAdmin}
+	bob := &model.User{Base: gocrud.Base{ID: 2}, Role: model.UserRoleListener}
+	events := []model.PlayEvent{
+		{SongID: songs[0].ID, UserID: bob.ID},
+		{SongID: songs[0].ID, UserID: bob.ID},
+		{SongID: songs[1].ID, UserID: 3},
+	}
+	if err := db.Create(&events).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		name     string
+		user     *model.User
+		userId   string
+		expected map[string]int64
+	}{
+		{"listeners see their own plays", bob, "", map[string]int64{"Waterloo": 2}},
+		{"listeners can not see plays of others", bob, "3", map[string]int64{}},
+		{"admins see plays of everyone", admin, "", map[string]int64{"Waterloo": 2, "Mamma Mia": 1}},
+		{"admins see plays of anyone", admin, "3", map[string]int64{"Mamma Mia": 1}},
+	} {
+		stats, err := findPlayStats(db, c.user, url.Values{"userId": {c.userId}}, "play_count DESC")
+		if err != nil {
+			t.Fatal(c.name, err)
+		} else if len(stats) != len(c.expected) {
+			t.Fatal(c.name, "unexpected stats", stats)
+		}
+		for _, stat := range stats {
+			if stat.PlayCount != c.expected[stat.Song.Name] {
+				t.Fatal(c.name, "unexpected stat", stat)
+			}
+		}
+	}
+
+	// songs played only by others are never played by bob
+	stats, err := findNeverPlayed(db, bob, url.Values{"userId": {"3"}})
+	if err != nil {
+		t.Fatal(err)
+	} else if len(stats) != 3 {
+		t.Fatal("unexpected never played songs", stats)
+	}
+
+	stats, err = findNeverPlayed(db, admin, url.Values{"userId": {"3"}})
+	if err != nil {
+		t.Fatal(err)
+	} else if len(stats) != 2 || stats[0].Song.Name == "Mamma Mia" || stats[1].Song.Name == "Mamma Mia" {
+		t.Fatal("unexpected never played songs", stats)
+	}
+}
@@ -0,0 +1,452 @@
+package controller
+
+import (
+	"github.com/allape/gocrud"
+	"github.com/allape/homesong/auth"
+	"github.com/allape/homesong/ffmpeg"
+	"github.com/allape/homesong/model"
+	"github.com/allape/homesong/playlist"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type PlaylistFormat string
+
+const (
+	PlaylistFormatM3U8 PlaylistFormat = "m3u8"
+	PlaylistFormatXSPF PlaylistFormat = "xspf"
+)
+
+// requestBaseURL returns the scheme and host the client used, forwarded headers are only trusted from trusted proxies
+func requestBaseURL(context *gin.Context) string {
+	scheme := gocrud.Ternary(context.Request.TLS != nil, "https", "http")
+	host := context.Request.Host
+	if auth.IsTrustedProxy(context) {
+		if proto := context.GetHeader("X-Forwarded-Proto"); proto != "" {
+			scheme = proto
+		}
+		if forwardedHost := context.GetHeader("X-Forwarded-Host"); forwardedHost != "" {
+			host = forwardedHost
+		}
+	}
+	return scheme + "://" + host
+}
+
+// DefaultExportTokenTTL is the lifetime of tokens embedded in exported playlists without ?expiresIn=, in hours
+const DefaultExportTokenTTL = 7 * 24
+
+// exportCollection writes songs of a collection as a playlist file with absolute stream urls.
+// ?profile= points urls to hotwire instead of original files,
+// ?withToken=true creates a stream only API token for the urls, which expires in ?expiresIn= hours, or never with 0.
+// Tokens are only created by POST, so that prefetchers and players fetching the url again do not pile them up.
+func exportCollection(context *gin.Context, db *gorm.DB, format PlaylistFormat) {
+	collectionId := gocrud.Pick(gocrud.IDsFromCommaSeparatedString(context.Param("id")), 0, 0)
+	collection, ok := findVisibleCollection(context, db, collectionId)
+	if !ok {
+		return
+	}
+
+	query := url.Values{}
+
+	profile := context.Query("profile")
+	if profile != "" {
+		if _, ok := ffmpeg.Profiles[ffmpeg.ProfileName(profile)]; !ok {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), "profile not found")
+			return
+		}
+		query.Set("profile", profile)
+	}
+
+	if user := auth.CurrentUser(context); context.Query("withToken") == "true" && user.ID != 0 {
+		if context.Request.Method != http.MethodPost {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), "withToken requires POST")
+			return
+		}
+
+		var expiresAt *time.Time
+		expiresIn, err := strconv.ParseInt(context.DefaultQuery("expiresIn", strconv.Itoa(DefaultExportTokenTTL)), 10, 64)
+		if err != nil || expiresIn < 0 {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), "invalid expiresIn")
+			return
+		} else if expiresIn > 0 {
+			expiresAt = gocrud.Pointer(time.Now().Add(time.Duration(expiresIn) * time.Hour))
+		}
+
+		token, _, err := auth.NewApiToken(db, user, "Export of "+collection.Name, []model.TokenScope{model.TokenScopeStream}, expiresAt)
+		if err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
+			return
+		}
+		query.Set(auth.TokenQuery, token)
+	}
+
+	songs, err := findSongsOfCollection(db, collection)
+	if err != nil {
+		gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
+		return
+	}
+
+	songIds := make([]gocrud.ID, len(songs))
+	for i, song := range songs {
+		songIds[i] = song.ID
+	}
+
+	songCollections, err := findSongCollections(db, songIds)
+	if err != nil {
+		gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
+		return
+	}
+
+	songURL := requestBaseURL(context) + "/api/song/" + gocrud.Ternary(profile == "", "stream/", "hotwire/")
+	encodedQuery := gocrud.Ternary(len(query) > 0, "?"+query.Encode(), "")
+
+	entries := make([]playlist.Entry, 0, len(songs))
+	for _, song := range songs {
+		if song.Filename == "" {
+			continue
+		}
+
+		entry := playlist.Entry{
+			Location: songURL + strconv.FormatUint(uint64(song.ID), 10) + encodedQuery,
+			Title:    song.Name,
+			Duration: song.Duration,
+		}
+
+		artist, album := artistAndAlbumOf(songCollections, song.ID)
+		if artist != nil {
+			entry.Artist = artist.Name
+		}
+		if album != nil {
+			entry.Album = album.Name
+		}
+
+		entries = append(entries, entry)
+	}
+
+	var (
+		body        []byte
+		contentType string
+	)
+	switch format {
+	case PlaylistFormatM3U8:
+		body = playlist.WriteM3U8(collection.Name, entries)
+		contentType = "audio/x-mpegurl; charset=utf-8"
+	case PlaylistFormatXSPF:
+		body, err = playlist.WriteXSPF(collection.Name, entries)
+		if err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
+			return
+		}
+		contentType = "application/xspf+xml; charset=utf-8"
+	}
+
+	context.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+		"filename": collection.Name + "." + string(format),
+	}))
+	context.Data(http.StatusOK, contentType, body)
+}
+
+// PlaylistImportMaxSize limits uploaded playlist files
+const PlaylistImportMaxSize = 16 << 20
+
+type playlistImportReport struct {
+	Collection model.Collection       `json:"collection"`
+	Matched    int                    `json:"matched"`
+	Unmatched  []model.UnmatchedEntry `json:"unmatched"`
+}
+
+// importPlaylist creates a playlist from an uploaded M3U, XSPF or CSV file in form field "file",
+// with optional form fields "name" and "visibility". Entries which match no song are kept as model.UnmatchedEntry.
+func importPlaylist(context *gin.Context, db *gorm.DB) {
+	fileHeader, err := context.FormFile("file")
+	if err != nil {
+		gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), err)
+		return
+	} else if fileHeader.Size > PlaylistImportMaxSize {
+		gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), "file too large")
+		return
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), err)
+		return
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	content, err := io.ReadAll(io.LimitReader(file, PlaylistImportMaxSize))
+	if err != nil {
+		gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), err)
+		return
+	}
+
+	format, err := playlist.DetectFormat(fileHeader.Filename, content)
+	if err != nil {
+		gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), err)
+		return
+	}
+
+	name, entries, err := playlist.Parse(format, content)
+	if err != nil {
+		gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), err)
+		return
+	}
+
+	if formName := strings.TrimSpace(context.PostForm("name")); formName != "" {
+		name = formName
+	} else if name == "" {
+		name = strings.TrimSpace(strings.TrimSuffix(path.Base(fileHeader.Filename), path.Ext(fileHeader.Filename)))
+	}
+	if name == "" {
+		gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), "name is required")
+		return
+	}
+
+	visibility := model.Visibility(gocrud.Ternary(context.PostForm("visibility") == "", string(model.VisibilityShared), context.PostForm("visibility")))
+	if !slices.Contains(model.Visibilities, visibility) {
+		gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), "visibility not found")
+		return
+	}
+
+	user := auth.CurrentUser(context)
+
+	var exist model.Collection
+	if err := db.Model(&exist).Where("`name` = ? AND `type` = ? AND owner_id = ? AND deleted_at IS NULL", name, model.CollectionTypeSong, user.ID).First(&exist).Error; err == nil {
+		gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), "name already exists")
+		return
+	}
+
+	matcher, err := newSongMatcher(db)
+	if err != nil {
+		gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
+		return
+	}
+
+	report := playlistImportReport{
+		Collection: model.Collection{
+			Type:       model.CollectionTypeSong,
+			Name:       name,
+			OwnerID:    user.ID,
+			Visibility: visibility,
+		},
+		Unmatched: []model.UnmatchedEntry{},
+	}
+
+	var collectionSongs []model.CollectionSong
+	for _, entry := range entries {
+		songId, err := matcher.Match(entry)
+		if err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
+			return
+		}
+
+		if songId == 0 {
+			report.Unmatched = append(report.Unmatched, model.UnmatchedEntry{
+				Line:     entry.Line,
+				Location: entry.Location,
+				Title:    entry.Title,
+				Artist:   entry.Artist,
+				Album:    entry.Album,
+				Duration: entry.Duration,
+			})
+			continue
+		}
+
+		report.Matched++
+		if slices.ContainsFunc(collectionSongs, func(collectionSong model.CollectionSong) bool {
+			return collectionSong.SongID == songId
+		}) {
+			continue
+		}
+		collectionSongs = append(collectionSongs, model.CollectionSong{
+			SongID:   songId,
+			Role:     model.Reserved,
+			Position: int32(len(collectionSongs) + 1),
+		})
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&report.Collection).Error; err != nil {
+			return err
+		}
+		for i := range collectionSongs {
+			collectionSongs[i].CollectionID = report.Collection.ID
+		}
+		for i := range report.Unmatched {
+			report.Unmatched[i].CollectionID = report.Collection.ID
+		}
+		if len(collectionSongs) > 0 {
+			if err := tx.Create(&collectionSongs).Error; err != nil {
+				return err
+			}
+		}
+		if len(report.Unmatched) > 0 {
+			if err := tx.Create(&report.Unmatched).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
+		return
+	}
+
+	context.JSON(http.StatusOK, gocrud.R[playlistImportReport]{Code: gocrud.RestCoder.OK(), Data: report})
+}
+
+// streamURLPattern matches urls written by exportCollection, so exported playlists can be imported back
+var streamURLPattern = regexp.MustCompile(`/api/song/(?:stream|hotwire)/(\d+)(?:[?#]|$)`)
+
+// songMatcher matches playlist entries to songs by stream urls and digests in file names,
+// then loosely by name and artist, names are guessed from file names for plain M3U entries
+type songMatcher struct {
+	db       *gorm.DB
+	songs    []model.Song
+	names    []string // normalized names of songs
+	byID     map[gocrud.ID]bool
+	byDigest map[string]gocrud.ID // stored files are named after their digests
+}
+
+func newSongMatcher(db *gorm.DB) (*songMatcher, error) {
+	matcher := &songMatcher{
+		db:       db,
+		byID:     map[gocrud.ID]bool{},
+		byDigest: map[string]gocrud.ID{},
+	}
+
+	err := db.Model(&model.Song{}).
+		Select("id, name, digest, duration").
+		Where("deleted_at IS NULL").
+		Order("id").
+		Find(&matcher.songs).Error
+	if err != nil {
+		return nil, err
+	}
+
+	matcher.names = make([]string, len(matcher.songs))
+	for i, song := range matcher.songs {
+		matcher.names[i] = playlist.Normalize(song.Name)
+		matcher.byID[song.ID] = true
+		if song.Digest != "" {
+			if _, ok := matcher.byDigest[song.Digest]; !ok {
+				matcher.byDigest[song.Digest] = song.ID
+			}
+		}
+	}
+
+	return matcher, nil
+}
+
+// Match returns the id of the song best matching entry, or 0 if there is none
+func (m *songMatcher) Match(entry playlist.Entry) (gocrud.ID, error) {
+	if entry.Location != "" {
+		if match := streamURLPattern.FindStringSubmatch(entry.Location); match != nil {
+			if id, err := strconv.ParseUint(match[1], 10, 64); err == nil && m.byID[gocrud.ID(id)] {
+				return gocrud.ID(id), nil
+			}
+		}
+
+		if id, ok := m.byDigest[strings.ToLower(entry.BaseName())]; ok {
+			return id, nil
+		}
+	}
+
+	artist, title := entry.Guess()
+	title = playlist.Normalize(title)
+	if title == "" {
+		return 0, nil
+	}
+
+	var candidates []int
+	for i, name := range m.names {
+		if playlist.Similar(title, name) {
+			candidates = append(candidates, i)
+		}
+	}
+	if len(candidates) == 0 {
+		return 0, nil
+	}
+
+	songIds := make([]gocrud.ID, len(candidates))
+	for i, candidate := range candidates {
+		songIds[i] = m.songs[candidate].ID
+	}
+	songCollections, err := findSongCollections(m.db, songIds)
+	if err != nil {
+		return 0, err
+	}
+
+	artist = playlist.Normalize(artist)
+
+	var (
+		best      gocrud.ID
+		bestScore = -1
+	)
+	for _, candidate := range candidates {
+		song := m.songs[candidate]
+
+		score := 0
+		if m.names[candidate] == title {
+			score += 2
+		}
+		if entry.Duration > 0 && song.Duration > 0 && math.Abs(entry.Duration-song.Duration) <= 3 {
+			score++
+		}
+
+		if artist != "" {
+			known, matched := false, false
+			for _, songCollection := range songCollections {
+				if songCollection.SongID != song.ID || songCollection.Type != model.CollectionTypeArtist {
+					continue
+				}
+				known = true
+				name := playlist.Normalize(songCollection.Name)
+				// entries may list more than one artist, like "ABBA, Someone"
+				matched = matched || (name != "" && (strings.Contains(artist, name) || strings.Contains(name, artist)))
+			}
+			if known && !matched {
+				continue
+			} else if matched {
+				score += 2
+			}
+		}
+
+		if score > bestScore {
+			best, bestScore = song.ID, score
+		}
+	}
+
+	return best, nil
+}
+
+// findUnmatchedEntry makes an error response and returns false if the entry is not found or its playlist is not editable
+func findUnmatchedEntry(context *gin.Context, db *gorm.DB) (*model.UnmatchedEntry, *model.Collection, bool) {
+	id := gocrud.Pick(gocrud.IDsFromCommaSeparatedString(context.Param("id")), 0, 0)
+
+	var entry model.UnmatchedEntry
+	if err := db.Model(&entry).First(&entry, id).Error; err != nil {
+		gocrud.MakeErrorResponse(context, gocrud.RestCoder.NotFound(), err)
+		return nil, nil, false
+	}
+
+	collection, ok := findEditableCollection(context, db, entry.CollectionID)
+	if !ok {
+		return nil, nil, false
+	}
+
+	return &entry, collection, true
+}
@@ -0,0 +1,184 @@
+package controller
+
+import (
+	"github.com/allape/gocrud"
+	"github.com/allape/homesong/auth"
+	"github.com/allape/homesong/lyrics"
+	"github.com/allape/homesong/model"
+	"github.com/allape/homesong/search"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	DefaultSearchLimit = 10
+	MaxSearchLimit     = 50
+	SearchSnippetWidth = 80 // in runes
+)
+
+type SearchHit struct {
+	ID        gocrud.ID            `json:"id"`
+	Name      string               `json:"name"`
+	Type      model.CollectionType `json:"type,omitempty"`    // of collections
+	SongIDs   []gocrud.ID          `json:"songIds,omitempty"` // of lyrics
+	Score     float64              `json:"score"`
+	Highlight string               `json:"highlight"` // name as HTML, with terms wrapped in <mark>
+	Snippet   string               `json:"snippet"`   // the matched line of other columns as HTML, empty if only the name matches
+}
+
+type SearchResult struct {
+	Mode        search.Mode `json:"mode"`
+	Terms       []string    `json:"terms"`
+	Songs       []SearchHit `json:"songs"`
+	Collections []SearchHit `json:"collections"`
+	Lyrics      []SearchHit `json:"lyrics"`
+}
+
+// searchHitsOf makes SearchHits of records in the order of hits, records not found are skipped
+func searchHitsOf[T any](hits []search.Hit, records []T, id func(T) gocrud.ID, hit func(T) SearchHit) []SearchHit {
+	searchHits := make([]SearchHit, 0, len(hits))
+	for _, h := range hits {
+		index := slices.IndexFunc(records, func(record T) bool {
+			return id(record) == h.ID
+		})
+		if index == -1 {
+			continue
+		}
+		searchHit := hit(records[index])
+		searchHit.ID = h.ID
+		searchHit.Score = h.Score
+		searchHits = append(searchHits, searchHit)
+	}
+	return searchHits
+}
+
+func idsOf(hits []search.Hit) []gocrud.ID {
+	ids := make([]gocrud.ID, len(hits))
+	for i, hit := range hits {
+		ids[i] = hit.ID
+	}
+	return ids
+}
+
+// SetupSearchController serves search with mode, which is returned by search.Setup
+func SetupSearchController(group *gin.RouterGroup, db *gorm.DB, mode search.Mode) error {
+	// ?q=waterloo&types=songs,collections,lyrics&limit=10
+	group.GET("", func(context *gin.Context) {
+		terms := search.Terms(context.Query("q"))
+		if len(terms) == 0 {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), "q not found")
+			return
+		}
+
+		limit, err := strconv.Atoi(context.Query("limit"))
+		if err != nil || limit <= 0 {
+			limit = DefaultSearchLimit
+		}
+		limit = min(limit, MaxSearchLimit)
+
+		types := []string{"songs", "collections", "lyrics"}
+		if value := context.Query("types"); value != "" {
+			types = strings.Split(value, ",")
+		}
+
+		result := SearchResult{
+			Mode:        mode,
+			Terms:       terms,
+			Songs:       make([]SearchHit, 0),
+			Collections: make([]SearchHit, 0),
+			Lyrics:      make([]SearchHit, 0),
+		}
+
+		if slices.Contains(types, "songs") {
+			hits, err := mode.Match(db.Where("songs.deleted_at IS NULL"), search.Songs, terms, limit)
+			if err != nil {
+				gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
+				return
+			}
+			var songs []model.Song
+			if err := db.Model(&songs).Where("id IN ?", idsOf(hits)).Find(&songs).Error; err != nil {
+				gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
+				return
+			}
+			result.Songs = searchHitsOf(hits, songs, func(song model.Song) gocrud.ID {
+				return song.ID
+			}, func(song model.Song) SearchHit {
+				return SearchHit{
+					Name:      song.Name,
+					Highlight: search.Highlight(song.Name, terms),
+					Snippet:   search.Snippet(song.Description, terms, SearchSnippetWidth),
+				}
+			})
+		}
+
+		if slices.Contains(types, "collections") {
+			scope := whereCollectionVisible(db.Where("collections.deleted_at IS NULL"), auth.CurrentUser(context), "collections")
+			hits, err := mode.Match(scope, search.Collections, terms, limit)
+			if err != nil {
+				gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
+				return
+			}
+			var collections []model.Collection
+			if err := db.Model(&collections).Where("id IN ?", idsOf(hits)).Find(&collections).Error; err != nil {
+				gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
+				return
+			}
+			result.Collections = searchHitsOf(hits, collections, func(collection model.Collection) gocrud.ID {
+				return collection.ID
+			}, func(collection model.Collection) SearchHit {
+				return SearchHit{
+					Name:      collection.Name,
+					Type:      collection.Type,
+					Highlight: search.Highlight(collection.Name, terms),
+					Snippet:   search.Snippet(collection.Keywords, terms, SearchSnippetWidth),
+				}
+			})
+		}
+
+		if slices.Contains(types, "lyrics") {
+			hits, err := mode.Match(db.Where("lyrics.deleted_at IS NULL"), search.Lyrics, terms, limit)
+			if err != nil {
+				gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
+				return
+			}
+			ids := idsOf(hits)
+			var records []model.Lyrics
+			if err := db.Model(&records).Where("id IN ?", ids).Find(&records).Error; err != nil {
+				gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
+				return
+			}
+			var songLyrics []model.SongLyrics
+			if err := db.Model(&songLyrics).
+				Where("lyrics_id IN ? AND song_id IN (SELECT id FROM songs WHERE deleted_at IS NULL)", ids).
+				Order("song_id ASC").
+				Find(&songLyrics).Error; err != nil {
+				gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
+				return
+			}
+			result.Lyrics = searchHitsOf(hits, records, func(record model.Lyrics) gocrud.ID {
+				return record.ID
+			}, func(record model.Lyrics) SearchHit {
+				var songIds []gocrud.ID
+				for _, sl := range songLyrics {
+					if sl.LyricsID == record.ID {
+						songIds = append(songIds, sl.SongID)
+					}
+				}
+				return SearchHit{
+					Name:      record.Name,
+					SongIDs:   songIds,
+					Highlight: search.Highlight(record.Name, terms),
+					Snippet:   search.Snippet(lyrics.PlainText(record.Content), terms, SearchSnippetWidth),
+				}
+			})
+		}
+
+		context.JSON(http.StatusOK, gocrud.R[SearchResult]{Code: gocrud.RestCoder.OK(), Data: result})
+	})
+
+	return nil
+}
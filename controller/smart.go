@@ -0,0 +1,164 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"github.com/allape/gocrud"
+	"github.com/allape/homesong/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// SmartRuleLimit is the rule key for the max number of songs of a smart playlist, it is not a search handler
+const SmartRuleLimit = "limit"
+
+// parseSmartRules parses and validates rules of a smart playlist, keys must be songSearchHandlers or SmartRuleLimit
+func parseSmartRules(rules string) (url.Values, error) {
+	values, err := url.ParseQuery(strings.TrimPrefix(strings.TrimSpace(rules), "?"))
+	if err != nil {
+		return nil, err
+	} else if len(values) == 0 {
+		return nil, errors.New("rules are required")
+	}
+
+	for key := range values {
+		if key == SmartRuleLimit {
+			if limit, err := strconv.Atoi(values.Get(key)); err != nil || limit < 0 {
+				return nil, fmt.Errorf("invalid rule %s: %s", key, values.Get(key))
+			}
+		} else if _, ok := songSearchHandlers[key]; !ok || key == "deleted" {
+			return nil, fmt.Errorf("unknown rule: %s", key)
+		}
+	}
+
+	return values, nil
+}
+
+// smartSongsDB makes a query of songs matching rules of a smart playlist through songSearchHandlers,
+// collections in rules are followed only if they are visible to the owner of the smart playlist
+func smartSongsDB(db *gorm.DB, smart *model.Collection) *gorm.DB {
+	songDB := db.Model(&model.Song{}).Where("songs.deleted_at IS NULL")
+
+	values, err := parseSmartRules(smart.Rules)
+	if err != nil {
+		// broken rules match nothing instead of everything
+		return songDB.Where("1 = 0")
+	}
+
+	if collectionIds, ok := values["in_collectionId"]; ok {
+		newDB := db.Session(&gorm.Session{NewDB: true})
+		owner := model.User{Role: model.UserRoleListener}
+		if smart.OwnerID != 0 {
+			if err := newDB.Model(&owner).Where("id = ? AND deleted_at IS NULL", smart.OwnerID).First(&owner).Error; err != nil {
+				return songDB.Where("1 = 0")
+			}
+		}
+		for i, value := range collectionIds {
+			visible, err := visibleCollectionIds(newDB, &owner, gocrud.IDsFromCommaSeparatedString(value))
+			if err != nil {
+				_ = songDB.AddError(err)
+				return songDB
+			} else if len(visible) == 0 {
+				return songDB.Where("1 = 0")
+			}
+			collectionIds[i] = joinIds(visible)
+		}
+	}
+
+	sorted := false
+	for key, value := range values {
+		if handler, ok := songSearchHandlers[key]; ok {
+			songDB = handler(songDB, reversed(value), values)
+			sorted = sorted || strings.HasPrefix(key, "orderBy_")
+		}
+	}
+	if !sorted {
+		songDB = songDB.Order("songs.id")
+	}
+
+	if limit, _ := strconv.Atoi(values.Get(SmartRuleLimit)); limit > 0 {
+		songDB = songDB.Limit(limit)
+	}
+
+	return songDB
+}
+
+// searchSongsInCollections is the in_collectionId search handler of songs which also follows rules of smart playlists,
+// songs of a single collection are sorted by their positions, or by the rules of the smart playlist, unless sorted otherwise
+func searchSongsInCollections(db *gorm.DB, values []string, with url.Values) *gorm.DB {
+	ok, value := gocrud.ValuableArray(values)
+	if !ok {
+		return db
+	}
+	ids := gocrud.IDsFromCommaSeparatedString(value)
+	if len(ids) == 0 {
+		return db
+	}
+
+	newDB := db.Session(&gorm.Session{NewDB: true})
+
+	var smarts []model.Collection
+	if err := newDB.Model(&smarts).Where("id IN ? AND type = ? AND deleted_at IS NULL", ids, model.CollectionTypeSmart).Find(&smarts).Error; err != nil {
+		_ = db.AddError(err)
+		return db
+	}
+
+	condition := newDB.Where("songs.id IN (SELECT collection_songs.song_id FROM collection_songs WHERE collection_songs.collection_id IN ?)", ids)
+	for _, smart := range smarts {
+		// the derived table works around LIMIT in IN subqueries of MySQL
+		condition = condition.Or(fmt.Sprintf("songs.id IN (SELECT id FROM (?) AS smart_%d)", smart.ID), smartSongsDB(newDB, &smart).Select("songs.id"))
+	}
+	db = db.Where(condition)
+
+	for key := range with {
+		if strings.HasPrefix(key, "orderBy_") {
+			return db
+		}
+	}
+
+	if len(ids) == 1 && len(smarts) == 1 {
+		rules, _ := parseSmartRules(smarts[0].Rules)
+		for key, value := range rules {
+			if strings.HasPrefix(key, "orderBy_") {
+				db = songSearchHandlers[key](db, reversed(value), rules)
+			}
+		}
+		return db.Order("songs.id")
+	} else if len(ids) == 1 {
+		return db.Order(clause.OrderBy{Expression: clause.Expr{
+			SQL:  "(SELECT MIN(collection_songs.position) FROM collection_songs WHERE collection_songs.song_id = songs.id AND collection_songs.collection_id = ?), songs.id",
+			Vars: []any{ids[0]},
+		}})
+	}
+	return db
+}
+
+// reversed returns a reversed copy of values, search handlers of gocrud take the last value of a key first
+func reversed(values []string) []string {
+	values = slices.Clone(values)
+	slices.Reverse(values)
+	return values
+}
+
+// fillSmartStats counts songs and sums durations up for smart playlists in collections, which have no collection_songs
+func fillSmartStats(db *gorm.DB, collections []model.Collection, stats map[gocrud.ID]collectionStat) error {
+	for _, collection := range collections {
+		if collection.Type != model.CollectionTypeSmart {
+			continue
+		}
+		stat := collectionStat{CollectionID: collection.ID}
+		err := db.Table("(?) AS smart_songs", smartSongsDB(db, &collection).Select("songs.duration")).
+			Select("COUNT(*) AS song_count, COALESCE(SUM(smart_songs.duration), 0) AS duration").
+			Scan(&stat).Error
+		if err != nil {
+			return err
+		}
+		stat.CollectionID = collection.ID
+		stats[collection.ID] = stat
+	}
+	return nil
+}
@@ -1,59 +1,116 @@
 package controller
 
 import (
-	"bytes"
+	"cmp"
 	"encoding/json"
 	"fmt"
 	"github.com/allape/gocrud"
+	"github.com/allape/homesong/auth"
 	"github.com/allape/homesong/env"
 	"github.com/allape/homesong/ffmpeg"
+	"github.com/allape/homesong/ingest"
 	"github.com/allape/homesong/model"
 	"github.com/gin-gonic/gin"
-	"github.com/h2non/filetype"
 	"gorm.io/gorm"
 	"io"
+	"maps"
 	"net/http"
 	"net/url"
 	"os"
 	"path"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 )
 
-func SetupSongController(group *gin.RouterGroup, db *gorm.DB) error {
-	err := gocrud.New(group, db, gocrud.Crud[model.Song]{
-		DisableSave:     true,
-		EnableGetAll:    true,
-		DefaultPageSize: DefaultPageSize,
-		SearchHandlers: map[string]gocrud.SearchHandler{
-			"like_name":         gocrud.KeywordLike("name", nil),
-			"in_id":             gocrud.KeywordIDIn("id", gocrud.OverflowedArrayTrimmerFilter[gocrud.ID](DefaultPageSize)),
-			"deleted":           gocrud.NewSoftDeleteSearchHandler("songs"),
-			"orderBy_index":     gocrud.SortBy("index"),
-			"orderBy_createdAt": gocrud.SortBy("created_at"),
-			"orderBy_updatedAt": gocrud.SortBy("updated_at"),
-			"in_collectionId": func(db *gorm.DB, values []string, with url.Values) *gorm.DB {
-				if ok, value := gocrud.ValuableArray(values); ok {
-					ids := gocrud.IDsFromCommaSeparatedString(value)
-					if len(ids) == 0 {
-						return db
-					}
-					return db.Where("id IN (SELECT collection_songs.song_id FROM collection_songs WHERE collection_songs.collection_id IN ?)", ids)
-				}
+const (
+	SimilarDurationTolerance = 10.0 // in seconds
+	SimilarMaxOffset         = 108  // about 5 seconds of sub-fingerprints
+	SimilarMaxLength         = 1024 // about 47 seconds of sub-fingerprints
+)
+
+type SimilarSong struct {
+	Song       model.Song `json:"song"`
+	Similarity float64    `json:"similarity"` // 1 - bit error rate
+}
+
+// songSearchHandlers are shared by song queries and rules of smart playlists
+var songSearchHandlers = map[string]gocrud.SearchHandler{
+	"like_name":         gocrud.KeywordLike("name", nil),
+	"in_id":             gocrud.KeywordIDIn("id", gocrud.OverflowedArrayTrimmerFilter[gocrud.ID](DefaultPageSize)),
+	"deleted":           gocrud.NewSoftDeleteSearchHandler("songs"),
+	"orderBy_index":     gocrud.SortBy("index"),
+	"orderBy_createdAt": gocrud.SortBy("created_at"),
+	"orderBy_updatedAt": gocrud.SortBy("updated_at"),
+	"gte_duration":      gocrud.KeywordStatement("duration", gocrud.OperatorGte, gocrud.NumericValidate),
+	"lte_duration":      gocrud.KeywordStatement("duration", gocrud.OperatorLte, gocrud.NumericValidate),
+	"gte_bitRate":       gocrud.KeywordStatement("bit_rate", gocrud.OperatorGte, gocrud.NumericValidate),
+	"lte_bitRate":       gocrud.KeywordStatement("bit_rate", gocrud.OperatorLte, gocrud.NumericValidate),
+	"gte_sampleRate":    gocrud.KeywordStatement("sample_rate", gocrud.OperatorGte, gocrud.NumericValidate),
+	"lte_sampleRate":    gocrud.KeywordStatement("sample_rate", gocrud.OperatorLte, gocrud.NumericValidate),
+	"in_channels":       gocrud.KeywordIn("channels", nil),
+	"in_codec":          gocrud.KeywordIn("codec", nil),
+	"in_container":      gocrud.KeywordIn("container", nil),
+	"orderBy_duration":  gocrud.SortBy("duration"),
+	"orderBy_bitRate":   gocrud.SortBy("bit_rate"),
+	// songs linked to any of the collections, smart playlists are not followed
+	"in_collectionId": func(db *gorm.DB, values []string, with url.Values) *gorm.DB {
+		if ok, value := gocrud.ValuableArray(values); ok {
+			ids := gocrud.IDsFromCommaSeparatedString(value)
+			if len(ids) == 0 {
 				return db
-			},
-			"like_collectionName": func(db *gorm.DB, values []string, with url.Values) *gorm.DB {
-				if ok, value := gocrud.ValuableArray(values); ok {
-					return db.Where(`
+			}
+			return db.Where("id IN (SELECT collection_songs.song_id FROM collection_songs WHERE collection_songs.collection_id IN ?)", ids)
+		}
+		return db
+	},
+	"like_collectionName": func(db *gorm.DB, values []string, with url.Values) *gorm.DB {
+		if ok, value := gocrud.ValuableArray(values); ok {
+			return db.Where(`
 id IN (
 	SELECT collection_songs.song_id FROM collection_songs 
 	LEFT JOIN collections ON collection_songs.collection_id = collections.id
 	WHERE collections.name LIKE ?
 )
-					`, fmt.Sprintf("%%%s%%", value))
-				}
-				return db
-			},
-		},
+			`, fmt.Sprintf("%%%s%%", value))
+		}
+		return db
+	},
+	// ?addedInDays=30
+	"addedInDays": func(db *gorm.DB, values []string, with url.Values) *gorm.DB {
+		if ok, value := gocrud.ValuableArray(values); ok {
+			if days, err := strconv.ParseFloat(value, 64); err == nil && days > 0 {
+				return db.Where("created_at >= ?", time.Now().Add(-time.Duration(days*float64(24*time.Hour))))
+			}
+		}
+		return db
+	},
+	// ?neverPlayed=true for songs without any play, false for songs played at least once
+	"neverPlayed": func(db *gorm.DB, values []string, with url.Values) *gorm.DB {
+		if ok, value := gocrud.ValuableArray(values); ok {
+			played := "id IN (SELECT play_events.song_id FROM play_events)"
+			if value == "true" {
+				return db.Where("NOT " + played)
+			} else if value == "false" {
+				return db.Where(played)
+			}
+		}
+		return db
+	},
+}
+
+func SetupSongController(group *gin.RouterGroup, db *gorm.DB) error {
+	group.Use(onlyVisibleCollections(db))
+
+	searchHandlers := maps.Clone(songSearchHandlers)
+	searchHandlers["in_collectionId"] = searchSongsInCollections
+
+	err := gocrud.New(group, db, gocrud.Crud[model.Song]{
+		DisableSave:     true,
+		EnableGetAll:    true,
+		DefaultPageSize: DefaultPageSize,
+		SearchHandlers:  searchHandlers,
 		WillGetAll: func(context *gin.Context, db *gorm.DB) *gorm.DB {
 			collectionId := gocrud.IDsFromCommaSeparatedString(context.Query("in_collectionId"))
 			if len(collectionId) == 0 {
@@ -70,6 +127,7 @@ id IN (
 		return err
 	}
 
+	auth.AcceptScopes(group, http.MethodPut, "/upload", model.TokenScopeUpload)
 	group.PUT("/upload", func(context *gin.Context) {
 		form, err := context.MultipartForm()
 		if err != nil {
@@ -90,12 +148,17 @@ id IN (
 			return
 		}
 
+		// ?importTags=true, fill name and index from tags, then link artist and album collections
+		importTags := context.Query("importTags") == "true"
+
 		song.Name = strings.TrimSpace(song.Name)
-		if song.Name == "" {
+		if song.Name == "" && !importTags {
 			gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), "name cannot be empty")
 			return
 		}
 
+		var tags *ffmpeg.SongTags
+
 		songFormFile := form.File["file"]
 		if len(songFormFile) > 0 {
 			songFile, err := songFormFile[0].Open()
@@ -107,52 +170,11 @@ id IN (
 				_ = songFile.Close()
 			}()
 
-			filename, digest, err := gocrud.SaveAsDigestedFile(env.StaticFolder, songFormFile[0].Filename, songFile, songFormFile[0].Size, "")
+			tags, err = ingest.SaveFile(&song, songFormFile[0].Filename, songFile, songFormFile[0].Size, importTags)
 			if err != nil {
 				gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
 				return
 			}
-			song.Filename = string(filename)
-			song.Digest = string(digest)
-
-			fullpath := path.Join(env.StaticFolder, string(filename))
-
-			mime, err := filetype.MatchFile(fullpath)
-			if err != nil {
-				gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), err)
-				return
-			}
-			song.MIME = mime.MIME.Value
-
-			ffprobe, ffprobeJson, err := ffmpeg.FFProbe(fullpath)
-			if err != nil {
-				gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
-				return
-			}
-			song.FFProbeInfo = ffprobeJson
-
-			if song.Cover == "" {
-				coverBytes, coverExt, err := ffmpeg.ExtractCover(fullpath, ffprobe)
-				if err != nil {
-					gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
-					return
-				}
-
-				if len(coverBytes) > 0 {
-					cover, _, err := gocrud.SaveAsDigestedFile(
-						env.StaticFolder,
-						"cover"+ffmpeg.GetExtByCodecName(coverExt),
-						bytes.NewReader(coverBytes),
-						int64(len(coverBytes)),
-						"",
-					)
-					if err != nil {
-						gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
-						return
-					}
-					song.Cover = string(cover)
-				}
-			}
 		}
 		// empty file is allowed
 		//else if song.ID == 0 {
@@ -160,11 +182,28 @@ id IN (
 		//	return
 		//}
 
-		if err := db.Save(&song).Error; err != nil {
+		if song.Name == "" {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), "name cannot be empty")
+			return
+		}
+
+		if err := ingest.SaveSong(db, &song, tags); err != nil {
 			gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
 			return
 		}
 
+		if len(songFormFile) > 0 {
+			// the fingerprint of the replaced file no longer matches, if the new one failed to be fingerprinted
+			if song.Fingerprint == "" {
+				if err := ingest.SaveFingerprint(db, song.ID, ""); err != nil {
+					l.Warn().Printf("failed to delete fingerprint of song %d: %v", song.ID, err)
+				}
+			}
+			if _, err := ingest.LinkEmbeddedLyrics(db, &song); err != nil {
+				l.Warn().Printf("failed to extract lyrics of song %d: %v", song.ID, err)
+			}
+		}
+
 		context.JSON(http.StatusOK, gocrud.R[model.Song]{Code: gocrud.RestCoder.OK(), Data: song})
 	})
 
@@ -204,6 +243,7 @@ id IN (
 		context.Data(http.StatusOK, "image/"+ext, cover)
 	})
 
+	auth.AcceptScopes(group, http.MethodGet, "/hotwire/:id", model.TokenScopeStream, model.TokenScopeRead)
 	group.GET("/hotwire/:id", func(context *gin.Context) {
 		id := gocrud.Pick(gocrud.IDsFromCommaSeparatedString(context.Param("id")), 0, 0)
 		if id == 0 {
@@ -220,16 +260,205 @@ id IN (
 			return
 		}
 
-		filename := path.Join(env.StaticFolder, song.Filename)
+		profile, ok := ffmpeg.Profiles[ffmpeg.ProfileName(context.DefaultQuery("profile", string(ffmpeg.DefaultProfile)))]
+		if !ok {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), "profile not found")
+			return
+		}
+
+		copyAudio := songPassthrough(&song, profile)
 
-		context.Header("Content-Type", "audio/mpeg")
-		context.Writer.WriteHeaderNow()
-		context.Writer.Flush()
+		recordPlayEvent(db, context, song.ID, model.PlaySourceHotwire)
 
-		err := ffmpeg.ConvertToMp3(filename, context.Writer)
+		serveTranscodedSong(context, &song, profile, copyAudio)
+	})
+
+	auth.AcceptScopes(group, http.MethodGet, "/hls/:id/*filename", model.TokenScopeStream, model.TokenScopeRead)
+	group.GET("/hls/:id/*filename", func(context *gin.Context) {
+		id := gocrud.Pick(gocrud.IDsFromCommaSeparatedString(context.Param("id")), 0, 0)
+		if id == 0 {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), "id not found")
+			return
+		}
+
+		var song model.Song
+		if err := db.Model(&song).First(&song, id).Error; err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
+			return
+		} else if song.Filename == "" {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), "file not found")
+			return
+		} else if len(song.Digest) < 4 {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), "digest not found")
+			return
+		}
+
+		folder := path.Join(env.CacheFolder, "hls", song.Digest[:2], song.Digest[2:4], song.Digest)
+
+		err := ffmpeg.GenerateHLS(path.Join(env.StaticFolder, song.Filename), folder)
 		if err != nil {
-			l.Error().Println(err)
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
+			return
+		}
+
+		filename := path.Base(path.Clean("/" + context.Param("filename")))
+		if filename == "/" {
+			filename = ffmpeg.HLSPlaylist
+		}
+
+		if filename == ffmpeg.HLSPlaylist {
+			recordPlayEvent(db, context, song.ID, model.PlaySourceHLS)
+		}
+
+		context.Header("Content-Type", ffmpeg.GetHLSContentType(filename))
+
+		// players do not carry ?token= to variants and segments by themselves
+		if token := context.Query(auth.TokenQuery); token != "" && path.Ext(filename) == ".m3u8" {
+			playlist, err := os.ReadFile(path.Join(folder, filename))
+			if err != nil {
+				gocrud.MakeErrorResponse(context, gocrud.RestCoder.NotFound(), err)
+				return
+			}
+			query := url.Values{auth.TokenQuery: {token}}.Encode()
+			context.Data(http.StatusOK, ffmpeg.GetHLSContentType(filename), ffmpeg.AppendQueryToPlaylist(playlist, query))
+			return
+		}
+
+		context.File(path.Join(folder, filename))
+	})
+
+	group.PUT("/fingerprint/:id", func(context *gin.Context) {
+		id := gocrud.Pick(gocrud.IDsFromCommaSeparatedString(context.Param("id")), 0, 0)
+		if id == 0 {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), "id not found")
+			return
+		}
+
+		var song model.Song
+		if err := db.Model(&song).First(&song, id).Error; err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
+			return
+		}
+
+		if err := ingest.FillFingerprint(db, &song); err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
+			return
+		}
+
+		context.JSON(http.StatusOK, gocrud.R[model.Song]{Code: gocrud.RestCoder.OK(), Data: song})
+	})
+
+	// ?threshold=0.35, max bit error rate of fingerprints
+	group.GET("/similar/:id", func(context *gin.Context) {
+		id := gocrud.Pick(gocrud.IDsFromCommaSeparatedString(context.Param("id")), 0, 0)
+		if id == 0 {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), "id not found")
+			return
+		}
+
+		threshold, err := strconv.ParseFloat(context.DefaultQuery("threshold", ""), 64)
+		if err != nil || threshold <= 0 {
+			threshold = ffmpeg.DefaultFingerprintThreshold
+		}
+
+		var song model.Song
+		if err := db.Model(&song).First(&song, id).Error; err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
+			return
+		}
+
+		fingerprints, err := ingest.FindFingerprints(db, []gocrud.ID{song.ID})
+		if err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
+			return
+		}
+
+		// fingerprinting runs ffmpeg, which is left to admins with PUT /fingerprint/:id
+		if fingerprints[song.ID] == "" {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.FromStatus(http.StatusConflict), "fingerprint not found")
+			return
+		}
+
+		fingerprint, err := ffmpeg.ParseAcousticFingerprint(fingerprints[song.ID])
+		if err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
+			return
+		}
+
+		candidatesDB := db.Model(&model.Song{}).Where("id != ? AND deleted_at IS NULL AND id IN (SELECT song_fingerprints.song_id FROM song_fingerprints)", song.ID)
+		if song.Duration > 0 {
+			// re-encoding barely changes duration, besides silence trimmed at both ends
+			tolerance := max(SimilarDurationTolerance, song.Duration*0.1)
+			candidatesDB = candidatesDB.Where("duration BETWEEN ? AND ?", song.Duration-tolerance, song.Duration+tolerance)
+		}
+
+		var candidates []model.Song
+		if err := candidatesDB.Find(&candidates).Error; err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
+			return
+		}
+
+		candidateIds := make([]gocrud.ID, len(candidates))
+		for i, candidate := range candidates {
+			candidateIds[i] = candidate.ID
+		}
+		candidateFingerprints, err := ingest.FindFingerprints(db, candidateIds)
+		if err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
+			return
+		}
+
+		similarSongs := make([]SimilarSong, 0)
+		for _, candidate := range candidates {
+			candidateFingerprint, err := ffmpeg.ParseAcousticFingerprint(candidateFingerprints[candidate.ID])
+			if err != nil {
+				l.Warn().Printf("invalid fingerprint of song %d: %v", candidate.ID, err)
+				continue
+			}
+
+			bitErrorRate := ffmpeg.CompareAcousticFingerprints(fingerprint, candidateFingerprint, SimilarMaxOffset, SimilarMaxLength)
+			if bitErrorRate < threshold {
+				similarSongs = append(similarSongs, SimilarSong{Song: candidate, Similarity: 1 - bitErrorRate})
+			}
+		}
+
+		slices.SortFunc(similarSongs, func(a, b SimilarSong) int {
+			return cmp.Compare(b.Similarity, a.Similarity)
+		})
+
+		context.JSON(http.StatusOK, gocrud.R[[]SimilarSong]{Code: gocrud.RestCoder.OK(), Data: similarSongs})
+	})
+
+	auth.AcceptScopes(group, http.MethodGet, "/hotwire-profiles", model.TokenScopeStream, model.TokenScopeRead)
+	group.GET("/hotwire-profiles", func(context *gin.Context) {
+		profiles := make([]ffmpeg.Profile, 0, len(ffmpeg.Profiles))
+		for _, profile := range ffmpeg.Profiles {
+			profiles = append(profiles, profile)
+		}
+		slices.SortFunc(profiles, func(a, b ffmpeg.Profile) int {
+			return strings.Compare(string(a.Name), string(b.Name))
+		})
+		context.JSON(http.StatusOK, gocrud.R[[]ffmpeg.Profile]{Code: gocrud.RestCoder.OK(), Data: profiles})
+	})
+
+	auth.AcceptScopes(group, http.MethodGet, "/stream/:id", model.TokenScopeStream, model.TokenScopeRead)
+	group.GET("/stream/:id", func(context *gin.Context) {
+		id := gocrud.Pick(gocrud.IDsFromCommaSeparatedString(context.Param("id")), 0, 0)
+		if id == 0 {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), "id not found")
+			return
+		}
+
+		var song model.Song
+		if err := db.Model(&song).First(&song, id).Error; err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
+			return
+		} else if song.Filename == "" {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), "file not found")
+			return
 		}
+
+		serveSongFile(db, context, &song, model.PlaySourceStream)
 	})
 
 	// ?lyricsIds=1,2,3
@@ -278,6 +507,33 @@ id IN (
 		context.JSON(http.StatusOK, gocrud.R[[]model.SongLyrics]{Code: gocrud.RestCoder.OK(), Data: songLyrics})
 	})
 
+	// links lyrics embedded in the file of a song, which are not linked yet
+	group.PUT("/lyrics/:id/rescan", func(context *gin.Context) {
+		id := gocrud.Pick(gocrud.IDsFromCommaSeparatedString(context.Param("id")), 0, 0)
+		if id == 0 {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), "id not found")
+			return
+		}
+
+		var song model.Song
+		if err := db.Model(&song).Where("deleted_at IS NULL").First(&song, id).Error; err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.NotFound(), err)
+			return
+		}
+
+		created, err := ingest.LinkEmbeddedLyrics(db, &song)
+		if err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
+			return
+		}
+
+		context.JSON(http.StatusOK, gocrud.R[[]model.Lyrics]{Code: gocrud.RestCoder.OK(), Data: gocrud.Ternary(created == nil, []model.Lyrics{}, created)})
+	})
+
+	group.GET("/lyrics/:id/merged", func(context *gin.Context) {
+		mergeSongLyrics(context, db)
+	})
+
 	group.GET("/lyrics/:id", func(context *gin.Context) {
 		id := gocrud.Pick(gocrud.IDsFromCommaSeparatedString(context.Param("id")), 0, 0)
 		if id == 0 {
@@ -303,3 +559,111 @@ id IN (
 
 	return nil
 }
+
+// BackfillSongAudioInfo fills the technical info columns of songs uploaded before they existed
+func BackfillSongAudioInfo(db *gorm.DB) error {
+	var songs []model.Song
+	if err := db.Model(&songs).Where("codec = '' OR codec IS NULL").Where("ff_probe_info != ''").Find(&songs).Error; err != nil {
+		return err
+	}
+
+	for _, song := range songs {
+		ffprobe, err := ffmpeg.ParseFFProbeJson(song.FFProbeInfo)
+		if err != nil {
+			l.Warn().Printf("failed to parse ffprobe info of song %d: %v", song.ID, err)
+			continue
+		}
+
+		ingest.FillAudioInfo(&song, ffprobe)
+
+		if err := db.Model(&song).Select("duration", "bit_rate", "sample_rate", "channels", "codec", "container").Updates(&song).Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// songPassthrough tells whether the audio stream of song can be copied into profile without re-encoding
+func songPassthrough(song *model.Song, profile ffmpeg.Profile) bool {
+	if ffprobe, err := ffmpeg.ParseFFProbeJson(song.FFProbeInfo); err == nil {
+		if stream := ffprobe.AudioStream(); stream != nil {
+			return profile.Passthrough(stream.CodecName)
+		}
+	}
+	return false
+}
+
+// serveSongFile serves the original file of song with Range support, source is left empty to skip recording plays
+func serveSongFile(db *gorm.DB, context *gin.Context, song *model.Song, source model.PlaySource) {
+	file, err := os.Open(path.Join(env.StaticFolder, song.Filename))
+	if err != nil {
+		gocrud.MakeErrorResponse(context, gocrud.RestCoder.NotFound(), err)
+		return
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	stat, err := file.Stat()
+	if err != nil {
+		gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
+		return
+	}
+
+	if song.MIME != "" {
+		context.Header("Content-Type", song.MIME)
+	}
+	if song.Digest != "" {
+		// http.ServeContent handles Range, If-Range and If-None-Match against this ETag
+		context.Header("ETag", fmt.Sprintf(`"%s"`, song.Digest))
+	}
+
+	// seeking requests ranges in the middle, only the beginning counts as a play
+	if rangeHeader := context.GetHeader("Range"); source != "" && (rangeHeader == "" || strings.HasPrefix(rangeHeader, "bytes=0-")) {
+		recordPlayEvent(db, context, song.ID, source)
+	}
+
+	http.ServeContent(context.Writer, context.Request, path.Base(song.Filename), stat.ModTime(), file)
+}
+
+// serveTranscodedSong streams song transcoded into profile, and caches the result by digest
+func serveTranscodedSong(context *gin.Context, song *model.Song, profile ffmpeg.Profile, copyAudio bool) {
+	filename := path.Join(env.StaticFolder, song.Filename)
+
+	if len(song.Digest) < 4 {
+		context.Header("Content-Type", profile.MIME)
+		context.Writer.WriteHeaderNow()
+		context.Writer.Flush()
+
+		err := ffmpeg.Transcode(filename, profile, copyAudio, context.Writer)
+		if err != nil {
+			l.Error().Println(err)
+		}
+		return
+	}
+
+	cacheFile := path.Join(
+		env.CacheFolder,
+		"transcode",
+		song.Digest[:2],
+		song.Digest[2:4],
+		fmt.Sprintf("%s.%s%s", song.Digest, profile.Name, profile.Ext),
+	)
+
+	context.Header("Content-Type", profile.MIME)
+
+	if stat, err := os.Stat(cacheFile); err == nil && !stat.IsDir() {
+		context.Header("ETag", fmt.Sprintf(`"%s.%s"`, song.Digest, profile.Name))
+		context.File(cacheFile)
+		return
+	}
+
+	context.Writer.WriteHeaderNow()
+	context.Writer.Flush()
+
+	err := ffmpeg.TranscodeWithCache(filename, cacheFile, profile, copyAudio, context.Writer)
+	if err != nil {
+		l.Error().Println(err)
+	}
+}
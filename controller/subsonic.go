@@ -0,0 +1,1380 @@
+package controller
+
+import (
+	"errors"
+	"github.com/allape/gocrud"
+	"github.com/allape/homesong/auth"
+	"github.com/allape/homesong/ffmpeg"
+	"github.com/allape/homesong/lyrics"
+	"github.com/allape/homesong/model"
+	"github.com/allape/homesong/subsonic"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"math"
+	"net/http"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	SubsonicMusicFolderID   = 1
+	SubsonicDefaultListSize = 10
+	SubsonicMaxListSize     = 500
+)
+
+var (
+	subsonicReadScopes   = []model.TokenScope{model.TokenScopeRead}
+	subsonicStreamScopes = []model.TokenScope{model.TokenScopeStream, model.TokenScopeRead}
+	subsonicWriteScopes  = []model.TokenScope{model.TokenScopeAdmin}
+)
+
+type subsonicCount struct {
+	ID    gocrud.ID
+	Count int64
+}
+
+type subsonicPair struct {
+	ID      gocrud.ID
+	OtherID gocrud.ID
+	Count   int64
+}
+
+func subsonicInt(context *gin.Context, name string, defaultValue, maxValue int) int {
+	value, err := strconv.Atoi(context.Request.FormValue(name))
+	if err != nil || value < 0 {
+		return defaultValue
+	}
+	return min(value, maxValue)
+}
+
+func subsonicValues(context *gin.Context, name string) []string {
+	_ = context.Request.ParseForm()
+	return context.Request.Form[name]
+}
+
+// subsonicIDs picks ids of kind from repeated params like ?id=1&id=2
+func subsonicIDs(context *gin.Context, name, kind string) []gocrud.ID {
+	var ids []gocrud.ID
+	for _, value := range subsonicValues(context, name) {
+		if k, id := subsonic.ParseID(value); k == kind && id != 0 {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+func subsonicRequiredID(context *gin.Context, name, kind string) (gocrud.ID, bool) {
+	value := context.Request.FormValue(name)
+	if value == "" {
+		subsonic.Fail(context, subsonic.ErrorMissingParameter, "required parameter is missing: "+name)
+		return 0, false
+	}
+	k, id := subsonic.ParseID(value)
+	if k != kind || id == 0 {
+		subsonic.Fail(context, subsonic.ErrorNotFound, "not found: "+value)
+		return 0, false
+	}
+	return id, true
+}
+
+func subsonicFail(context *gin.Context, err error) {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		subsonic.Fail(context, subsonic.ErrorNotFound, err.Error())
+		return
+	}
+	subsonic.Fail(context, subsonic.ErrorGeneric, err.Error())
+}
+
+func subsonicAuthenticate(db *gorm.DB) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		user, apiToken, err := auth.SubsonicLogin(db, context)
+		if err != nil {
+			code := subsonic.ErrorGeneric
+			switch {
+			case errors.Is(err, auth.ErrorMissingCredentials):
+				code = subsonic.ErrorMissingParameter
+			case errors.Is(err, auth.ErrorTokenAuthNotSupported):
+				code = subsonic.ErrorTokenAuthNotSupported
+			case errors.Is(err, auth.ErrorConflictingAuth):
+				code = subsonic.ErrorConflictingAuth
+			case errors.Is(err, auth.ErrorInvalidApiKey):
+				code = subsonic.ErrorInvalidApiKey
+			case errors.Is(err, auth.ErrorInvalidCredentials), errors.Is(err, auth.ErrorUnknownUser):
+				code = subsonic.ErrorWrongCredentials
+			}
+			subsonic.Fail(context, code, err.Error())
+			return
+		}
+
+		if err := auth.CheckScopes(context, apiToken); err != nil {
+			subsonic.Fail(context, subsonic.ErrorNotAuthorized, err.Error())
+			return
+		}
+
+		context.Set(auth.UserKey, user)
+		if apiToken != nil {
+			context.Set(auth.ApiTokenKey, apiToken)
+		}
+
+		context.Next()
+	}
+}
+
+func findSubsonicPlayCounts(db *gorm.DB, songIds []gocrud.ID) (map[gocrud.ID]int64, error) {
+	counts := map[gocrud.ID]int64{}
+	if len(songIds) == 0 {
+		return counts, nil
+	}
+	var rows []subsonicCount
+	if err := db.Model(&model.PlayEvent{}).Select("song_id AS id, COUNT(*) AS count").Where("song_id IN ? AND skipped = ?", songIds, false).Group("song_id").Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		counts[row.ID] = row.Count
+	}
+	return counts, nil
+}
+
+// findSubsonicStarred returns when songs were put into the favorites of user
+func findSubsonicStarred(db *gorm.DB, user *model.User, songIds []gocrud.ID) (map[gocrud.ID]time.Time, error) {
+	starred := map[gocrud.ID]time.Time{}
+	if len(songIds) == 0 {
+		return starred, nil
+	}
+	var rows []model.CollectionSong
+	err := db.Model(&model.CollectionSong{}).
+		Select("collection_songs.*").
+		Joins("JOIN collections ON collections.id = collection_songs.collection_id").
+		Where("collections.type = ? AND collections.owner_id = ? AND collections.deleted_at IS NULL", model.CollectionTypeFavorite, user.ID).
+		Where("collection_songs.song_id IN ?", songIds).
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		starred[row.SongID] = row.CreatedAt
+	}
+	return starred, nil
+}
+
+func subsonicChildren(db *gorm.DB, user *model.User, songs []model.Song) ([]subsonic.Child, error) {
+	children := make([]subsonic.Child, 0, len(songs))
+	if len(songs) == 0 {
+		return children, nil
+	}
+
+	songIds := make([]gocrud.ID, len(songs))
+	for i, song := range songs {
+		songIds[i] = song.ID
+	}
+
+	collectionSongs, err := findSongCollections(db, songIds)
+	if err != nil {
+		return nil, err
+	}
+
+	playCounts, err := findSubsonicPlayCounts(db, songIds)
+	if err != nil {
+		return nil, err
+	}
+
+	starred, err := findSubsonicStarred(db, user, songIds)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, song := range songs {
+		child := subsonic.Child{
+			ID:           subsonic.SongID(song.ID),
+			Title:        song.Name,
+			Track:        int(song.Index),
+			ContentType:  song.MIME,
+			Suffix:       strings.TrimPrefix(path.Ext(song.Filename), "."),
+			Duration:     int(math.Round(song.Duration)),
+			BitRate:      int(song.BitRate / 1000),
+			SamplingRate: int(song.SampleRate),
+			ChannelCount: int(song.Channels),
+			PlayCount:    playCounts[song.ID],
+			Created:      gocrud.Pointer(song.CreatedAt),
+			Type:         "music",
+			MediaType:    "song",
+		}
+
+		if song.Cover != "" {
+			child.CoverArt = child.ID
+		}
+
+		if starredAt, ok := starred[song.ID]; ok {
+			child.Starred = gocrud.Pointer(starredAt)
+		}
+
+		artist, album := artistAndAlbumOf(collectionSongs, song.ID)
+		if album != nil {
+			child.AlbumID = subsonic.AlbumID(album.CollectionID)
+			if album.Track > 0 {
+				child.Track = int(album.Track)
+				child.DiscNumber = int(album.Disc)
+			}
+			child.Parent = child.AlbumID
+			child.Album = album.Name
+			if child.CoverArt == "" && album.Cover != "" {
+				child.CoverArt = child.AlbumID
+			}
+		}
+		if artist != nil {
+			child.ArtistID = subsonic.ArtistID(artist.CollectionID)
+			child.Artist = artist.Name
+		}
+
+		children = append(children, child)
+	}
+
+	return children, nil
+}
+
+func subsonicAlbums(db *gorm.DB, albums []model.Collection) ([]subsonic.Album, error) {
+	results := make([]subsonic.Album, 0, len(albums))
+	if len(albums) == 0 {
+		return results, nil
+	}
+
+	albumIds := make([]gocrud.ID, len(albums))
+	for i, album := range albums {
+		albumIds[i] = album.ID
+	}
+
+	stats, err := findCollectionStats(db, albumIds)
+	if err != nil {
+		return nil, err
+	}
+
+	// the artist of an album is the one who sings most of its songs
+	var artistPairs []subsonicPair
+	err = db.Table("collection_songs AS album_songs").
+		Select("album_songs.collection_id AS id, artist_songs.collection_id AS other_id, COUNT(*) AS count").
+		Joins("JOIN collection_songs AS artist_songs ON artist_songs.song_id = album_songs.song_id").
+		Joins("JOIN collections AS artists ON artists.id = artist_songs.collection_id AND artists.type = ? AND artists.deleted_at IS NULL", model.CollectionTypeArtist).
+		Where("album_songs.collection_id IN ?", albumIds).
+		Group("album_songs.collection_id, artist_songs.collection_id").
+		Order("count DESC").
+		Scan(&artistPairs).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var artistIds []gocrud.ID
+	for _, pair := range artistPairs {
+		artistIds = append(artistIds, pair.OtherID)
+	}
+	var artists []model.Collection
+	if len(artistIds) > 0 {
+		if err := db.Model(&artists).Where("id IN ?", artistIds).Find(&artists).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	var playCounts []subsonicCount
+	err = db.Table("play_events").
+		Select("collection_songs.collection_id AS id, COUNT(*) AS count").
+		Joins("JOIN collection_songs ON collection_songs.song_id = play_events.song_id").
+		Where("collection_songs.collection_id IN ? AND play_events.skipped = ?", albumIds, false).
+		Group("collection_songs.collection_id").
+		Scan(&playCounts).Error
+	if err != nil {
+		return nil, err
+	}
+
+	for _, album := range albums {
+		result := subsonic.Album{
+			ID:        subsonic.AlbumID(album.ID),
+			Name:      album.Name,
+			CoverArt:  subsonic.AlbumID(album.ID),
+			SongCount: stats[album.ID].SongCount,
+			Duration:  int(math.Round(stats[album.ID].Duration)),
+			Created:   album.CreatedAt,
+		}
+
+		if i := slices.IndexFunc(artistPairs, func(pair subsonicPair) bool { return pair.ID == album.ID }); i != -1 {
+			if j := slices.IndexFunc(artists, func(artist model.Collection) bool { return artist.ID == artistPairs[i].OtherID }); j != -1 {
+				result.ArtistID = subsonic.ArtistID(artists[j].ID)
+				result.Artist = artists[j].Name
+			}
+		}
+
+		if i := slices.IndexFunc(playCounts, func(count subsonicCount) bool { return count.ID == album.ID }); i != -1 {
+			result.PlayCount = playCounts[i].Count
+		}
+
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+func subsonicArtists(db *gorm.DB, artists []model.Collection) ([]subsonic.Artist, error) {
+	results := make([]subsonic.Artist, 0, len(artists))
+	if len(artists) == 0 {
+		return results, nil
+	}
+
+	artistIds := make([]gocrud.ID, len(artists))
+	for i, artist := range artists {
+		artistIds[i] = artist.ID
+	}
+
+	var albumCounts []subsonicCount
+	err := db.Table("collection_songs AS artist_songs").
+		Select("artist_songs.collection_id AS id, COUNT(DISTINCT album_songs.collection_id) AS count").
+		Joins("JOIN collection_songs AS album_songs ON album_songs.song_id = artist_songs.song_id").
+		Joins("JOIN collections AS albums ON albums.id = album_songs.collection_id AND albums.type = ? AND albums.deleted_at IS NULL", model.CollectionTypeAlbum).
+		Where("artist_songs.collection_id IN ?", artistIds).
+		Group("artist_songs.collection_id").
+		Scan(&albumCounts).Error
+	if err != nil {
+		return nil, err
+	}
+
+	for _, artist := range artists {
+		result := subsonic.Artist{
+			ID:   subsonic.ArtistID(artist.ID),
+			Name: artist.Name,
+		}
+		if artist.Cover != "" {
+			result.CoverArt = result.ID
+		}
+		if i := slices.IndexFunc(albumCounts, func(count subsonicCount) bool { return count.ID == artist.ID }); i != -1 {
+			result.AlbumCount = int(albumCounts[i].Count)
+		}
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+func subsonicPlaylists(db *gorm.DB, playlists []model.Collection) ([]subsonic.Playlist, error) {
+	results := make([]subsonic.Playlist, 0, len(playlists))
+	if len(playlists) == 0 {
+		return results, nil
+	}
+
+	playlistIds := make([]gocrud.ID, len(playlists))
+	ownerIds := make([]gocrud.ID, len(playlists))
+	for i, playlist := range playlists {
+		playlistIds[i] = playlist.ID
+		ownerIds[i] = playlist.OwnerID
+	}
+
+	stats, err := findCollectionStats(db, playlistIds)
+	if err != nil {
+		return nil, err
+	}
+	if err := fillSmartStats(db, playlists, stats); err != nil {
+		return nil, err
+	}
+
+	var owners []model.User
+	if err := db.Model(&owners).Where("id IN ?", ownerIds).Find(&owners).Error; err != nil {
+		return nil, err
+	}
+
+	for _, playlist := range playlists {
+		result := subsonic.Playlist{
+			ID:        subsonic.PlaylistID(playlist.ID),
+			Name:      playlist.Name,
+			Comment:   playlist.Description,
+			Public:    playlist.Visibility != model.VisibilityPrivate,
+			SongCount: stats[playlist.ID].SongCount,
+			Duration:  int(math.Round(stats[playlist.ID].Duration)),
+			Created:   playlist.CreatedAt,
+			Changed:   playlist.UpdatedAt,
+			CoverArt:  subsonic.PlaylistID(playlist.ID),
+		}
+		if i := slices.IndexFunc(owners, func(owner model.User) bool { return owner.ID == playlist.OwnerID }); i != -1 {
+			result.Owner = owners[i].Username
+		}
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+// findSubsonicAlbumsOf returns albums which contain songs of artist
+func findSubsonicAlbumsOf(db *gorm.DB, artistId gocrud.ID) ([]model.Collection, error) {
+	var albums []model.Collection
+	err := db.Model(&model.Collection{}).
+		Where("collections.type = ? AND collections.deleted_at IS NULL", model.CollectionTypeAlbum).
+		Where("collections.id IN (?)", db.Table("collection_songs AS album_songs").
+			Select("album_songs.collection_id").
+			Joins("JOIN collection_songs AS artist_songs ON artist_songs.song_id = album_songs.song_id").
+			Where("artist_songs.collection_id = ?", artistId)).
+		Order("collections.name").
+		Find(&albums).Error
+	return albums, err
+}
+
+// findSubsonicAlbumList selects albums for getAlbumList and getAlbumList2
+func findSubsonicAlbumList(db *gorm.DB, context *gin.Context) ([]model.Collection, error) {
+	size := subsonicInt(context, "size", SubsonicDefaultListSize, SubsonicMaxListSize)
+	offset := subsonicInt(context, "offset", 0, math.MaxInt32)
+
+	albumDB := db.Model(&model.Collection{}).
+		Select("collections.*").
+		Where("collections.type = ? AND collections.deleted_at IS NULL", model.CollectionTypeAlbum).
+		Limit(size).
+		Offset(offset)
+
+	switch context.Request.FormValue("type") {
+	case "random":
+		albumDB = albumDB.Order(randomOrder(db))
+	case "newest":
+		albumDB = albumDB.Order("collections.created_at DESC")
+	case "alphabeticalByName", "alphabeticalByArtist":
+		albumDB = albumDB.Order("collections.name")
+	case "frequent", "highest":
+		albumDB = albumDB.
+			Joins("JOIN collection_songs ON collection_songs.collection_id = collections.id").
+			Joins("JOIN play_events ON play_events.song_id = collection_songs.song_id AND play_events.skipped = ?", false).
+			Group("collections.id").
+			Order("COUNT(play_events.id) DESC")
+	case "recent":
+		albumDB = albumDB.
+			Joins("JOIN collection_songs ON collection_songs.collection_id = collections.id").
+			Joins("JOIN play_events ON play_events.song_id = collection_songs.song_id").
+			Group("collections.id").
+			Order("MAX(play_events.id) DESC")
+	case "":
+		return nil, errors.New("required parameter is missing: type")
+	default:
+		// starred, byYear and byGenre, which have nothing to do with what HomeSong records
+		return []model.Collection{}, nil
+	}
+
+	var albums []model.Collection
+	if err := albumDB.Find(&albums).Error; err != nil {
+		return nil, err
+	}
+	return albums, nil
+}
+
+func SetupSubsonicController(group *gin.RouterGroup, db *gorm.DB) error {
+	group.Use(subsonicAuthenticate(db))
+
+	handle := func(method string, scopes []model.TokenScope, handler gin.HandlerFunc) {
+		// clients call both /rest/ping and /rest/ping.view, with GET or with form POST
+		for _, relativePath := range []string{"/" + method, "/" + method + ".view"} {
+			for _, httpMethod := range []string{http.MethodGet, http.MethodPost} {
+				auth.AcceptScopes(group, httpMethod, relativePath, scopes...)
+				group.Handle(httpMethod, relativePath, handler)
+			}
+		}
+	}
+
+	writeOK := func(context *gin.Context) {
+		subsonic.Write(context, subsonic.NewResponse())
+	}
+
+	handle("ping", subsonicStreamScopes, writeOK)
+
+	handle("getLicense", subsonicStreamScopes, func(context *gin.Context) {
+		response := subsonic.NewResponse()
+		response.License = &subsonic.License{Valid: true}
+		subsonic.Write(context, response)
+	})
+
+	handle("getOpenSubsonicExtensions", subsonicStreamScopes, func(context *gin.Context) {
+		response := subsonic.NewResponse()
+		response.OpenSubsonicExtensions = subsonic.Extensions
+		subsonic.Write(context, response)
+	})
+
+	handle("getMusicFolders", subsonicReadScopes, func(context *gin.Context) {
+		response := subsonic.NewResponse()
+		response.MusicFolders = &subsonic.MusicFolders{
+			MusicFolder: []subsonic.MusicFolder{{ID: SubsonicMusicFolderID, Name: "HomeSong"}},
+		}
+		subsonic.Write(context, response)
+	})
+
+	handle("getScanStatus", subsonicReadScopes, func(context *gin.Context) {
+		var count int64
+		if err := db.Model(&model.Song{}).Where("deleted_at IS NULL").Count(&count).Error; err != nil {
+			subsonicFail(context, err)
+			return
+		}
+		response := subsonic.NewResponse()
+		response.ScanStatus = &subsonic.ScanStatus{Scanning: false, Count: count}
+		subsonic.Write(context, response)
+	})
+
+	handle("getGenres", subsonicReadScopes, func(context *gin.Context) {
+		response := subsonic.NewResponse()
+		response.Genres = &subsonic.Genres{Genre: []struct{}{}}
+		subsonic.Write(context, response)
+	})
+
+	handle("getNowPlaying", subsonicReadScopes, func(context *gin.Context) {
+		response := subsonic.NewResponse()
+		response.NowPlaying = &subsonic.NowPlaying{Entry: []subsonic.Child{}}
+		subsonic.Write(context, response)
+	})
+
+	handle("getUser", subsonicReadScopes, func(context *gin.Context) {
+		user := auth.CurrentUser(context)
+		if username := context.Request.FormValue("username"); username != "" && username != user.Username {
+			if !user.IsAdmin() {
+				subsonic.Fail(context, subsonic.ErrorNotAuthorized, auth.ErrorForbidden.Error())
+				return
+			}
+			var other model.User
+			if err := db.Model(&other).Where("username = ? AND deleted_at IS NULL", username).First(&other).Error; err != nil {
+				subsonicFail(context, err)
+				return
+			}
+			user = &other
+		}
+
+		response := subsonic.NewResponse()
+		response.User = &subsonic.User{
+			Username:          user.Username,
+			ScrobblingEnabled: true,
+			AdminRole:         user.IsAdmin(),
+			SettingsRole:      true,
+			DownloadRole:      true,
+			UploadRole:        user.IsAdmin(),
+			PlaylistRole:      true,
+			CoverArtRole:      user.IsAdmin(),
+			StreamRole:        true,
+			Folder:            []int{SubsonicMusicFolderID},
+		}
+		subsonic.Write(context, response)
+	})
+
+	getArtists := func(context *gin.Context) *subsonic.Indexes {
+		var artists []model.Collection
+		if err := db.Model(&artists).Where("type = ? AND deleted_at IS NULL", model.CollectionTypeArtist).Order("name").Find(&artists).Error; err != nil {
+			subsonicFail(context, err)
+			return nil
+		}
+
+		results, err := subsonicArtists(db, artists)
+		if err != nil {
+			subsonicFail(context, err)
+			return nil
+		}
+
+		indexes := &subsonic.Indexes{Index: []subsonic.Index{}}
+		for _, artist := range results {
+			name := subsonic.IndexName(artist.Name)
+			i := slices.IndexFunc(indexes.Index, func(index subsonic.Index) bool { return index.Name == name })
+			if i == -1 {
+				i = len(indexes.Index)
+				indexes.Index = append(indexes.Index, subsonic.Index{Name: name})
+			}
+			indexes.Index[i].Artist = append(indexes.Index[i].Artist, artist)
+		}
+
+		slices.SortStableFunc(indexes.Index, func(a, b subsonic.Index) int {
+			return strings.Compare(a.Name, b.Name)
+		})
+
+		return indexes
+	}
+
+	handle("getArtists", subsonicReadScopes, func(context *gin.Context) {
+		if artists := getArtists(context); artists != nil {
+			response := subsonic.NewResponse()
+			response.Artists = artists
+			subsonic.Write(context, response)
+		}
+	})
+
+	handle("getIndexes", subsonicReadScopes, func(context *gin.Context) {
+		if indexes := getArtists(context); indexes != nil {
+			indexes.LastModified = time.Now().UnixMilli()
+			response := subsonic.NewResponse()
+			response.Indexes = indexes
+			subsonic.Write(context, response)
+		}
+	})
+
+	handle("getArtist", subsonicReadScopes, func(context *gin.Context) {
+		id, ok := subsonicRequiredID(context, "id", subsonic.KindArtist)
+		if !ok {
+			return
+		}
+
+		artist, err := findCollectionOfTypes(db, id, model.CollectionTypeArtist)
+		if err != nil {
+			subsonicFail(context, err)
+			return
+		}
+
+		albums, err := findSubsonicAlbumsOf(db, artist.ID)
+		if err != nil {
+			subsonicFail(context, err)
+			return
+		}
+
+		artistResults, err := subsonicArtists(db, []model.Collection{*artist})
+		if err != nil {
+			subsonicFail(context, err)
+			return
+		}
+
+		albumResults, err := subsonicAlbums(db, albums)
+		if err != nil {
+			subsonicFail(context, err)
+			return
+		}
+
+		response := subsonic.NewResponse()
+		response.Artist = &subsonic.ArtistWithAlbums{Artist: artistResults[0], Album: albumResults}
+		subsonic.Write(context, response)
+	})
+
+	handle("getArtistInfo", subsonicReadScopes, func(context *gin.Context) {
+		response := subsonic.NewResponse()
+		response.ArtistInfo = &subsonic.ArtistInfo{}
+		if kind, id := subsonic.ParseID(context.Request.FormValue("id")); kind == subsonic.KindArtist {
+			if artist, err := findCollectionOfTypes(db, id, model.CollectionTypeArtist); err == nil {
+				response.ArtistInfo.Biography = artist.Description
+			}
+		}
+		subsonic.Write(context, response)
+	})
+
+	handle("getArtistInfo2", subsonicReadScopes, func(context *gin.Context) {
+		response := subsonic.NewResponse()
+		response.ArtistInfo2 = &subsonic.ArtistInfo{}
+		if kind, id := subsonic.ParseID(context.Request.FormValue("id")); kind == subsonic.KindArtist {
+			if artist, err := findCollectionOfTypes(db, id, model.CollectionTypeArtist); err == nil {
+				response.ArtistInfo2.Biography = artist.Description
+			}
+		}
+		subsonic.Write(context, response)
+	})
+
+	getAlbum := func(context *gin.Context) (*subsonic.AlbumWithSongs, bool) {
+		id, ok := subsonicRequiredID(context, "id", subsonic.KindAlbum)
+		if !ok {
+			return nil, false
+		}
+
+		album, err := findCollectionOfTypes(db, id, model.CollectionTypeAlbum)
+		if err != nil {
+			subsonicFail(context, err)
+			return nil, false
+		}
+
+		songs, err := findSongsOfCollection(db, album)
+		if err != nil {
+			subsonicFail(context, err)
+			return nil, false
+		}
+
+		albumResults, err := subsonicAlbums(db, []model.Collection{*album})
+		if err != nil {
+			subsonicFail(context, err)
+			return nil, false
+		}
+
+		children, err := subsonicChildren(db, auth.CurrentUser(context), songs)
+		if err != nil {
+			subsonicFail(context, err)
+			return nil, false
+		}
+
+		return &subsonic.AlbumWithSongs{Album: albumResults[0], Song: children}, true
+	}
+
+	handle("getAlbum", subsonicReadScopes, func(context *gin.Context) {
+		if album, ok := getAlbum(context); ok {
+			response := subsonic.NewResponse()
+			response.Album = album
+			subsonic.Write(context, response)
+		}
+	})
+
+	handle("getAlbumInfo", subsonicReadScopes, func(context *gin.Context) {
+		response := subsonic.NewResponse()
+		response.AlbumInfo = &subsonic.AlbumInfo{}
+		if kind, id := subsonic.ParseID(context.Request.FormValue("id")); kind == subsonic.KindAlbum {
+			if album, err := findCollectionOfTypes(db, id, model.CollectionTypeAlbum); err == nil {
+				response.AlbumInfo.Notes = album.Description
+			}
+		}
+		subsonic.Write(context, response)
+	})
+	handle("getAlbumInfo2", subsonicReadScopes, func(context *gin.Context) {
+		response := subsonic.NewResponse()
+		response.AlbumInfo = &subsonic.AlbumInfo{}
+		if kind, id := subsonic.ParseID(context.Request.FormValue("id")); kind == subsonic.KindAlbum {
+			if album, err := findCollectionOfTypes(db, id, model.CollectionTypeAlbum); err == nil {
+				response.AlbumInfo.Notes = album.Description
+			}
+		}
+		subsonic.Write(context, response)
+	})
+
+	// folder based browsing, artists contain albums, and albums contain songs
+	handle("getMusicDirectory", subsonicReadScopes, func(context *gin.Context) {
+		kind, id := subsonic.ParseID(context.Request.FormValue("id"))
+		switch kind {
+		case subsonic.KindArtist:
+			artist, err := findCollectionOfTypes(db, id, model.CollectionTypeArtist)
+			if err != nil {
+				subsonicFail(context, err)
+				return
+			}
+
+			albums, err := findSubsonicAlbumsOf(db, artist.ID)
+			if err != nil {
+				subsonicFail(context, err)
+				return
+			}
+
+			albumResults, err := subsonicAlbums(db, albums)
+			if err != nil {
+				subsonicFail(context, err)
+				return
+			}
+
+			directory := &subsonic.Directory{ID: subsonic.ArtistID(artist.ID), Name: artist.Name, Child: []subsonic.Child{}}
+			for _, album := range albumResults {
+				directory.Child = append(directory.Child, subsonic.Child{
+					ID:       album.ID,
+					Parent:   directory.ID,
+					IsDir:    true,
+					Title:    album.Name,
+					Album:    album.Name,
+					Artist:   artist.Name,
+					CoverArt: album.CoverArt,
+					Created:  gocrud.Pointer(album.Created),
+				})
+			}
+
+			response := subsonic.NewResponse()
+			response.Directory = directory
+			subsonic.Write(context, response)
+		case subsonic.KindAlbum:
+			album, ok := getAlbum(context)
+			if !ok {
+				return
+			}
+			response := subsonic.NewResponse()
+			response.Directory = &subsonic.Directory{ID: album.ID, Parent: album.ArtistID, Name: album.Name, Child: album.Song}
+			subsonic.Write(context, response)
+		default:
+			subsonic.Fail(context, subsonic.ErrorNotFound, "directory not found")
+		}
+	})
+
+	handle("getSong", subsonicReadScopes, func(context *gin.Context) {
+		id, ok := subsonicRequiredID(context, "id", subsonic.KindSong)
+		if !ok {
+			return
+		}
+
+		song, err := findPlayableSong(db, id)
+		if err != nil {
+			subsonicFail(context, err)
+			return
+		}
+
+		children, err := subsonicChildren(db, auth.CurrentUser(context), []model.Song{*song})
+		if err != nil {
+			subsonicFail(context, err)
+			return
+		}
+
+		response := subsonic.NewResponse()
+		response.Song = &children[0]
+		subsonic.Write(context, response)
+	})
+
+	handle("getAlbumList", subsonicReadScopes, func(context *gin.Context) {
+		albums, err := findSubsonicAlbumList(db, context)
+		if err != nil {
+			subsonicFail(context, err)
+			return
+		}
+
+		albumResults, err := subsonicAlbums(db, albums)
+		if err != nil {
+			subsonicFail(context, err)
+			return
+		}
+
+		list := &subsonic.AlbumList{Album: make([]subsonic.Child, 0, len(albumResults))}
+		for _, album := range albumResults {
+			list.Album = append(list.Album, subsonic.Child{
+				ID:       album.ID,
+				Parent:   album.ArtistID,
+				IsDir:    true,
+				Title:    album.Name,
+				Album:    album.Name,
+				Artist:   album.Artist,
+				CoverArt: album.CoverArt,
+				Created:  gocrud.Pointer(album.Created),
+			})
+		}
+
+		response := subsonic.NewResponse()
+		response.AlbumList = list
+		subsonic.Write(context, response)
+	})
+
+	handle("getAlbumList2", subsonicReadScopes, func(context *gin.Context) {
+		albums, err := findSubsonicAlbumList(db, context)
+		if err != nil {
+			subsonicFail(context, err)
+			return
+		}
+
+		albumResults, err := subsonicAlbums(db, albums)
+		if err != nil {
+			subsonicFail(context, err)
+			return
+		}
+
+		response := subsonic.NewResponse()
+		response.AlbumList2 = &subsonic.AlbumList2{Album: albumResults}
+		subsonic.Write(context, response)
+	})
+
+	handle("getRandomSongs", subsonicReadScopes, func(context *gin.Context) {
+		var songs []model.Song
+		err := db.Model(&songs).
+			Where("deleted_at IS NULL AND filename != ''").
+			Order(randomOrder(db)).
+			Limit(subsonicInt(context, "size", SubsonicDefaultListSize, SubsonicMaxListSize)).
+			Find(&songs).Error
+		if err != nil {
+			subsonicFail(context, err)
+			return
+		}
+
+		children, err := subsonicChildren(db, auth.CurrentUser(context), songs)
+		if err != nil {
+			subsonicFail(context, err)
+			return
+		}
+
+		response := subsonic.NewResponse()
+		response.RandomSongs = &subsonic.Songs{Song: children}
+		subsonic.Write(context, response)
+	})
+
+	handle("search3", subsonicReadScopes, func(context *gin.Context) {
+		// clients sync the whole library with query=""
+		keyword := "%" + strings.Trim(strings.TrimSpace(context.Request.FormValue("query")), `"`) + "%"
+
+		var artists []model.Collection
+		err := db.Model(&artists).
+			Where("type = ? AND deleted_at IS NULL AND name LIKE ?", model.CollectionTypeArtist, keyword).
+			Order("name").
+			Limit(subsonicInt(context, "artistCount", 20, SubsonicMaxListSize)).
+			Offset(subsonicInt(context, "artistOffset", 0, math.MaxInt32)).
+			Find(&artists).Error
+		if err != nil {
+			subsonicFail(context, err)
+			return
+		}
+
+		var albums []model.Collection
+		err = db.Model(&albums).
+			Where("type = ? AND deleted_at IS NULL AND name LIKE ?", model.CollectionTypeAlbum, keyword).
+			Order("name").
+			Limit(subsonicInt(context, "albumCount", 20, SubsonicMaxListSize)).
+			Offset(subsonicInt(context, "albumOffset", 0, math.MaxInt32)).
+			Find(&albums).Error
+		if err != nil {
+			subsonicFail(context, err)
+			return
+		}
+
+		var songs []model.Song
+		err = db.Model(&songs).
+			Where("deleted_at IS NULL AND filename != '' AND name LIKE ?", keyword).
+			Order("name, id").
+			Limit(subsonicInt(context, "songCount", 20, SubsonicMaxListSize)).
+			Offset(subsonicInt(context, "songOffset", 0, math.MaxInt32)).
+			Find(&songs).Error
+		if err != nil {
+			subsonicFail(context, err)
+			return
+		}
+
+		result := &subsonic.SearchResult{}
+		if result.Artist, err = subsonicArtists(db, artists); err != nil {
+			subsonicFail(context, err)
+			return
+		}
+		if result.Album, err = subsonicAlbums(db, albums); err != nil {
+			subsonicFail(context, err)
+			return
+		}
+		if result.Song, err = subsonicChildren(db, auth.CurrentUser(context), songs); err != nil {
+			subsonicFail(context, err)
+			return
+		}
+
+		response := subsonic.NewResponse()
+		response.SearchResult3 = result
+		subsonic.Write(context, response)
+	})
+
+	handle("getPlaylists", subsonicReadScopes, func(context *gin.Context) {
+		var playlists []model.Collection
+		playlistDB := db.Model(&model.Collection{}).
+			Where("collections.type IN ? AND collections.deleted_at IS NULL", collectionKinds[subsonic.KindPlaylist]).
+			Order("collections.name")
+		if err := whereCollectionVisible(playlistDB, auth.CurrentUser(context), "collections").Find(&playlists).Error; err != nil {
+			subsonicFail(context, err)
+			return
+		}
+
+		results, err := subsonicPlaylists(db, playlists)
+		if err != nil {
+			subsonicFail(context, err)
+			return
+		}
+
+		response := subsonic.NewResponse()
+		response.Playlists = &subsonic.Playlists{Playlist: results}
+		subsonic.Write(context, response)
+	})
+
+	writePlaylist := func(context *gin.Context, playlist *model.Collection) {
+		songs, err := findSongsOfCollection(db, playlist)
+		if err != nil {
+			subsonicFail(context, err)
+			return
+		}
+
+		results, err := subsonicPlaylists(db, []model.Collection{*playlist})
+		if err != nil {
+			subsonicFail(context, err)
+			return
+		}
+
+		children, err := subsonicChildren(db, auth.CurrentUser(context), songs)
+		if err != nil {
+			subsonicFail(context, err)
+			return
+		}
+
+		response := subsonic.NewResponse()
+		response.Playlist = &subsonic.PlaylistWithSongs{Playlist: results[0], Entry: children}
+		subsonic.Write(context, response)
+	}
+
+	// findPlaylist returns a visible playlist, and also checks whether it is editable if editable is true
+	findPlaylist := func(context *gin.Context, param string, editable bool) (*model.Collection, bool) {
+		id, ok := subsonicRequiredID(context, param, subsonic.KindPlaylist)
+		if !ok {
+			return nil, false
+		}
+
+		playlist, err := findCollectionOfTypes(db, id, collectionKinds[subsonic.KindPlaylist]...)
+		if err != nil {
+			subsonicFail(context, err)
+			return nil, false
+		}
+
+		user := auth.CurrentUser(context)
+		if !playlist.VisibleTo(user) {
+			subsonic.Fail(context, subsonic.ErrorNotFound, "playlist not found")
+			return nil, false
+		} else if editable && !playlist.EditableBy(user) {
+			subsonic.Fail(context, subsonic.ErrorNotAuthorized, auth.ErrorForbidden.Error())
+			return nil, false
+		} else if editable && playlist.Type == model.CollectionTypeSmart {
+			subsonic.Fail(context, subsonic.ErrorNotAuthorized, "songs of smart playlists follow their rules")
+			return nil, false
+		}
+
+		return playlist, true
+	}
+
+	handle("getPlaylist", subsonicReadScopes, func(context *gin.Context) {
+		if playlist, ok := findPlaylist(context, "id", false); ok {
+			writePlaylist(context, playlist)
+		}
+	})
+
+	handle("createPlaylist", subsonicWriteScopes, func(context *gin.Context) {
+		user := auth.CurrentUser(context)
+		songIds := subsonicIDs(context, "songId", subsonic.KindSong)
+
+		var playlist *model.Collection
+		if context.Request.FormValue("playlistId") != "" {
+			var ok bool
+			if playlist, ok = findPlaylist(context, "playlistId", true); !ok {
+				return
+			}
+		} else {
+			name := strings.TrimSpace(context.Request.FormValue("name"))
+			if name == "" {
+				subsonic.Fail(context, subsonic.ErrorMissingParameter, "required parameter is missing: name")
+				return
+			}
+
+			var exist model.Collection
+			if err := db.Model(&exist).Where("`name` = ? AND `type` = ? AND owner_id = ? AND deleted_at IS NULL", name, model.CollectionTypeSong, user.ID).First(&exist).Error; err == nil {
+				subsonic.Fail(context, subsonic.ErrorGeneric, "name already exists")
+				return
+			}
+
+			playlist = &model.Collection{
+				Type:       model.CollectionTypeSong,
+				Name:       name,
+				OwnerID:    user.ID,
+				Visibility: model.VisibilityPrivate,
+			}
+		}
+
+		// songs of an existing playlist are replaced, and kept if the new ones fail to be added
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if playlist.ID == 0 {
+				if err := tx.Create(playlist).Error; err != nil {
+					return err
+				}
+			} else if err := tx.Where("collection_id = ?", playlist.ID).Delete(&model.CollectionSong{}).Error; err != nil {
+				return err
+			}
+
+			if len(songIds) > 0 {
+				if _, err := addSongsToCollection(tx, playlist, songIds); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			subsonicFail(context, err)
+			return
+		}
+
+		writePlaylist(context, playlist)
+	})
+
+	handle("updatePlaylist", subsonicWriteScopes, func(context *gin.Context) {
+		playlist, ok := findPlaylist(context, "playlistId", true)
+		if !ok {
+			return
+		}
+
+		updates := map[string]any{}
+		if name := strings.TrimSpace(context.Request.FormValue("name")); name != "" {
+			updates["name"] = name
+		}
+		if comment, ok := context.Request.Form["comment"]; ok {
+			updates["description"] = gocrud.Pick(comment, 0, "")
+		}
+		if public := context.Request.FormValue("public"); public != "" {
+			if !playlist.ManageableBy(auth.CurrentUser(context)) {
+				subsonic.Fail(context, subsonic.ErrorNotAuthorized, auth.ErrorForbidden.Error())
+				return
+			}
+			updates["visibility"] = gocrud.Ternary(public == "true", model.VisibilityPublic, model.VisibilityPrivate)
+		}
+		if len(updates) > 0 {
+			if err := db.Model(playlist).Updates(updates).Error; err != nil {
+				subsonicFail(context, err)
+				return
+			}
+		}
+
+		if indexes := subsonicValues(context, "songIndexToRemove"); len(indexes) > 0 {
+			songs, err := findSongsOfCollection(db, playlist)
+			if err != nil {
+				subsonicFail(context, err)
+				return
+			}
+			var songIds []gocrud.ID
+			for _, index := range indexes {
+				if i, err := strconv.Atoi(index); err == nil && i >= 0 && i < len(songs) {
+					songIds = append(songIds, songs[i].ID)
+				}
+			}
+			if len(songIds) > 0 {
+				if err := db.Where("collection_id = ? AND song_id IN ?", playlist.ID, songIds).Delete(&model.CollectionSong{}).Error; err != nil {
+					subsonicFail(context, err)
+					return
+				}
+			}
+		}
+
+		if songIds := subsonicIDs(context, "songIdToAdd", subsonic.KindSong); len(songIds) > 0 {
+			if _, err := addSongsToCollection(db, playlist, songIds); err != nil {
+				subsonicFail(context, err)
+				return
+			}
+		}
+
+		writeOK(context)
+	})
+
+	handle("deletePlaylist", subsonicWriteScopes, func(context *gin.Context) {
+		playlist, ok := findPlaylist(context, "id", false)
+		if !ok {
+			return
+		} else if !playlist.ManageableBy(auth.CurrentUser(context)) {
+			subsonic.Fail(context, subsonic.ErrorNotAuthorized, auth.ErrorForbidden.Error())
+			return
+		}
+
+		if err := db.Model(playlist).UpdateColumn("deleted_at", time.Now()).Error; err != nil {
+			subsonicFail(context, err)
+			return
+		}
+
+		writeOK(context)
+	})
+
+	handle("stream", subsonicStreamScopes, func(context *gin.Context) {
+		id, ok := subsonicRequiredID(context, "id", subsonic.KindSong)
+		if !ok {
+			return
+		}
+
+		song, err := findPlayableSong(db, id)
+		if err != nil {
+			subsonicFail(context, err)
+			return
+		}
+
+		// plays are recorded by scrobble
+		profileName, transcode := subsonic.PickProfile(
+			context.Request.FormValue("format"),
+			subsonicInt(context, "maxBitRate", 0, math.MaxInt32),
+			song.BitRate,
+			song.Codec,
+		)
+		if !transcode {
+			serveSongFile(db, context, song, "")
+			return
+		}
+
+		profile := ffmpeg.Profiles[profileName]
+		serveTranscodedSong(context, song, profile, songPassthrough(song, profile))
+	})
+
+	handle("download", subsonicStreamScopes, func(context *gin.Context) {
+		id, ok := subsonicRequiredID(context, "id", subsonic.KindSong)
+		if !ok {
+			return
+		}
+
+		song, err := findPlayableSong(db, id)
+		if err != nil {
+			subsonicFail(context, err)
+			return
+		}
+
+		context.Header("Content-Disposition", `attachment; filename="`+strings.ReplaceAll(path.Base(song.Filename), `"`, "")+`"`)
+		serveSongFile(db, context, song, "")
+	})
+
+	handle("getCoverArt", subsonicStreamScopes, func(context *gin.Context) {
+		filename := findCoverFile(db, auth.CurrentUser(context), context.Request.FormValue("id"))
+		if filename == "" {
+			subsonic.Fail(context, subsonic.ErrorNotFound, "cover not found")
+			return
+		}
+		context.File(filename)
+	})
+
+	handle("getLyrics", subsonicReadScopes, func(context *gin.Context) {
+		artistName := strings.TrimSpace(context.Request.FormValue("artist"))
+		title := strings.TrimSpace(context.Request.FormValue("title"))
+
+		response := subsonic.NewResponse()
+		response.Lyrics = &subsonic.Lyrics{Artist: artistName, Title: title}
+
+		if title != "" {
+			lyricsDB := db.Model(&model.Lyrics{}).
+				Select("lyrics.*").
+				Joins("JOIN song_lyrics ON song_lyrics.lyrics_id = lyrics.id").
+				Joins("JOIN songs ON songs.id = song_lyrics.song_id AND songs.deleted_at IS NULL").
+				Where("lyrics.deleted_at IS NULL AND songs.name = ?", title)
+			if artistName != "" {
+				lyricsDB = lyricsDB.Where("songs.id IN (?)", db.Table("collection_songs").
+					Select("collection_songs.song_id").
+					Joins("JOIN collections ON collections.id = collection_songs.collection_id").
+					Where("collections.type = ? AND collections.name = ? AND collections.deleted_at IS NULL", model.CollectionTypeArtist, artistName))
+			}
+
+			var record model.Lyrics
+			if err := lyricsDB.First(&record).Error; err == nil {
+				response.Lyrics.Value = lyrics.PlainText(record.Content)
+			} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+				subsonicFail(context, err)
+				return
+			}
+		}
+
+		subsonic.Write(context, response)
+	})
+
+	handle("getLyricsBySongId", subsonicReadScopes, func(context *gin.Context) {
+		id, ok := subsonicRequiredID(context, "id", subsonic.KindSong)
+		if !ok {
+			return
+		}
+
+		song, err := findPlayableSong(db, id)
+		if err != nil {
+			subsonicFail(context, err)
+			return
+		}
+
+		var lyricsList []model.Lyrics
+		err = db.Model(&model.Lyrics{}).
+			Select("lyrics.*").
+			Joins("JOIN song_lyrics ON song_lyrics.lyrics_id = lyrics.id").
+			Where("song_lyrics.song_id = ? AND lyrics.deleted_at IS NULL", song.ID).
+			Order("lyrics.`index`, lyrics.id").
+			Find(&lyricsList).Error
+		if err != nil {
+			subsonicFail(context, err)
+			return
+		}
+
+		list := &subsonic.LyricsList{StructuredLyrics: make([]subsonic.StructuredLyrics, 0, len(lyricsList))}
+		for _, record := range lyricsList {
+			lines, synced, err := lyrics.Parse(record.Content)
+			if err != nil {
+				// saved before timestamps were validated, shown as plain text
+				lines, synced = lyrics.PlainLines(record.Content), false
+			}
+
+			structured := subsonic.StructuredLyrics{Lang: gocrud.Ternary(record.Language == "", "xxx", record.Language), DisplayTitle: song.Name, Synced: synced, Line: []subsonic.Line{}}
+			for _, line := range lines {
+				subsonicLine := subsonic.Line{Value: line.Text}
+				if synced {
+					subsonicLine.Start = gocrud.Pointer(int64(math.Round(line.Time * 1000)))
+				}
+				structured.Line = append(structured.Line, subsonicLine)
+			}
+			list.StructuredLyrics = append(list.StructuredLyrics, structured)
+		}
+
+		response := subsonic.NewResponse()
+		response.LyricsList = list
+		subsonic.Write(context, response)
+	})
+
+	handle("scrobble", []model.TokenScope{model.TokenScopeStream}, func(context *gin.Context) {
+		songIds := subsonicIDs(context, "id", subsonic.KindSong)
+		if len(songIds) == 0 {
+			subsonic.Fail(context, subsonic.ErrorMissingParameter, "required parameter is missing: id")
+			return
+		}
+
+		// submission=false only means "now playing"
+		if context.Request.FormValue("submission") == "false" {
+			writeOK(context)
+			return
+		}
+
+		times := subsonicValues(context, "time")
+		events := make([]model.PlayEvent, 0, len(songIds))
+		for i, songId := range songIds {
+			event := model.PlayEvent{
+				SongID: songId,
+				UserID: auth.CurrentUser(context).ID,
+				Source: model.PlaySourceReport,
+				Client: context.Request.FormValue("c"),
+			}
+			if i < len(times) {
+				if millis, err := strconv.ParseInt(times[i], 10, 64); err == nil && millis > 0 {
+					event.CreatedAt = time.UnixMilli(millis)
+				}
+			}
+			events = append(events, event)
+		}
+
+		if err := db.Create(&events).Error; err != nil {
+			subsonicFail(context, err)
+			return
+		}
+
+		writeOK(context)
+	})
+
+	// only songs can be starred, they are kept in the favorites of current user
+	handle("star", subsonicWriteScopes, func(context *gin.Context) {
+		favorite, err := getOrCreateFavorite(db, auth.CurrentUser(context))
+		if err != nil {
+			subsonicFail(context, err)
+			return
+		}
+		if songIds := subsonicIDs(context, "id", subsonic.KindSong); len(songIds) > 0 {
+			if _, err := addSongsToCollection(db, favorite, songIds); err != nil {
+				subsonicFail(context, err)
+				return
+			}
+		}
+		writeOK(context)
+	})
+
+	handle("unstar", subsonicWriteScopes, func(context *gin.Context) {
+		favorite, err := getOrCreateFavorite(db, auth.CurrentUser(context))
+		if err != nil {
+			subsonicFail(context, err)
+			return
+		}
+		if songIds := subsonicIDs(context, "id", subsonic.KindSong); len(songIds) > 0 {
+			if err := db.Where("collection_id = ? AND song_id IN ?", favorite.ID, songIds).Delete(&model.CollectionSong{}).Error; err != nil {
+				subsonicFail(context, err)
+				return
+			}
+		}
+		writeOK(context)
+	})
+
+	getStarred := func(context *gin.Context) *subsonic.Starred {
+		favorite, err := getOrCreateFavorite(db, auth.CurrentUser(context))
+		if err != nil {
+			subsonicFail(context, err)
+			return nil
+		}
+
+		songs, err := findSongsOfCollection(db, favorite)
+		if err != nil {
+			subsonicFail(context, err)
+			return nil
+		}
+
+		children, err := subsonicChildren(db, auth.CurrentUser(context), songs)
+		if err != nil {
+			subsonicFail(context, err)
+			return nil
+		}
+
+		return &subsonic.Starred{Artist: []subsonic.Artist{}, Album: []subsonic.Album{}, Song: children}
+	}
+
+	handle("getStarred", subsonicReadScopes, func(context *gin.Context) {
+		if starred := getStarred(context); starred != nil {
+			response := subsonic.NewResponse()
+			response.Starred = starred
+			subsonic.Write(context, response)
+		}
+	})
+
+	handle("getStarred2", subsonicReadScopes, func(context *gin.Context) {
+		if starred := getStarred(context); starred != nil {
+			response := subsonic.NewResponse()
+			response.Starred2 = starred
+			subsonic.Write(context, response)
+		}
+	})
+
+	return nil
+}
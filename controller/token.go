@@ -0,0 +1,121 @@
+package controller
+
+import (
+	"github.com/allape/gocrud"
+	"github.com/allape/homesong/auth"
+	"github.com/allape/homesong/model"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+type ApiTokenForm struct {
+	Name      string             `json:"name"`
+	Scopes    []model.TokenScope `json:"scopes"`
+	ExpiresIn int64              `json:"expiresIn"` // in hours, 0 for never
+}
+
+type ApiTokenResult struct {
+	Token    string         `json:"token"` // only shown once
+	ApiToken model.ApiToken `json:"apiToken"`
+}
+
+// whereOwnApiTokens lets admins see every token, and others only their own ones
+func whereOwnApiTokens(db *gorm.DB, user *model.User) *gorm.DB {
+	if user.IsAdmin() {
+		return db
+	}
+	return db.Where("user_id = ?", user.ID)
+}
+
+func SetupApiTokenController(group *gin.RouterGroup, db *gorm.DB) error {
+	err := gocrud.New(group, db, gocrud.Crud[model.ApiToken]{
+		DisableSave:     true,
+		DefaultPageSize: DefaultPageSize,
+		SearchHandlers: map[string]gocrud.SearchHandler{
+			"in_userId":          gocrud.KeywordIDIn("user_id", gocrud.OverflowedArrayTrimmerFilter[gocrud.ID](DefaultPageSize)),
+			"like_name":          gocrud.KeywordLike("name", nil),
+			"orderBy_createdAt":  gocrud.SortBy("created_at"),
+			"orderBy_lastUsedAt": gocrud.SortBy("last_used_at"),
+		},
+		WillPage: func(pageNum *int64, pageSize *int64, context *gin.Context, db *gorm.DB) *gorm.DB {
+			return whereOwnApiTokens(db, auth.CurrentUser(context))
+		},
+		WillCount: func(context *gin.Context, db *gorm.DB) *gorm.DB {
+			return whereOwnApiTokens(db, auth.CurrentUser(context))
+		},
+		DidGetOne: func(record *model.ApiToken, context *gin.Context, db *gorm.DB) {
+			if user := auth.CurrentUser(context); record.UserID != user.ID && !user.IsAdmin() {
+				gocrud.MakeErrorResponse(context, gocrud.RestCoder.NotFound(), "token not found")
+				return
+			}
+		},
+		WillDelete: func(context *gin.Context, db *gorm.DB) {
+			var exist model.ApiToken
+			if err := db.Model(&exist).First(&exist, context.Param("id")).Error; err != nil {
+				gocrud.MakeErrorResponse(context, gocrud.RestCoder.NotFound(), err)
+				return
+			} else if user := auth.CurrentUser(context); exist.UserID != user.ID && !user.IsAdmin() {
+				gocrud.MakeErrorResponse(context, gocrud.RestCoder.FromStatus(http.StatusForbidden), auth.ErrorForbidden)
+				return
+			}
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	group.PUT("", func(context *gin.Context) {
+		var form ApiTokenForm
+		if err := context.ShouldBindJSON(&form); err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), err)
+			return
+		}
+
+		user := auth.CurrentUser(context)
+		if user.ID == 0 {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), "auth is disabled")
+			return
+		}
+
+		form.Name = strings.TrimSpace(form.Name)
+		if form.Name == "" {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), "name is required")
+			return
+		} else if len(form.Scopes) == 0 {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), "scopes are required")
+			return
+		} else if form.ExpiresIn < 0 {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), "expiresIn cannot be negative")
+			return
+		}
+
+		for _, scope := range form.Scopes {
+			if !slices.Contains(model.TokenScopes, scope) {
+				gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), "scope not found: "+string(scope))
+				return
+			}
+		}
+
+		var expiresAt *time.Time
+		if form.ExpiresIn > 0 {
+			expiresAt = gocrud.Pointer(time.Now().Add(time.Duration(form.ExpiresIn) * time.Hour))
+		}
+
+		token, apiToken, err := auth.NewApiToken(db, user, form.Name, slices.Compact(slices.Sorted(slices.Values(form.Scopes))), expiresAt)
+		if err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
+			return
+		}
+
+		context.JSON(http.StatusOK, gocrud.R[ApiTokenResult]{Code: gocrud.RestCoder.OK(), Data: ApiTokenResult{
+			Token:    token,
+			ApiToken: *apiToken,
+		}})
+	})
+
+	return nil
+}
@@ -0,0 +1,311 @@
+package controller
+
+import (
+	"errors"
+	"github.com/allape/gocrud"
+	"github.com/allape/homesong/auth"
+	"github.com/allape/homesong/env"
+	"github.com/allape/homesong/model"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+type LoginForm struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+type LoginResult struct {
+	Token     string     `json:"token"`
+	ExpiresAt time.Time  `json:"expiresAt"`
+	User      model.User `json:"user"`
+}
+
+type AuthProviders struct {
+	OIDC bool `json:"oidc"`
+}
+
+type PasswordForm struct {
+	OldPassword string `json:"oldPassword"`
+	Password    string `json:"password"`
+}
+
+type UserForm struct {
+	model.User
+	Password string `json:"password"` // leave it empty to keep the current one
+}
+
+func setSessionCookie(context *gin.Context, token string) {
+	context.SetSameSite(http.SameSiteLaxMode)
+	context.SetCookie(auth.CookieName, token, env.SessionTTL*3600, "/", "", false, true)
+}
+
+func SetupAuthController(group *gin.RouterGroup, db *gorm.DB) error {
+	group.POST("/login", func(context *gin.Context) {
+		var form LoginForm
+		if err := context.ShouldBindJSON(&form); err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), err)
+			return
+		}
+
+		user, err := auth.Login(db, strings.TrimSpace(form.Username), form.Password)
+		if err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.FromStatus(http.StatusUnauthorized), err)
+			return
+		}
+
+		token, session, err := auth.NewSession(db, user)
+		if err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
+			return
+		}
+
+		setSessionCookie(context, token)
+
+		context.JSON(http.StatusOK, gocrud.R[LoginResult]{Code: gocrud.RestCoder.OK(), Data: LoginResult{
+			Token:     token,
+			ExpiresAt: session.ExpiresAt,
+			User:      *user,
+		}})
+	})
+
+	group.GET("/providers", func(context *gin.Context) {
+		context.JSON(http.StatusOK, gocrud.R[AuthProviders]{Code: gocrud.RestCoder.OK(), Data: AuthProviders{
+			OIDC: auth.OIDCEnabled(),
+		}})
+	})
+
+	group.GET("/oidc/login", func(context *gin.Context) {
+		authURL, state, nonce, err := auth.OIDCAuthCodeURL(context.Request.Context())
+		if err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
+			return
+		}
+
+		context.SetSameSite(http.SameSiteLaxMode)
+		context.SetCookie(auth.OIDCStateCookieName, state+"."+nonce, 600, "/api/auth/oidc", "", false, true)
+
+		context.Redirect(http.StatusFound, authURL)
+	})
+
+	group.GET("/oidc/callback", func(context *gin.Context) {
+		if errorCode := context.Query("error"); errorCode != "" {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.FromStatus(http.StatusUnauthorized), errorCode+": "+context.Query("error_description"))
+			return
+		}
+
+		stateCookie, _ := context.Cookie(auth.OIDCStateCookieName)
+		context.SetCookie(auth.OIDCStateCookieName, "", -1, "/api/auth/oidc", "", false, true)
+
+		state, nonce, ok := strings.Cut(stateCookie, ".")
+		if !ok || state == "" || state != context.Query("state") {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), auth.ErrorOIDCStateInvalid)
+			return
+		}
+
+		claims, err := auth.OIDCExchange(context.Request.Context(), context.Query("code"), nonce)
+		if err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.FromStatus(http.StatusUnauthorized), err)
+			return
+		}
+
+		user, err := auth.OIDCUser(db, claims)
+		if err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.FromStatus(http.StatusUnauthorized), err)
+			return
+		}
+
+		token, _, err := auth.NewSession(db, user)
+		if err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
+			return
+		}
+
+		setSessionCookie(context, token)
+
+		context.Redirect(http.StatusFound, "/ui/")
+	})
+
+	group.POST("/logout", func(context *gin.Context) {
+		if token := auth.GetToken(context); token != "" {
+			if err := auth.DeleteSession(db, token); err != nil {
+				gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
+				return
+			}
+		}
+
+		context.SetCookie(auth.CookieName, "", -1, "/", "", false, true)
+
+		context.JSON(http.StatusOK, gocrud.R[bool]{Code: gocrud.RestCoder.OK(), Data: true})
+	})
+
+	group.GET("/me", auth.Authenticate(db), func(context *gin.Context) {
+		context.JSON(http.StatusOK, gocrud.R[model.User]{Code: gocrud.RestCoder.OK(), Data: *auth.CurrentUser(context)})
+	})
+
+	group.PUT("/password", auth.Authenticate(db), func(context *gin.Context) {
+		var form PasswordForm
+		if err := context.ShouldBindJSON(&form); err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), err)
+			return
+		}
+
+		user := auth.CurrentUser(context)
+		if user.ID == 0 {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), "auth is disabled")
+			return
+		} else if form.Password == "" {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), "password cannot be empty")
+			return
+		}
+
+		if _, err := auth.Login(db, user.Username, form.OldPassword); err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), err)
+			return
+		}
+
+		hash, err := auth.HashPassword(form.Password)
+		if err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
+			return
+		}
+
+		if err := db.Model(user).UpdateColumn("password", hash).Error; err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
+			return
+		}
+
+		// the old password may have leaked, so sessions signed in with it go too
+		if err := auth.DeleteOtherSessions(db, user, auth.GetToken(context)); err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
+			return
+		}
+
+		auth.ForgetSubsonicPasswords()
+
+		context.JSON(http.StatusOK, gocrud.R[bool]{Code: gocrud.RestCoder.OK(), Data: true})
+	})
+
+	return nil
+}
+
+func SetupUserController(group *gin.RouterGroup, db *gorm.DB) error {
+	err := gocrud.New(group, db, gocrud.Crud[model.User]{
+		DisableSave:     true,
+		DefaultPageSize: DefaultPageSize,
+		SearchHandlers: map[string]gocrud.SearchHandler{
+			"like_username":     gocrud.KeywordLike("username", nil),
+			"in_id":             gocrud.KeywordIDIn("id", gocrud.OverflowedArrayTrimmerFilter[gocrud.ID](DefaultPageSize)),
+			"in_role":           gocrud.KeywordIn("role", nil),
+			"deleted":           gocrud.NewSoftDeleteSearchHandler("users"),
+			"orderBy_createdAt": gocrud.SortBy("created_at"),
+			"orderBy_updatedAt": gocrud.SortBy("updated_at"),
+		},
+		WillDelete: func(context *gin.Context, db *gorm.DB) {
+			id := gocrud.Pick(gocrud.IDsFromCommaSeparatedString(context.Param("id")), 0, 0)
+			if id == auth.CurrentUser(context).ID {
+				gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), "cannot delete yourself")
+				return
+			}
+		},
+		OnDelete: gocrud.NewSoftDeleteHandler[model.User](gocrud.RestCoder),
+		DidDelete: func(context *gin.Context, db *gorm.DB) {
+			if err := db.Where("user_id = ?", context.Param("id")).Delete(&model.Session{}).Error; err != nil {
+				l.Warn().Printf("failed to delete sessions of user %s: %v", context.Param("id"), err)
+			}
+			if err := db.Where("user_id = ?", context.Param("id")).Delete(&model.ApiToken{}).Error; err != nil {
+				l.Warn().Printf("failed to delete api tokens of user %s: %v", context.Param("id"), err)
+			}
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	group.PUT("", func(context *gin.Context) {
+		var form UserForm
+		if err := context.ShouldBindJSON(&form); err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), err)
+			return
+		}
+
+		user := form.User
+		user.Username = strings.TrimSpace(user.Username)
+		user.Role = model.UserRole(strings.TrimSpace(string(user.Role)))
+
+		if user.Username == "" {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), "username is required")
+			return
+		} else if !slices.Contains(model.UserRoles, user.Role) {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), "role not found")
+			return
+		} else if user.ID != 0 && user.ID == auth.CurrentUser(context).ID && !user.IsAdmin() {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), "cannot demote yourself")
+			return
+		}
+
+		var exist model.User
+		if err := db.Model(&exist).Where("username = ?", user.Username).First(&exist).Error; err == nil && exist.ID != user.ID {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), "username already exists")
+			return
+		} else if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
+			return
+		}
+
+		user.ExternalID = strings.TrimSpace(user.ExternalID)
+		if user.ExternalID != "" {
+			var bound model.User
+			if err := db.Model(&bound).Where("external_id = ?", user.ExternalID).First(&bound).Error; err == nil && bound.ID != user.ID {
+				gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), "externalId already exists")
+				return
+			} else if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+				gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
+				return
+			}
+		}
+
+		if user.ID != 0 {
+			var current model.User
+			if err := db.Model(&current).First(&current, user.ID).Error; err != nil {
+				gocrud.MakeErrorResponse(context, gocrud.RestCoder.NotFound(), err)
+				return
+			}
+			user.Password = current.Password
+			user.CreatedAt = current.CreatedAt
+			user.DeletedAt = current.DeletedAt
+		}
+
+		if form.Password != "" {
+			hash, err := auth.HashPassword(form.Password)
+			if err != nil {
+				gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
+				return
+			}
+			user.Password = hash
+		} else if user.ID == 0 {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), "password is required")
+			return
+		}
+
+		if err := db.Save(&user).Error; err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
+			return
+		}
+
+		if form.Password != "" {
+			auth.ForgetSubsonicPasswords()
+			if err := auth.DeleteOtherSessions(db, &user, auth.GetToken(context)); err != nil {
+				l.Warn().Printf("failed to delete sessions of user %d: %v", user.ID, err)
+			}
+		}
+
+		context.JSON(http.StatusOK, gocrud.R[model.User]{Code: gocrud.RestCoder.OK(), Data: user})
+	})
+
+	return nil
+}
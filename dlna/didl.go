@@ -0,0 +1,129 @@
+package dlna
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	ClassStorageFolder = "object.container.storageFolder"
+	ClassMusicArtist   = "object.container.person.musicArtist"
+	ClassMusicAlbum    = "object.container.album.musicAlbum"
+	ClassPlaylist      = "object.container.playlistContainer"
+	ClassMusicTrack    = "object.item.audioItem.musicTrack"
+)
+
+// dlnaFlags tells renderers that the stream supports byte seeking and background transfer
+const dlnaFlags = "DLNA.ORG_FLAGS=01700000000000000000000000000000"
+
+type Res struct {
+	ProtocolInfo    string `xml:"protocolInfo,attr"`
+	Duration        string `xml:"duration,attr,omitempty"`
+	Size            int64  `xml:"size,attr,omitempty"`
+	Bitrate         int64  `xml:"bitrate,attr,omitempty"` // in bytes per second
+	SampleFrequency int64  `xml:"sampleFrequency,attr,omitempty"`
+	NrAudioChannels int64  `xml:"nrAudioChannels,attr,omitempty"`
+	URL             string `xml:",chardata"`
+}
+
+type Object struct {
+	ID          string `xml:"id,attr"`
+	ParentID    string `xml:"parentID,attr"`
+	Restricted  int    `xml:"restricted,attr"`
+	Title       string `xml:"dc:title"`
+	Creator     string `xml:"dc:creator,omitempty"`
+	Class       string `xml:"upnp:class"`
+	Artist      string `xml:"upnp:artist,omitempty"`
+	Album       string `xml:"upnp:album,omitempty"`
+	TrackNumber int    `xml:"upnp:originalTrackNumber,omitempty"`
+	AlbumArtURI string `xml:"upnp:albumArtURI,omitempty"`
+	Res         []Res  `xml:"res,omitempty"`
+}
+
+type Container struct {
+	Object
+	ChildCount int `xml:"childCount,attr"`
+	Searchable int `xml:"searchable,attr"`
+}
+
+type Item struct {
+	Object
+}
+
+type DIDLLite struct {
+	XMLName    xml.Name    `xml:"DIDL-Lite"`
+	Xmlns      string      `xml:"xmlns,attr"`
+	XmlnsDC    string      `xml:"xmlns:dc,attr"`
+	XmlnsUPnP  string      `xml:"xmlns:upnp,attr"`
+	XmlnsDLNA  string      `xml:"xmlns:dlna,attr"`
+	Containers []Container `xml:"container"`
+	Items      []Item      `xml:"item"`
+}
+
+func NewDIDLLite() *DIDLLite {
+	return &DIDLLite{
+		Xmlns:     "urn:schemas-upnp-org:metadata-1-0/DIDL-Lite/",
+		XmlnsDC:   "http://purl.org/dc/elements/1.1/",
+		XmlnsUPnP: "urn:schemas-upnp-org:metadata-1-0/upnp/",
+		XmlnsDLNA: "urn:schemas-dlna-org:metadata-1-0/",
+	}
+}
+
+func (d *DIDLLite) Len() int {
+	return len(d.Containers) + len(d.Items)
+}
+
+func (d *DIDLLite) String() (string, error) {
+	body, err := xml.Marshal(d)
+	if err != nil {
+		return "", err
+	}
+	return string(body), nil
+}
+
+// FormatDuration formats seconds as H:MM:SS.mmm for res@duration
+func FormatDuration(seconds float64) string {
+	duration := time.Duration(seconds * float64(time.Second)).Round(time.Millisecond)
+	return fmt.Sprintf(
+		"%d:%02d:%02d.%03d",
+		int(duration.Hours()),
+		int(duration.Minutes())%60,
+		int(duration.Seconds())%60,
+		duration.Milliseconds()%1000,
+	)
+}
+
+// FileProtocolInfo describes an original file, which can be seeked by bytes
+func FileProtocolInfo(mime string) string {
+	return "http-get:*:" + mime + ":DLNA.ORG_OP=01;" + dlnaFlags
+}
+
+// TranscodeProtocolInfo describes a transcoded stream, whose size is unknown so it can not be seeked
+func TranscodeProtocolInfo(mime string) string {
+	info := "http-get:*:" + mime + ":"
+	if mime == "audio/mpeg" {
+		info += "DLNA.ORG_PN=MP3;"
+	}
+	return info + "DLNA.ORG_OP=00;DLNA.ORG_CI=1;" + dlnaFlags
+}
+
+// ContentFeatures returns the fourth field of protocolInfo, sent as the contentFeatures.dlna.org header
+func ContentFeatures(protocolInfo string) string {
+	fields := strings.SplitN(protocolInfo, ":", 4)
+	return fields[len(fields)-1]
+}
+
+// ParseIndex parses StartingIndex and RequestedCount of Browse
+func ParseIndex(value string) (int, bool) {
+	if value == "" {
+		return 0, true
+	}
+	index, err := strconv.ParseUint(value, 10, 31)
+	if err != nil {
+		return 0, false
+	}
+	return int(index), true
+}
@@ -0,0 +1,96 @@
+package dlna
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseAction(t *testing.T) {
+	body := `<?xml version="1.0"?>
+<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">
+  <s:Body>
+    <u:Browse xmlns:u="urn:schemas-upnp-org:service:ContentDirectory:1">
+      <ObjectID>al-1</ObjectID>
+      <BrowseFlag>BrowseDirectChildren</BrowseFlag>
+      <Filter>*</Filter>
+      <StartingIndex>0</StartingIndex>
+      <RequestedCount>50</RequestedCount>
+      <SortCriteria></SortCriteria>
+    </u:Browse>
+  </s:Body>
+</s:Envelope>`
+
+	action, args, err := ParseAction(strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if action != "Browse" {
+		t.Fatal("unexpected action", action)
+	}
+	if args["ObjectID"] != "al-1" || args["RequestedCount"] != "50" || args["SortCriteria"] != "" {
+		t.Fatal("unexpected args", args)
+	}
+
+	if _, _, err := ParseAction(strings.NewReader(`<s:Envelope><s:Body></s:Body></s:Envelope>`)); err != ErrorNoAction {
+		t.Fatal("empty body should fail", err)
+	}
+}
+
+func TestFormatDuration(t *testing.T) {
+	if duration := FormatDuration(231.4); duration != "0:03:51.400" {
+		t.Fatal("unexpected duration", duration)
+	}
+	if duration := FormatDuration(3723); duration != "1:02:03.000" {
+		t.Fatal("unexpected duration", duration)
+	}
+}
+
+func TestSearchTargets(t *testing.T) {
+	server := &SSDPServer{UUID: "1234"}
+
+	if targets := server.SearchTargets("ssdp:all"); len(targets) != len(server.Targets()) {
+		t.Fatal("ssdp:all should match every target", targets)
+	}
+	if targets := server.SearchTargets(ContentDirectoryType); len(targets) != 1 || targets[0] != ContentDirectoryType {
+		t.Fatal("unexpected targets", targets)
+	}
+	if targets := server.SearchTargets("urn:schemas-upnp-org:device:MediaRenderer:1"); len(targets) != 0 {
+		t.Fatal("renderers should not match", targets)
+	}
+
+	if usn := server.USN("uuid:1234"); usn != "uuid:1234" {
+		t.Fatal("unexpected usn", usn)
+	}
+	if usn := server.USN(DeviceType); usn != "uuid:1234::"+DeviceType {
+		t.Fatal("unexpected usn", usn)
+	}
+}
+
+func TestDIDLLite(t *testing.T) {
+	didl := NewDIDLLite()
+	didl.Items = append(didl.Items, Item{Object: Object{
+		ID:         "so-1",
+		ParentID:   "al-2",
+		Restricted: 1,
+		Title:      "Rock & Roll",
+		Class:      ClassMusicTrack,
+		Res:        []Res{{ProtocolInfo: FileProtocolInfo("audio/mpeg"), URL: "http://127.0.0.1/dlna/media/1.mp3"}},
+	}})
+
+	result, err := didl.String()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{
+		`<item id="so-1" parentID="al-2" restricted="1">`,
+		`<dc:title>Rock &amp; Roll</dc:title>`,
+		`<upnp:class>object.item.audioItem.musicTrack</upnp:class>`,
+		`<res protocolInfo="http-get:*:audio/mpeg:DLNA.ORG_OP=01;`,
+		`>http://127.0.0.1/dlna/media/1.mp3</res>`,
+	} {
+		if !strings.Contains(result, expected) {
+			t.Fatal("missing", expected, "in", result)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package dlna
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	ErrorInvalidAction = 401
+	ErrorInvalidArgs   = 402
+	ErrorActionFailed  = 501
+	ErrorNoSuchObject  = 701
+)
+
+var ErrorNoAction = errors.New("no action in soap body")
+
+// Arg is an argument of an action response, they are kept in order as some renderers expect
+type Arg struct {
+	Name  string
+	Value string
+}
+
+// ParseAction reads the action name and arguments from the body of a SOAP envelope
+func ParseAction(body io.Reader) (string, map[string]string, error) {
+	decoder := xml.NewDecoder(body)
+
+	var (
+		action string
+		args   = map[string]string{}
+		depth  int
+		name   string
+		value  strings.Builder
+	)
+
+	// Envelope > Body > Action > Argument
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return "", nil, err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			depth++
+			switch depth {
+			case 3:
+				action = t.Name.Local
+			case 4:
+				name = t.Name.Local
+				value.Reset()
+			}
+		case xml.CharData:
+			if depth == 4 {
+				value.Write(t)
+			}
+		case xml.EndElement:
+			if depth == 4 {
+				args[name] = value.String()
+			}
+			depth--
+		}
+	}
+
+	if action == "" {
+		return "", nil, ErrorNoAction
+	}
+
+	return action, args, nil
+}
+
+// ActionResponse wraps the out arguments of action into a SOAP envelope
+func ActionResponse(serviceType, action string, args ...Arg) []byte {
+	var body bytes.Buffer
+	body.WriteString(xml.Header)
+	body.WriteString(`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/"><s:Body>`)
+	_, _ = fmt.Fprintf(&body, `<u:%sResponse xmlns:u="%s">`, action, serviceType)
+	for _, arg := range args {
+		_, _ = fmt.Fprintf(&body, "<%s>", arg.Name)
+		_ = xml.EscapeText(&body, []byte(arg.Value))
+		_, _ = fmt.Fprintf(&body, "</%s>", arg.Name)
+	}
+	_, _ = fmt.Fprintf(&body, `</u:%sResponse>`, action)
+	body.WriteString(`</s:Body></s:Envelope>`)
+	return body.Bytes()
+}
+
+// FaultResponse is sent with 500 Internal Server Error as UPnP requires
+func FaultResponse(code int, description string) []byte {
+	var body bytes.Buffer
+	body.WriteString(xml.Header)
+	body.WriteString(`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/"><s:Body>`)
+	body.WriteString(`<s:Fault><faultcode>s:Client</faultcode><faultstring>UPnPError</faultstring><detail>`)
+	_, _ = fmt.Fprintf(&body, `<UPnPError xmlns="urn:schemas-upnp-org:control-1-0"><errorCode>%d</errorCode><errorDescription>`, code)
+	_ = xml.EscapeText(&body, []byte(description))
+	body.WriteString(`</errorDescription></UPnPError></detail></s:Fault></s:Body></s:Envelope>`)
+	return body.Bytes()
+}
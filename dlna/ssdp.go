@@ -0,0 +1,205 @@
+package dlna
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	SSDPAddr   = "239.255.255.250:1900"
+	SSDPMaxAge = 1800 // in seconds
+
+	// notifyInterval is well within SSDPMaxAge, since some renderers drop servers which miss one announcement
+	notifyInterval = SSDPMaxAge / 3 * time.Second
+)
+
+// SSDPServer announces a media server, and answers searches for it
+type SSDPServer struct {
+	UUID string
+	// Location returns the url of the device description which is reachable from ip
+	Location func(ip net.IP) string
+}
+
+// Targets are the notification types of the device and its services
+func (s *SSDPServer) Targets() []string {
+	return []string{
+		"upnp:rootdevice",
+		"uuid:" + s.UUID,
+		DeviceType,
+		ContentDirectoryType,
+		ConnectionManagerType,
+	}
+}
+
+// USN makes the unique service name of target
+func (s *SSDPServer) USN(target string) string {
+	if target == "uuid:"+s.UUID {
+		return target
+	}
+	return "uuid:" + s.UUID + "::" + target
+}
+
+// SearchTargets returns the targets matching ST of an M-SEARCH request
+func (s *SSDPServer) SearchTargets(st string) []string {
+	if st == "ssdp:all" {
+		return s.Targets()
+	}
+	for _, target := range s.Targets() {
+		if strings.EqualFold(target, st) {
+			return []string{target}
+		}
+	}
+	return nil
+}
+
+func (s *SSDPServer) SearchResponse(target string, location string) []byte {
+	return []byte(fmt.Sprintf(
+		"HTTP/1.1 200 OK\r\nCACHE-CONTROL: max-age=%d\r\nDATE: %s\r\nEXT:\r\nLOCATION: %s\r\nSERVER: %s\r\nST: %s\r\nUSN: %s\r\nContent-Length: 0\r\n\r\n",
+		SSDPMaxAge, time.Now().UTC().Format(http.TimeFormat), location, ServerName, target, s.USN(target),
+	))
+}
+
+func (s *SSDPServer) NotifyMessage(target string, location string, alive bool) []byte {
+	if !alive {
+		return []byte(fmt.Sprintf(
+			"NOTIFY * HTTP/1.1\r\nHOST: %s\r\nNT: %s\r\nNTS: ssdp:byebye\r\nUSN: %s\r\n\r\n",
+			SSDPAddr, target, s.USN(target),
+		))
+	}
+	return []byte(fmt.Sprintf(
+		"NOTIFY * HTTP/1.1\r\nHOST: %s\r\nCACHE-CONTROL: max-age=%d\r\nLOCATION: %s\r\nNT: %s\r\nNTS: ssdp:alive\r\nSERVER: %s\r\nUSN: %s\r\n\r\n",
+		SSDPAddr, SSDPMaxAge, location, target, ServerName, s.USN(target),
+	))
+}
+
+// Serve announces the server on every IPv4 interface, and answers M-SEARCH requests until the listener fails
+func (s *SSDPServer) Serve() error {
+	group, err := net.ResolveUDPAddr("udp4", SSDPAddr)
+	if err != nil {
+		return err
+	}
+
+	conn, err := net.ListenMulticastUDP("udp4", nil, group)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	go func() {
+		for {
+			s.notify(group, true)
+			time.Sleep(notifyInterval)
+		}
+	}()
+
+	buffer := make([]byte, 2048)
+	for {
+		n, remote, err := conn.ReadFromUDP(buffer)
+		if err != nil {
+			return err
+		}
+
+		request, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(buffer[:n])))
+		if err != nil || request.Method != "M-SEARCH" || request.Header.Get("MAN") != `"ssdp:discover"` {
+			continue
+		}
+
+		targets := s.SearchTargets(request.Header.Get("ST"))
+		if len(targets) == 0 {
+			continue
+		}
+
+		// responses are spread over MX seconds, which is capped to 5 by the spec
+		mx, _ := strconv.Atoi(request.Header.Get("MX"))
+		delay := time.Duration(rand.Int64N(int64(max(min(mx, 5), 1)) * int64(time.Second)))
+
+		go s.respond(remote, targets, delay)
+	}
+}
+
+func (s *SSDPServer) respond(remote *net.UDPAddr, targets []string, delay time.Duration) {
+	time.Sleep(delay)
+
+	// dialing picks the local address which routes to remote, which is what remote can reach
+	conn, err := net.DialUDP("udp4", nil, remote)
+	if err != nil {
+		l.Warn().Printf("Failed to respond to %s: %v", remote, err)
+		return
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	location := s.Location(conn.LocalAddr().(*net.UDPAddr).IP)
+	for _, target := range targets {
+		if _, err := conn.Write(s.SearchResponse(target, location)); err != nil {
+			l.Warn().Printf("Failed to respond to %s: %v", remote, err)
+			return
+		}
+	}
+}
+
+// notify sends NOTIFY from every IPv4 address, so that each network sees a location it can reach
+func (s *SSDPServer) notify(group *net.UDPAddr, alive bool) {
+	for _, ip := range InterfaceIPs() {
+		conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: ip})
+		if err != nil {
+			l.Warn().Printf("Failed to notify from %s: %v", ip, err)
+			continue
+		}
+
+		location := s.Location(ip)
+		for _, target := range s.Targets() {
+			if _, err := conn.WriteToUDP(s.NotifyMessage(target, location, alive), group); err != nil {
+				l.Warn().Printf("Failed to notify from %s: %v", ip, err)
+				break
+			}
+		}
+
+		_ = conn.Close()
+	}
+}
+
+// Shutdown says byebye on every IPv4 interface
+func (s *SSDPServer) Shutdown() {
+	group, err := net.ResolveUDPAddr("udp4", SSDPAddr)
+	if err != nil {
+		return
+	}
+	s.notify(group, false)
+}
+
+// InterfaceIPs returns IPv4 addresses of interfaces which are up and support multicast
+func InterfaceIPs() []net.IP {
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		l.Warn().Printf("Failed to list network interfaces: %v", err)
+		return nil
+	}
+
+	var ips []net.IP
+	for _, iface := range interfaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagMulticast == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.To4() != nil {
+				ips = append(ips, ipNet.IP.To4())
+			}
+		}
+	}
+	return ips
+}
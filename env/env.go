@@ -13,6 +13,41 @@ const (
 
 	uiFolder     = "HOME_SONG_UI_FOLDER"
 	staticFolder = "HOME_SONG_STATIC_FOLDER"
+	cacheFolder  = "HOME_SONG_CACHE_FOLDER"
+
+	inboxFolder      = "HOME_SONG_INBOX_FOLDER"
+	inboxInterval    = "HOME_SONG_INBOX_INTERVAL"
+	inboxImportTags  = "HOME_SONG_INBOX_IMPORT_TAGS"
+	quarantineFolder = "HOME_SONG_QUARANTINE_FOLDER"
+
+	extraArtistSeparators = "HOME_SONG_EXTRA_ARTIST_SEPARATORS"
+
+	enableAuth    = "HOME_SONG_ENABLE_AUTH"
+	adminUsername = "HOME_SONG_ADMIN_USERNAME"
+	adminPassword = "HOME_SONG_ADMIN_PASSWORD"
+	sessionTTL    = "HOME_SONG_SESSION_TTL"
+
+	adminGroup      = "HOME_SONG_ADMIN_GROUP"
+	autoCreateUsers = "HOME_SONG_AUTO_CREATE_USERS"
+
+	remoteUserHeader   = "HOME_SONG_REMOTE_USER_HEADER"
+	remoteGroupsHeader = "HOME_SONG_REMOTE_GROUPS_HEADER"
+	trustedProxies     = "HOME_SONG_TRUSTED_PROXIES"
+
+	oidcIssuer        = "HOME_SONG_OIDC_ISSUER"
+	oidcClientID      = "HOME_SONG_OIDC_CLIENT_ID"
+	oidcClientSecret  = "HOME_SONG_OIDC_CLIENT_SECRET"
+	oidcRedirectURL   = "HOME_SONG_OIDC_REDIRECT_URL"
+	oidcScopes        = "HOME_SONG_OIDC_SCOPES"
+	oidcUsernameClaim = "HOME_SONG_OIDC_USERNAME_CLAIM"
+	oidcGroupsClaim   = "HOME_SONG_OIDC_GROUPS_CLAIM"
+
+	dlnaEnabled      = "HOME_SONG_DLNA_ENABLED"
+	dlnaFriendlyName = "HOME_SONG_DLNA_FRIENDLY_NAME"
+	dlnaUUID         = "HOME_SONG_DLNA_UUID"
+	dlnaBaseURL      = "HOME_SONG_DLNA_BASE_URL"
+	dlnaUsername     = "HOME_SONG_DLNA_USERNAME"
+	dlnaBindAddr     = "HOME_SONG_DLNA_BIND_ADDR"
 )
 
 var (
@@ -24,6 +59,41 @@ var (
 
 	UIFolder     = goenv.Getenv(uiFolder, "./ui/dist/index.html")
 	StaticFolder = goenv.Getenv(staticFolder, "./static")
+	CacheFolder  = goenv.Getenv(cacheFolder, "./cache")
+
+	InboxFolder      = goenv.Getenv(inboxFolder, "")       // leave it empty to disable watching
+	InboxInterval    = goenv.Getenv(inboxInterval, 10)     // in seconds
+	InboxImportTags  = goenv.Getenv(inboxImportTags, true) // same as ?importTags=true of upload
+	QuarantineFolder = goenv.Getenv(quarantineFolder, "./quarantine")
+
+	ExtraArtistSeparators = goenv.Getenv(extraArtistSeparators, "") // each character splits artist tags too, e.g. "/／", which would split "AC/DC"
+
+	EnableAuth    = goenv.Getenv(enableAuth, false)
+	AdminUsername = goenv.Getenv(adminUsername, "admin") // created on startup if there is no user yet
+	AdminPassword = goenv.Getenv(adminPassword, "")      // leave it empty to generate one, which will be printed in log
+	SessionTTL    = goenv.Getenv(sessionTTL, 720)        // in hours
+
+	AdminGroup      = goenv.Getenv(adminGroup, "")        // members of this group from OIDC or proxy become admins, leave it empty to keep roles as they are
+	AutoCreateUsers = goenv.Getenv(autoCreateUsers, true) // create listeners for unknown users from OIDC or proxy
+
+	RemoteUserHeader   = goenv.Getenv(remoteUserHeader, "") // e.g. "Remote-User", leave it empty to disable proxy login
+	RemoteGroupsHeader = goenv.Getenv(remoteGroupsHeader, "Remote-Groups")
+	TrustedProxies     = goenv.Getenv(trustedProxies, "127.0.0.1/32,::1/128") // comma separated CIDRs
+
+	OIDCIssuer        = goenv.Getenv(oidcIssuer, "") // e.g. "https://auth.example.com", leave it empty to disable OIDC login
+	OIDCClientID      = goenv.Getenv(oidcClientID, "")
+	OIDCClientSecret  = goenv.Getenv(oidcClientSecret, "")
+	OIDCRedirectURL   = goenv.Getenv(oidcRedirectURL, "")                // e.g. "https://song.example.com/api/auth/oidc/callback"
+	OIDCScopes        = goenv.Getenv(oidcScopes, "openid profile email") // space separated, add "groups" if HOME_SONG_ADMIN_GROUP is used and the provider needs it
+	OIDCUsernameClaim = goenv.Getenv(oidcUsernameClaim, "preferred_username")
+	OIDCGroupsClaim   = goenv.Getenv(oidcGroupsClaim, "groups")
+
+	DLNAEnabled      = goenv.Getenv(dlnaEnabled, false) // advertise on the LAN as a UPnP media server
+	DLNAFriendlyName = goenv.Getenv(dlnaFriendlyName, "HomeSong")
+	DLNAUUID         = goenv.Getenv(dlnaUUID, "")          // generated from the host name and friendly name if empty
+	DLNABaseURL      = goenv.Getenv(dlnaBaseURL, "")       // e.g. "http://192.168.1.2:8200", detected from network interfaces if empty
+	DLNAUsername     = goenv.Getenv(dlnaUsername, "")      // browse as this user when auth is enabled, only shared and public collections are listed if empty
+	DLNABindAddr     = goenv.Getenv(dlnaBindAddr, ":8200") // DLNA has its own listener, which should only be reachable from the LAN
 
 	Standalone = DatabaseDSN == ""
 )
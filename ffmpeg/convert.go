@@ -3,19 +3,28 @@ package ffmpeg
 import (
 	"bytes"
 	"io"
+	"os"
 	"os/exec"
+	"path"
 )
 
 func ConvertToMp3(filename string, writer io.Writer) error {
-	cmd := exec.Command(
-		"ffmpeg",
+	return Transcode(filename, Profiles[DefaultProfile], false, writer)
+}
+
+// Transcode encodes the audio of filename with profile into writer,
+// copyAudio skips re-encoding when the source codec already matches the profile
+func Transcode(filename string, profile Profile, copyAudio bool, writer io.Writer) error {
+	args := []string{
 		"-hide_banner",
 		"-loglevel", "error",
 		"-i", filename,
-		"-c:a", "mp3",
-		"-f", "mp3",
-		"-",
-	)
+		"-vn",
+	}
+	args = append(args, profile.Args(copyAudio)...)
+	args = append(args, "-")
+
+	cmd := exec.Command("ffmpeg", args...)
 
 	stderr := bytes.NewBuffer(nil)
 
@@ -31,3 +40,46 @@ func ConvertToMp3(filename string, writer io.Writer) error {
 
 	return nil
 }
+
+// TranscodeWithCache works like Transcode, and also keeps the output in cacheFile once ffmpeg exits successfully.
+// A client that goes away does not abort the transcoding, so the cache is still completed for the next play.
+func TranscodeWithCache(filename, cacheFile string, profile Profile, copyAudio bool, writer io.Writer) error {
+	err := os.MkdirAll(path.Dir(cacheFile), 0755)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(path.Dir(cacheFile), "transcode-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+	}()
+
+	err = Transcode(filename, profile, copyAudio, &teeWriter{file: tmp, client: writer})
+	if err != nil {
+		return err
+	}
+
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), cacheFile)
+}
+
+type teeWriter struct {
+	file      io.Writer
+	client    io.Writer
+	clientErr error
+}
+
+func (w *teeWriter) Write(p []byte) (int, error) {
+	if w.clientErr == nil {
+		_, w.clientErr = w.client.Write(p)
+	}
+	return w.file.Write(p)
+}
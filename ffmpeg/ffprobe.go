@@ -7,6 +7,8 @@ import (
 	"io"
 	"os"
 	"os/exec"
+	"strconv"
+	"strings"
 )
 
 var l = gogger.New("ffmpeg")
@@ -21,31 +23,37 @@ const (
 type CodecName string
 
 type FFProbeStream struct {
-	Index         int       `json:"index"`
-	CodecName     CodecName `json:"codec_name"`
-	CodecLongName string    `json:"codec_long_name"`
-	Profile       string    `json:"profile"`
-	CodecType     CodecType `json:"codec_type"`
-	CodecTagStr   string    `json:"codec_tag_string"`
-	CodecTag      string    `json:"codec_tag"`
-	NbFrames      string    `json:"nb_frames"`
-	Width         int       `json:"width"`
-	Height        int       `json:"height"`
+	Index         int               `json:"index"`
+	CodecName     CodecName         `json:"codec_name"`
+	CodecLongName string            `json:"codec_long_name"`
+	Profile       string            `json:"profile"`
+	CodecType     CodecType         `json:"codec_type"`
+	CodecTagStr   string            `json:"codec_tag_string"`
+	CodecTag      string            `json:"codec_tag"`
+	NbFrames      string            `json:"nb_frames"`
+	Width         int               `json:"width"`
+	Height        int               `json:"height"`
+	SampleRate    string            `json:"sample_rate"`
+	Channels      int               `json:"channels"`
+	ChannelLayout string            `json:"channel_layout"`
+	Duration      string            `json:"duration"`
+	BitRate       string            `json:"bit_rate"`
+	Tags          map[string]string `json:"tags"`
 }
 
 type FFProbeFormat struct {
-	Filename       string `json:"filename"`
-	NbStreams      int    `json:"nb_streams"`
-	NbPrograms     int    `json:"nb_programs"`
-	NbStreamGroups int    `json:"nb_stream_groups"`
-	FormatName     string `json:"format_name"`
-	FormatLongName string `json:"format_long_name"`
-	StartTime      string `json:"start_time"`
-	Duration       string `json:"duration"`
-	Size           string `json:"size"`
-	BitRate        string `json:"bit_rate"`
-	ProbeScore     int    `json:"probe_score"`
-	Tags           any    `json:"tags"`
+	Filename       string            `json:"filename"`
+	NbStreams      int               `json:"nb_streams"`
+	NbPrograms     int               `json:"nb_programs"`
+	NbStreamGroups int               `json:"nb_stream_groups"`
+	FormatName     string            `json:"format_name"`
+	FormatLongName string            `json:"format_long_name"`
+	StartTime      string            `json:"start_time"`
+	Duration       string            `json:"duration"`
+	Size           string            `json:"size"`
+	BitRate        string            `json:"bit_rate"`
+	ProbeScore     int               `json:"probe_score"`
+	Tags           map[string]string `json:"tags"`
 }
 
 type FFProbeJson struct {
@@ -86,3 +94,75 @@ func FFProbeReader(reader io.Reader) (*FFProbeJson, string, error) {
 
 	return &ffprobe, string(output), nil
 }
+
+func ParseFFProbeJson(str string) (*FFProbeJson, error) {
+	var ffprobe FFProbeJson
+	err := json.Unmarshal([]byte(str), &ffprobe)
+	if err != nil {
+		return nil, err
+	}
+	return &ffprobe, nil
+}
+
+// AudioStream returns the first audio stream, or nil if there is none
+func (f *FFProbeJson) AudioStream() *FFProbeStream {
+	for i := range f.Streams {
+		if f.Streams[i].CodecType == Audio {
+			return &f.Streams[i]
+		}
+	}
+	return nil
+}
+
+type AudioInfo struct {
+	Duration   float64 // in seconds
+	BitRate    int64   // in bits per second
+	SampleRate int32
+	Channels   int32
+	Codec      CodecName
+	Container  string
+}
+
+// AudioInfo collects the technical details of the first audio stream,
+// stream level values are preferred, and format level values are used as fallback
+func (f *FFProbeJson) AudioInfo() AudioInfo {
+	info := AudioInfo{
+		Duration:  parseFloat(f.Format.Duration),
+		BitRate:   parseInt(f.Format.BitRate),
+		Container: strings.Split(f.Format.FormatName, ",")[0],
+	}
+
+	stream := f.AudioStream()
+	if stream == nil {
+		return info
+	}
+
+	info.Codec = stream.CodecName
+	info.SampleRate = int32(parseInt(stream.SampleRate))
+	info.Channels = int32(stream.Channels)
+
+	if duration := parseFloat(stream.Duration); info.Duration == 0 && duration > 0 {
+		info.Duration = duration
+	}
+	if bitRate := parseInt(stream.BitRate); bitRate > 0 {
+		info.BitRate = bitRate
+	}
+
+	return info
+}
+
+func parseFloat(str string) float64 {
+	value, err := strconv.ParseFloat(strings.TrimSpace(str), 64)
+	if err != nil {
+		return 0
+	}
+	return value
+}
+
+func parseInt(str string) int64 {
+	value, err := strconv.ParseInt(strings.TrimSpace(str), 10, 64)
+	if err != nil {
+		return 0
+	}
+	return value
+}
@@ -10,3 +10,25 @@ func TestFFProbe(t *testing.T) {
 
 	t.Log(ffprobe)
 }
+
+func TestAudioInfo(t *testing.T) {
+	ffprobe, err := ParseFFProbeJson(`{
+		"streams": [
+			{"index": 0, "codec_name": "flac", "codec_type": "audio", "sample_rate": "44100", "channels": 2, "duration": "215.400000"},
+			{"index": 1, "codec_name": "mjpeg", "codec_type": "video"}
+		],
+		"format": {"format_name": "flac", "duration": "215.400000", "bit_rate": "912345"}
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info := ffprobe.AudioInfo()
+	if info.Codec != "flac" || info.Container != "flac" {
+		t.Fatal("unexpected codec or container", info)
+	} else if info.SampleRate != 44100 || info.Channels != 2 {
+		t.Fatal("unexpected sample rate or channels", info)
+	} else if info.Duration != 215.4 || info.BitRate != 912345 {
+		t.Fatal("unexpected duration or bit rate", info)
+	}
+}
@@ -0,0 +1,204 @@
+package ffmpeg
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/bits"
+	"math/cmplx"
+	"os/exec"
+	"strconv"
+)
+
+// The fingerprint follows Haitsma and Kalker's approach, which is also the basis of chromaprint:
+// the first FingerprintSeconds of audio is downmixed and resampled to FingerprintSampleRate,
+// each frame is split into 33 logarithmic bands between 300Hz and 2000Hz,
+// and every bit of a 32-bit sub-fingerprint tells whether the energy difference of two adjacent bands
+// increased compared to the previous frame. This survives re-encoding, so MP3 and FLAC of a recording match.
+const (
+	FingerprintSampleRate = 5512
+	FingerprintSeconds    = 120
+	fingerprintFrameSize  = 2048
+	fingerprintFrameHop   = 256
+	fingerprintBands      = 33
+	fingerprintMinFreq    = 300.0
+	fingerprintMaxFreq    = 2000.0
+)
+
+// DefaultFingerprintThreshold is the bit error rate under which two fingerprints are the same recording
+const DefaultFingerprintThreshold = 0.35
+
+type AcousticFingerprint []uint32
+
+func (f AcousticFingerprint) String() string {
+	buf := make([]byte, len(f)*4)
+	for i, sub := range f {
+		binary.LittleEndian.PutUint32(buf[i*4:], sub)
+	}
+	return base64.StdEncoding.EncodeToString(buf)
+}
+
+func ParseAcousticFingerprint(str string) (AcousticFingerprint, error) {
+	buf, err := base64.StdEncoding.DecodeString(str)
+	if err != nil {
+		return nil, err
+	} else if len(buf)%4 != 0 {
+		return nil, errors.New("invalid fingerprint length")
+	}
+
+	fingerprint := make(AcousticFingerprint, len(buf)/4)
+	for i := range fingerprint {
+		fingerprint[i] = binary.LittleEndian.Uint32(buf[i*4:])
+	}
+	return fingerprint, nil
+}
+
+// Fingerprint decodes filename into mono PCM with ffmpeg and computes its AcousticFingerprint
+func Fingerprint(filename string) (AcousticFingerprint, error) {
+	cmd := exec.Command(
+		"ffmpeg",
+		"-hide_banner",
+		"-loglevel", "error",
+		"-i", filename,
+		"-vn",
+		"-t", strconv.Itoa(FingerprintSeconds),
+		"-ac", "1",
+		"-ar", strconv.Itoa(FingerprintSampleRate),
+		"-f", "s16le",
+		"-",
+	)
+
+	stdout := bytes.NewBuffer(nil)
+	stderr := bytes.NewBuffer(nil)
+
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	err := cmd.Run()
+	if err != nil {
+		return nil, fmt.Errorf("ffmpeg: %w: %s", err, stderr.String())
+	}
+
+	pcm := stdout.Bytes()
+	samples := make([]float64, len(pcm)/2)
+	for i := range samples {
+		samples[i] = float64(int16(binary.LittleEndian.Uint16(pcm[i*2:]))) / math.MaxInt16
+	}
+
+	return ComputeAcousticFingerprint(samples), nil
+}
+
+// ComputeAcousticFingerprint computes the fingerprint of mono samples at FingerprintSampleRate
+func ComputeAcousticFingerprint(samples []float64) AcousticFingerprint {
+	if len(samples) < fingerprintFrameSize {
+		return AcousticFingerprint{}
+	}
+
+	window := make([]float64, fingerprintFrameSize)
+	for i := range window {
+		window[i] = 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/float64(fingerprintFrameSize-1))
+	}
+
+	// bin boundaries of the logarithmic bands
+	edges := make([]int, fingerprintBands+1)
+	for i := range edges {
+		freq := fingerprintMinFreq * math.Pow(fingerprintMaxFreq/fingerprintMinFreq, float64(i)/fingerprintBands)
+		edges[i] = int(math.Round(freq * fingerprintFrameSize / FingerprintSampleRate))
+	}
+
+	frames := (len(samples)-fingerprintFrameSize)/fingerprintFrameHop + 1
+	fingerprint := make(AcousticFingerprint, 0, frames)
+
+	frame := make([]complex128, fingerprintFrameSize)
+	previous := make([]float64, fingerprintBands)
+	current := make([]float64, fingerprintBands)
+
+	for n := 0; n < frames; n++ {
+		offset := n * fingerprintFrameHop
+		for i := range frame {
+			frame[i] = complex(samples[offset+i]*window[i], 0)
+		}
+		fft(frame)
+
+		for b := 0; b < fingerprintBands; b++ {
+			energy := 0.0
+			for k := edges[b]; k < edges[b+1] || k == edges[b]; k++ {
+				magnitude := cmplx.Abs(frame[k])
+				energy += magnitude * magnitude
+			}
+			current[b] = energy
+		}
+
+		if n > 0 {
+			var sub uint32
+			for b := 0; b < fingerprintBands-1; b++ {
+				if current[b]-current[b+1]-(previous[b]-previous[b+1]) > 0 {
+					sub |= 1 << b
+				}
+			}
+			fingerprint = append(fingerprint, sub)
+		}
+
+		previous, current = current, previous
+	}
+
+	return fingerprint
+}
+
+// CompareAcousticFingerprints returns the lowest bit error rate of a against b,
+// trying to align them by shifting up to maxOffset sub-fingerprints, and only the first maxLength sub-fingerprints are compared.
+// 0 means identical, and about 0.5 means unrelated.
+func CompareAcousticFingerprints(a, b AcousticFingerprint, maxOffset, maxLength int) float64 {
+	best := 1.0
+	for offset := -maxOffset; offset <= maxOffset; offset++ {
+		start := max(0, -offset)
+		end := min(len(a), len(b)-offset, start+maxLength)
+		// too little overlap is not reliable
+		if end-start < min(len(a), len(b), maxLength)/2 || end <= start {
+			continue
+		}
+
+		errorBits := 0
+		for i := start; i < end; i++ {
+			errorBits += bits.OnesCount32(a[i] ^ b[i+offset])
+		}
+
+		if rate := float64(errorBits) / float64((end-start)*(fingerprintBands-1)); rate < best {
+			best = rate
+		}
+	}
+	return best
+}
+
+// fft is an in-place radix-2 Cooley-Tukey transform, len(x) must be a power of 2
+func fft(x []complex128) {
+	n := len(x)
+
+	for i, j := 1, 0; i < n; i++ {
+		bit := n >> 1
+		for ; j&bit != 0; bit >>= 1 {
+			j ^= bit
+		}
+		j ^= bit
+		if i < j {
+			x[i], x[j] = x[j], x[i]
+		}
+	}
+
+	for size := 2; size <= n; size <<= 1 {
+		step := cmplx.Exp(complex(0, -2*math.Pi/float64(size)))
+		for start := 0; start < n; start += size {
+			w := complex(1, 0)
+			for k := 0; k < size/2; k++ {
+				u := x[start+k]
+				v := x[start+k+size/2] * w
+				x[start+k] = u + v
+				x[start+k+size/2] = u - v
+				w *= step
+			}
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package ffmpeg
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// melody generates seconds of random notes at FingerprintSampleRate
+func melody(seed int64, seconds int) []float64 {
+	r := rand.New(rand.NewSource(seed))
+	samples := make([]float64, seconds*FingerprintSampleRate)
+	noteLength := FingerprintSampleRate / 4
+	for start := 0; start < len(samples); start += noteLength {
+		freq := 220 * math.Pow(2, float64(r.Intn(36))/12)
+		for i := start; i < start+noteLength && i < len(samples); i++ {
+			t := float64(i) / FingerprintSampleRate
+			samples[i] = 0.5*math.Sin(2*math.Pi*freq*t) + 0.25*math.Sin(4*math.Pi*freq*t)
+		}
+	}
+	return samples
+}
+
+func TestAcousticFingerprint(t *testing.T) {
+	original := melody(1, 20)
+
+	r := rand.New(rand.NewSource(2))
+	reencoded := make([]float64, len(original)-100)
+	for i := range reencoded {
+		reencoded[i] = original[i+100]*0.6 + (r.Float64()-0.5)*0.02
+	}
+
+	a := ComputeAcousticFingerprint(original)
+	b := ComputeAcousticFingerprint(reencoded)
+	c := ComputeAcousticFingerprint(melody(3, 20))
+
+	if same := CompareAcousticFingerprints(a, b, 16, 1024); same >= DefaultFingerprintThreshold {
+		t.Fatal("same recording should match, bit error rate:", same)
+	}
+	if different := CompareAcousticFingerprints(a, c, 16, 1024); different < DefaultFingerprintThreshold {
+		t.Fatal("different recordings should not match, bit error rate:", different)
+	}
+
+	parsed, err := ParseAcousticFingerprint(a.String())
+	if err != nil {
+		t.Fatal(err)
+	} else if CompareAcousticFingerprints(a, parsed, 0, len(a)) != 0 {
+		t.Fatal("parsed fingerprint differs")
+	}
+}
+
+func TestFFT(t *testing.T) {
+	x := make([]complex128, 8)
+	for i := range x {
+		x[i] = complex(math.Cos(2*math.Pi*float64(i)/8), 0)
+	}
+	fft(x)
+	if math.Abs(real(x[1])-4) > 1e-9 || math.Abs(real(x[7])-4) > 1e-9 || math.Abs(real(x[0])) > 1e-9 {
+		t.Fatal("unexpected spectrum", x)
+	}
+}
@@ -0,0 +1,227 @@
+package ffmpeg
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	HLSPlaylist     = "index.m3u8"
+	HLSPollInterval = 200 * time.Millisecond // how often a running generation is checked for its first segments
+)
+
+// HLSBitRates are the AAC renditions listed in the master playlist, from low to high
+var HLSBitRates = []string{"64k", "128k", "256k"}
+
+type hlsJob struct {
+	ready chan struct{} // closed once playlists and the first segments are written
+	done  chan struct{} // closed once ffmpeg exits
+	err   error
+}
+
+var (
+	hlsJobs      = map[string]*hlsJob{}
+	hlsJobsMutex sync.Mutex
+)
+
+func hlsVariantPlaylist(folder string, index int) string {
+	return path.Join(folder, fmt.Sprintf("stream_%d.m3u8", index))
+}
+
+// hlsReady tells whether the master playlist and every variant playlist exist,
+// playlists are renamed into place only after their first segments are complete
+func hlsReady(folder string) bool {
+	if _, err := os.Stat(path.Join(folder, HLSPlaylist)); err != nil {
+		return false
+	}
+	for i := range HLSBitRates {
+		if _, err := os.Stat(hlsVariantPlaylist(folder, i)); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// hlsComplete tells whether every variant playlist is ended
+func hlsComplete(folder string) bool {
+	for i := range HLSBitRates {
+		playlist, err := os.ReadFile(hlsVariantPlaylist(folder, i))
+		if err != nil || !bytes.Contains(playlist, []byte("#EXT-X-ENDLIST")) {
+			return false
+		}
+	}
+	return true
+}
+
+// GenerateHLS writes a master playlist named HLSPlaylist and fMP4 segments for each of HLSBitRates into folder.
+// ffmpeg runs in background, and it returns once the first segments are ready, so that players can start early,
+// variant playlists grow as an EVENT playlist until they are ended.
+// It does nothing if folder is complete, and concurrent calls for the same folder share the running ffmpeg.
+func GenerateHLS(filename, folder string) error {
+	if hlsComplete(folder) {
+		return nil
+	}
+
+	hlsJobsMutex.Lock()
+	job, ok := hlsJobs[folder]
+	if !ok {
+		// finished right before the lock
+		if hlsComplete(folder) {
+			hlsJobsMutex.Unlock()
+			return nil
+		}
+
+		cmd, stderr, err := startHLS(filename, folder)
+		if err != nil {
+			hlsJobsMutex.Unlock()
+			return err
+		}
+
+		job = &hlsJob{ready: make(chan struct{}), done: make(chan struct{})}
+		hlsJobs[folder] = job
+		go job.wait(cmd, stderr, folder)
+	}
+	hlsJobsMutex.Unlock()
+
+	select {
+	case <-job.ready:
+		return nil
+	case <-job.done:
+		return job.err
+	}
+}
+
+// startHLS starts ffmpeg on an empty folder, a folder left incomplete by a previous run is removed
+func startHLS(filename, folder string) (*exec.Cmd, *bytes.Buffer, error) {
+	err := os.RemoveAll(folder)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = os.MkdirAll(folder, 0755)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	args := []string{
+		"-hide_banner",
+		"-loglevel", "error",
+		"-i", filename,
+	}
+
+	streamMap := make([]string, len(HLSBitRates))
+	for i := range HLSBitRates {
+		args = append(args, "-map", "0:a:0")
+		streamMap[i] = fmt.Sprintf("a:%d", i)
+	}
+
+	args = append(args, "-c:a", "aac")
+	for i, bitRate := range HLSBitRates {
+		args = append(args, fmt.Sprintf("-b:a:%d", i), bitRate)
+	}
+
+	args = append(
+		args,
+		"-f", "hls",
+		"-hls_time", "10",
+		"-hls_playlist_type", "event",
+		"-hls_flags", "temp_file", // segments and playlists are renamed into place once written
+		"-hls_segment_type", "fmp4",
+		"-hls_fmp4_init_filename", "init_%v.mp4",
+		"-hls_segment_filename", path.Join(folder, "segment_%v_%05d.m4s"),
+		"-master_pl_name", HLSPlaylist,
+		"-var_stream_map", strings.Join(streamMap, " "),
+		path.Join(folder, "stream_%v.m3u8"),
+	)
+
+	cmd := exec.Command("ffmpeg", args...)
+
+	stderr := bytes.NewBuffer(nil)
+	cmd.Stderr = stderr
+
+	err = cmd.Start()
+	if err != nil {
+		_ = os.RemoveAll(folder)
+		return nil, nil, err
+	}
+
+	return cmd, stderr, nil
+}
+
+// wait marks job ready once the first segments are written, and forgets it once ffmpeg exits
+func (job *hlsJob) wait(cmd *exec.Cmd, stderr *bytes.Buffer, folder string) {
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+
+	ticker := time.NewTicker(HLSPollInterval)
+	defer ticker.Stop()
+
+	ready := false
+	for {
+		select {
+		case err := <-exited:
+			if err != nil {
+				job.err = fmt.Errorf("ffmpeg: %w: %s", err, stderr.String())
+				_ = os.RemoveAll(folder)
+			}
+
+			hlsJobsMutex.Lock()
+			delete(hlsJobs, folder)
+			hlsJobsMutex.Unlock()
+
+			close(job.done)
+			return
+		case <-ticker.C:
+			if !ready && hlsReady(folder) {
+				ready = true
+				close(job.ready)
+			}
+		}
+	}
+}
+
+func GetHLSContentType(filename string) string {
+	switch path.Ext(filename) {
+	case ".m3u8":
+		return "application/vnd.apple.mpegurl"
+	case ".m4s":
+		return "video/iso.segment"
+	case ".mp4":
+		return "audio/mp4"
+	default:
+		return "application/octet-stream"
+	}
+}
+
+var hlsMapURIPattern = regexp.MustCompile(`URI="([^"?]+)"`)
+
+// AppendQueryToPlaylist appends query to every uri in an m3u8 playlist,
+// so that sub-resources fetched by players carry it too, e.g. ?token=
+func AppendQueryToPlaylist(playlist []byte, query string) []byte {
+	if query == "" {
+		return playlist
+	}
+
+	lines := strings.Split(string(playlist), "\n")
+	for i, line := range lines {
+		line = strings.TrimRight(line, "\r")
+		if line == "" {
+			continue
+		} else if strings.HasPrefix(line, "#") {
+			lines[i] = hlsMapURIPattern.ReplaceAllString(line, `URI="$1?`+query+`"`)
+		} else if !strings.Contains(line, "?") {
+			lines[i] = line + "?" + query
+		}
+	}
+
+	return []byte(strings.Join(lines, "\n"))
+}
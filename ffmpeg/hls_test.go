@@ -0,0 +1,14 @@
+package ffmpeg
+
+import (
+	"testing"
+)
+
+func TestAppendQueryToPlaylist(t *testing.T) {
+	playlist := "#EXTM3U\n#EXT-X-MAP:URI=\"init_0.mp4\"\n#EXTINF:6.0,\nsegment_0_00000.m4s\n#EXT-X-ENDLIST\n"
+	expected := "#EXTM3U\n#EXT-X-MAP:URI=\"init_0.mp4?token=t\"\n#EXTINF:6.0,\nsegment_0_00000.m4s?token=t\n#EXT-X-ENDLIST\n"
+
+	if result := string(AppendQueryToPlaylist([]byte(playlist), "token=t")); result != expected {
+		t.Fatal("unexpected playlist", result)
+	}
+}
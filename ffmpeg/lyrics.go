@@ -0,0 +1,304 @@
+package ffmpeg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"github.com/allape/homesong/lyrics"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"unicode/utf16"
+)
+
+// EmbeddedLyrics is lyrics found in tags of a file, synced lyrics are converted to LRC
+type EmbeddedLyrics struct {
+	Source      string `json:"source"`   // USLT, SYLT, or the tag key like LYRICS
+	Language    string `json:"language"` // ISO 639-2 code of ID3 frames, empty if unknown
+	Description string `json:"description"`
+	Content     string `json:"content"`
+}
+
+// EmbeddedLyrics returns unsynced lyrics in tags, which are ID3 USLT frames (lyrics-eng),
+// Vorbis and APE LYRICS or UNSYNCEDLYRICS, and MP4 ©lyr
+func (f *FFProbeJson) EmbeddedLyrics() []EmbeddedLyrics {
+	tagMaps := []map[string]string{f.Format.Tags}
+	if stream := f.AudioStream(); stream != nil {
+		tagMaps = append(tagMaps, stream.Tags)
+	}
+
+	var list []EmbeddedLyrics
+	for _, tags := range tagMaps {
+		keys := make([]string, 0, len(tags))
+		for key := range tags {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			content := normalizeLyrics(tags[key])
+			if content == "" {
+				continue
+			}
+
+			embedded := EmbeddedLyrics{Source: strings.ToUpper(key), Content: content}
+			switch lowerKey := strings.ToLower(key); {
+			case lowerKey == "lyrics" || lowerKey == "unsyncedlyrics" || lowerKey == "unsynced lyrics":
+			case strings.HasPrefix(lowerKey, "lyrics-"):
+				// lyrics-eng, or lyrics-description-eng
+				embedded.Source = "USLT"
+				description, language := "", key[len("lyrics-"):]
+				if index := strings.LastIndex(language, "-"); index != -1 {
+					description, language = language[:index], language[index+1:]
+				}
+				embedded.Language, embedded.Description = language, description
+			default:
+				continue
+			}
+
+			if !containsLyrics(list, content) {
+				list = append(list, embedded)
+			}
+		}
+	}
+	return list
+}
+
+// ExtractLyrics returns synced lyrics in ID3 SYLT frames, which ffprobe does not read, followed by FFProbeJson.EmbeddedLyrics
+func ExtractLyrics(filename string, ffprobe *FFProbeJson) ([]EmbeddedLyrics, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	list, err := ReadSYLT(file)
+	if err != nil {
+		return nil, err
+	}
+
+	if ffprobe == nil {
+		_, err = file.Seek(0, io.SeekStart)
+		if err != nil {
+			return nil, err
+		}
+		ffprobe, _, err = FFProbeReader(file)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, embedded := range ffprobe.EmbeddedLyrics() {
+		if !containsLyrics(list, embedded.Content) {
+			list = append(list, embedded)
+		}
+	}
+	return list, nil
+}
+
+// MaxID3TagSize limits the ID3 tag read by ReadSYLT, which may be up to 256MB by its header
+const MaxID3TagSize = 16 << 20
+
+// ReadSYLT reads SYLT frames with millisecond timestamps in the ID3v2.3 or ID3v2.4 tag at the start of reader.
+// An entry starting with a line break begins a new line, and the entries of a line become words of it;
+// if no entry does, every entry is a line.
+func ReadSYLT(reader io.Reader) ([]EmbeddedLyrics, error) {
+	header := make([]byte, 10)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	version := header[3]
+	if string(header[:3]) != "ID3" || (version != 3 && version != 4) {
+		return nil, nil
+	}
+
+	// the size in header is not trusted, frames beyond MaxID3TagSize or the end of reader are ignored
+	tag, err := io.ReadAll(io.LimitReader(reader, int64(min(syncsafe(header[6:10]), MaxID3TagSize))))
+	if err != nil {
+		return nil, err
+	}
+
+	flags := header[5]
+	if version == 3 && flags&0x80 != 0 {
+		tag = removeUnsynchronisation(tag)
+	}
+	if flags&0x40 != 0 && len(tag) >= 4 {
+		// extended header, its size excludes the size field itself in ID3v2.3
+		size := int(binary.BigEndian.Uint32(tag[:4])) + 4
+		if version == 4 {
+			size = syncsafe(tag[:4])
+		}
+		tag = tag[min(size, len(tag)):]
+	}
+
+	var list []EmbeddedLyrics
+	for len(tag) >= 10 && tag[0] != 0 {
+		id := string(tag[:4])
+		size := int(binary.BigEndian.Uint32(tag[4:8]))
+		if version == 4 {
+			size = syncsafe(tag[4:8])
+		}
+		frameFlags := tag[9]
+		if size > len(tag)-10 {
+			break
+		}
+		frame := tag[10 : 10+size]
+		tag = tag[10+size:]
+
+		if id != "SYLT" {
+			continue
+		}
+		if version == 4 {
+			if frameFlags&0x0c != 0 { // compressed or encrypted
+				continue
+			}
+			if frameFlags&0x01 != 0 && len(frame) >= 4 { // data length indicator
+				frame = frame[4:]
+			}
+			if frameFlags&0x02 != 0 {
+				frame = removeUnsynchronisation(frame)
+			}
+		} else if frameFlags&0xc0 != 0 { // compressed or encrypted
+			continue
+		}
+
+		if embedded, ok := parseSYLT(frame); ok && !containsLyrics(list, embedded.Content) {
+			list = append(list, embedded)
+		}
+	}
+	return list, nil
+}
+
+// parseSYLT reads the body of a SYLT frame, frames timed in MPEG frames are skipped
+func parseSYLT(frame []byte) (EmbeddedLyrics, bool) {
+	if len(frame) < 6 || frame[4] != 2 {
+		return EmbeddedLyrics{}, false
+	}
+	encoding := frame[0]
+	embedded := EmbeddedLyrics{Source: "SYLT", Language: strings.TrimRight(string(frame[1:4]), "\x00 ")}
+
+	rest := frame[6:]
+	embedded.Description, rest = readID3Text(encoding, rest)
+
+	type entry struct {
+		time float64
+		text string
+	}
+	var (
+		entries   []entry
+		lineBreak = false
+	)
+	for len(rest) > 0 {
+		var text string
+		text, rest = readID3Text(encoding, rest)
+		if len(rest) < 4 {
+			break
+		}
+		time := float64(binary.BigEndian.Uint32(rest[:4])) / 1000
+		rest = rest[4:]
+
+		text = strings.ReplaceAll(strings.ReplaceAll(text, "\r\n", "\n"), "\r", "\n")
+		lineBreak = lineBreak || strings.HasPrefix(text, "\n")
+		entries = append(entries, entry{time: time, text: text})
+	}
+
+	var lines []lyrics.Line
+	for _, e := range entries {
+		if !lineBreak {
+			lines = append(lines, lyrics.Line{Time: e.time, Text: strings.TrimSpace(e.text)})
+			continue
+		}
+		if len(lines) == 0 || strings.HasPrefix(e.text, "\n") {
+			lines = append(lines, lyrics.Line{Time: e.time})
+		}
+		line := &lines[len(lines)-1]
+		word := strings.TrimLeft(e.text, "\n")
+		line.Words = append(line.Words, lyrics.Word{Time: e.time, Text: word})
+		line.Text += word
+	}
+
+	for i := range lines {
+		lines[i].Text = strings.TrimSpace(lines[i].Text)
+		if len(lines[i].Words) < 2 {
+			lines[i].Words = nil
+		}
+	}
+	if len(lines) == 0 {
+		return embedded, false
+	}
+
+	embedded.Content = normalizeLyrics(string(lyrics.WriteLRC("", lines)))
+	return embedded, true
+}
+
+// readID3Text reads a string terminated by the terminator of encoding, and returns the rest after it
+func readID3Text(encoding byte, data []byte) (string, []byte) {
+	if encoding == 0 || encoding == 3 {
+		end := bytes.IndexByte(data, 0)
+		if end == -1 {
+			end = len(data)
+		}
+		text := data[:end]
+		rest := data[min(end+1, len(data)):]
+		if encoding == 3 {
+			return string(text), rest
+		}
+		runes := make([]rune, len(text))
+		for i, b := range text {
+			runes[i] = rune(b)
+		}
+		return string(runes), rest
+	}
+
+	end := len(data) - len(data)%2
+	for i := 0; i+1 < len(data); i += 2 {
+		if data[i] == 0 && data[i+1] == 0 {
+			end = i
+			break
+		}
+	}
+	text := data[:end]
+	rest := data[min(end+2, len(data)):]
+
+	var order binary.ByteOrder = binary.BigEndian
+	if encoding == 1 && len(text) >= 2 {
+		if text[0] == 0xff && text[1] == 0xfe {
+			order, text = binary.LittleEndian, text[2:]
+		} else if text[0] == 0xfe && text[1] == 0xff {
+			text = text[2:]
+		}
+	}
+	units := make([]uint16, len(text)/2)
+	for i := range units {
+		units[i] = order.Uint16(text[i*2:])
+	}
+	return string(utf16.Decode(units)), rest
+}
+
+func syncsafe(b []byte) int {
+	return int(b[0]&0x7f)<<21 | int(b[1]&0x7f)<<14 | int(b[2]&0x7f)<<7 | int(b[3]&0x7f)
+}
+
+// removeUnsynchronisation turns every 0xFF 0x00 back into 0xFF
+func removeUnsynchronisation(data []byte) []byte {
+	return bytes.ReplaceAll(data, []byte{0xff, 0x00}, []byte{0xff})
+}
+
+func normalizeLyrics(content string) string {
+	return strings.TrimSpace(strings.ReplaceAll(strings.ReplaceAll(content, "\r\n", "\n"), "\r", "\n"))
+}
+
+func containsLyrics(list []EmbeddedLyrics, content string) bool {
+	for _, embedded := range list {
+		if embedded.Content == content {
+			return true
+		}
+	}
+	return false
+}
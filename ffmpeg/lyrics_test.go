@@ -0,0 +1,76 @@
+package ffmpeg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"unicode/utf16"
+)
+
+func TestEmbeddedLyrics(t *testing.T) {
+	ffprobe, err := ParseFFProbeJson(`{
+		"streams": [
+			{"index": 0, "codec_name": "flac", "codec_type": "audio", "tags": {"LYRICS": "My, my\r\nAt Waterloo"}}
+		],
+		"format": {"format_name": "mp3", "tags": {"lyrics-eng": "My, my\nAt Waterloo", "lyrics-Live-swe": "Min, min"}}
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	list := ffprobe.EmbeddedLyrics()
+	if len(list) != 2 {
+		t.Fatal("unexpected lyrics", list)
+	} else if list[0].Source != "USLT" || list[0].Language != "swe" || list[0].Description != "Live" || list[0].Content != "Min, min" {
+		t.Fatal("unexpected USLT", list[0])
+	} else if list[1].Language != "eng" || list[1].Content != "My, my\nAt Waterloo" {
+		t.Fatal("unexpected USLT", list[1])
+	}
+}
+
+func TestReadSYLT(t *testing.T) {
+	utf16Text := func(text string) []byte {
+		var buffer bytes.Buffer
+		buffer.Write([]byte{0xff, 0xfe})
+		for _, unit := range utf16.Encode([]rune(text)) {
+			_ = binary.Write(&buffer, binary.LittleEndian, unit)
+		}
+		buffer.Write([]byte{0, 0})
+		return buffer.Bytes()
+	}
+
+	// UTF-16, eng, milliseconds, lyrics, descriptor
+	frame := append([]byte{1, 'e', 'n', 'g', 2, 1}, utf16Text("")...)
+	for _, entry := range []struct {
+		text string
+		time uint32
+	}{{"My, ", 10000}, {"my", 10500}, {"\nWaterloo", 20000}} {
+		frame = append(frame, utf16Text(entry.text)...)
+		frame = binary.BigEndian.AppendUint32(frame, entry.time)
+	}
+
+	tag := append([]byte("SYLT"), binary.BigEndian.AppendUint32(nil, uint32(len(frame)))...)
+	tag = append(append(tag, 0, 0), frame...)
+
+	size := len(tag)
+	header := []byte{'I', 'D', '3', 3, 0, 0, byte(size >> 21 & 0x7f), byte(size >> 14 & 0x7f), byte(size >> 7 & 0x7f), byte(size & 0x7f)}
+
+	list, err := ReadSYLT(bytes.NewReader(append(header, tag...)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "[00:10.00]<00:10.00>My, <00:10.50>my\n[00:20.00]Waterloo"
+	if len(list) != 1 || list[0].Source != "SYLT" || list[0].Language != "eng" || list[0].Content != expected {
+		t.Fatal("unexpected lyrics", list)
+	}
+
+	// a tag claiming 256MB, cut short
+	header = []byte{'I', 'D', '3', 3, 0, 0, 0x7f, 0x7f, 0x7f, 0x7f}
+	list, err = ReadSYLT(bytes.NewReader(append(header, tag...)))
+	if err != nil {
+		t.Fatal(err)
+	} else if len(list) != 1 || list[0].Content != expected {
+		t.Fatal("unexpected lyrics of truncated tag", list)
+	}
+}
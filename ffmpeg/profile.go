@@ -0,0 +1,49 @@
+package ffmpeg
+
+type ProfileName string
+
+const (
+	ProfileMP3      ProfileName = "mp3"
+	ProfileMP3At128 ProfileName = "mp3-128"
+	ProfileMP3At320 ProfileName = "mp3-320"
+	ProfileOpus96   ProfileName = "opus-96"
+	ProfileAAC256   ProfileName = "aac-256"
+	ProfileFLAC     ProfileName = "flac"
+)
+
+const DefaultProfile = ProfileMP3
+
+type Profile struct {
+	Name    ProfileName `json:"name"`
+	Codec   CodecName   `json:"codec"`   // encoder for -c:a
+	Format  string      `json:"format"`  // muxer for -f
+	BitRate string      `json:"bitRate"` // empty for encoder default
+	Ext     string      `json:"ext"`
+	MIME    string      `json:"mime"`
+}
+
+var Profiles = map[ProfileName]Profile{
+	ProfileMP3:      {Name: ProfileMP3, Codec: "mp3", Format: "mp3", Ext: ".mp3", MIME: "audio/mpeg"},
+	ProfileMP3At128: {Name: ProfileMP3At128, Codec: "mp3", Format: "mp3", BitRate: "128k", Ext: ".mp3", MIME: "audio/mpeg"},
+	ProfileMP3At320: {Name: ProfileMP3At320, Codec: "mp3", Format: "mp3", BitRate: "320k", Ext: ".mp3", MIME: "audio/mpeg"},
+	ProfileOpus96:   {Name: ProfileOpus96, Codec: "libopus", Format: "ogg", BitRate: "96k", Ext: ".opus", MIME: "audio/ogg"},
+	ProfileAAC256:   {Name: ProfileAAC256, Codec: "aac", Format: "adts", BitRate: "256k", Ext: ".aac", MIME: "audio/aac"},
+	ProfileFLAC:     {Name: ProfileFLAC, Codec: "flac", Format: "flac", Ext: ".flac", MIME: "audio/flac"},
+}
+
+// Passthrough reports whether a source stream encoded with codec can be copied as is
+func (p Profile) Passthrough(codec CodecName) bool {
+	return p.BitRate == "" && codec != "" && codec == p.Codec
+}
+
+func (p Profile) Args(copyAudio bool) []string {
+	if copyAudio {
+		return []string{"-c:a", "copy", "-f", p.Format}
+	}
+
+	args := []string{"-c:a", string(p.Codec)}
+	if p.BitRate != "" {
+		args = append(args, "-b:a", p.BitRate)
+	}
+	return append(args, "-f", p.Format)
+}
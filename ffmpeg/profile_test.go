@@ -0,0 +1,30 @@
+package ffmpeg
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestProfileArgs(t *testing.T) {
+	args := Profiles[ProfileMP3At320].Args(false)
+	if !slices.Equal(args, []string{"-c:a", "mp3", "-b:a", "320k", "-f", "mp3"}) {
+		t.Fatal("unexpected args", args)
+	}
+
+	args = Profiles[ProfileFLAC].Args(true)
+	if !slices.Equal(args, []string{"-c:a", "copy", "-f", "flac"}) {
+		t.Fatal("unexpected args", args)
+	}
+}
+
+func TestProfilePassthrough(t *testing.T) {
+	if !Profiles[ProfileFLAC].Passthrough("flac") {
+		t.Fatal("flac should be passed through")
+	}
+	if Profiles[ProfileMP3At128].Passthrough("mp3") {
+		t.Fatal("mp3 with bitrate should be re-encoded")
+	}
+	if Profiles[ProfileFLAC].Passthrough("alac") {
+		t.Fatal("alac should be re-encoded")
+	}
+}
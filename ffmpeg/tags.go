@@ -0,0 +1,91 @@
+package ffmpeg
+
+import (
+	"strconv"
+	"strings"
+)
+
+// ArtistSeparators splits multi-value artist tags, ID3v2.4 values are joined with ";" by ffprobe.
+// Slashes are left out, they are part of names like "AC/DC".
+var ArtistSeparators = []string{";", "；", "、"}
+
+type SongTags struct {
+	Title        string   `json:"title"`
+	Artists      []string `json:"artists"`
+	AlbumArtists []string `json:"albumArtists"`
+	Album        string   `json:"album"`
+	Composers    []string `json:"composers"`
+	Lyricists    []string `json:"lyricists"`
+	Track        int      `json:"track"`
+	TrackTotal   int      `json:"trackTotal"`
+	Disc         int      `json:"disc"`
+	DiscTotal    int      `json:"discTotal"`
+}
+
+// Tag returns the value of a ID3/Vorbis/MP4 tag by case-insensitive key,
+// format level tags are preferred, and audio stream level tags (Ogg, Opus) are used as fallback
+func (f *FFProbeJson) Tag(keys ...string) string {
+	tagMaps := []map[string]string{f.Format.Tags}
+	if stream := f.AudioStream(); stream != nil {
+		tagMaps = append(tagMaps, stream.Tags)
+	}
+
+	for _, key := range keys {
+		for _, tags := range tagMaps {
+			for k, v := range tags {
+				if strings.EqualFold(k, key) {
+					if v = strings.TrimSpace(v); v != "" {
+						return v
+					}
+				}
+			}
+		}
+	}
+
+	return ""
+}
+
+func (f *FFProbeJson) SongTags() SongTags {
+	tags := SongTags{
+		Title:        f.Tag("title"),
+		Artists:      SplitArtists(f.Tag("artist", "artists")),
+		AlbumArtists: SplitArtists(f.Tag("album_artist", "album artist", "albumartist")),
+		Album:        f.Tag("album"),
+		Composers:    SplitArtists(f.Tag("composer")),
+		Lyricists:    SplitArtists(f.Tag("lyricist", "writer")),
+	}
+
+	tags.Track, tags.TrackTotal = parseNumberOfTotal(f.Tag("track", "tracknumber"))
+	if tags.TrackTotal == 0 {
+		tags.TrackTotal = int(parseInt(f.Tag("tracktotal", "totaltracks")))
+	}
+
+	tags.Disc, tags.DiscTotal = parseNumberOfTotal(f.Tag("disc", "discnumber"))
+	if tags.DiscTotal == 0 {
+		tags.DiscTotal = int(parseInt(f.Tag("disctotal", "totaldiscs")))
+	}
+
+	return tags
+}
+
+func SplitArtists(str string) []string {
+	for _, sep := range ArtistSeparators[1:] {
+		str = strings.ReplaceAll(str, sep, ArtistSeparators[0])
+	}
+
+	var artists []string
+	for _, artist := range strings.Split(str, ArtistSeparators[0]) {
+		if artist = strings.TrimSpace(artist); artist != "" {
+			artists = append(artists, artist)
+		}
+	}
+	return artists
+}
+
+// parseNumberOfTotal parses values like "3/12" or "3"
+func parseNumberOfTotal(str string) (int, int) {
+	number, total, _ := strings.Cut(str, "/")
+	n, _ := strconv.Atoi(strings.TrimSpace(number))
+	t, _ := strconv.Atoi(strings.TrimSpace(total))
+	return n, t
+}
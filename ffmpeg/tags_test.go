@@ -0,0 +1,33 @@
+package ffmpeg
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestSongTags(t *testing.T) {
+	ffprobe, err := ParseFFProbeJson(`{
+		"streams": [
+			{"index": 0, "codec_name": "vorbis", "codec_type": "audio", "tags": {"TITLE": "Song", "ARTIST": "A; B", "TRACKNUMBER": "3", "TRACKTOTAL": "12"}}
+		],
+		"format": {"format_name": "ogg", "tags": {"album": "Album", "disc": "1/2"}}
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tags := ffprobe.SongTags()
+	if tags.Title != "Song" || tags.Album != "Album" {
+		t.Fatal("unexpected title or album", tags)
+	} else if !slices.Equal(tags.Artists, []string{"A", "B"}) {
+		t.Fatal("unexpected artists", tags.Artists)
+	} else if tags.Track != 3 || tags.TrackTotal != 12 || tags.Disc != 1 || tags.DiscTotal != 2 {
+		t.Fatal("unexpected track or disc", tags)
+	}
+}
+
+func TestSplitArtists(t *testing.T) {
+	if artists := SplitArtists(" A、B ; C;;AC/DC"); !slices.Equal(artists, []string{"A", "B", "C", "AC/DC"}) {
+		t.Fatal("unexpected artists", artists)
+	}
+}
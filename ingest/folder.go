@@ -0,0 +1,280 @@
+package ingest
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/allape/gocrud"
+	"github.com/allape/homesong/model"
+	"github.com/h2non/filetype"
+	"gorm.io/gorm"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type ImportStatus string
+
+const (
+	Imported   ImportStatus = "imported"
+	Duplicated ImportStatus = "duplicated"
+	Skipped    ImportStatus = "skipped"
+	Failed     ImportStatus = "failed"
+)
+
+type ImportRecord struct {
+	Path    string       `json:"path"`
+	Size    int64        `json:"size"`
+	ModTime time.Time    `json:"modTime"`
+	Status  ImportStatus `json:"status"`
+	SongID  gocrud.ID    `json:"songId,omitempty"`
+	Digest  string       `json:"digest,omitempty"`
+	Error   string       `json:"error,omitempty"`
+}
+
+type ImportConfig struct {
+	ImportTags bool
+	LogFile    string // JSON lines of ImportRecord, files finished in previous runs are not imported again
+}
+
+type ImportReport struct {
+	Imported   int `json:"imported"`
+	Duplicated int `json:"duplicated"`
+	Skipped    int `json:"skipped"`
+	Failed     int `json:"failed"`
+	Resumed    int `json:"resumed"`
+}
+
+// ImportFolder walks folder and imports every audio file in it with ImportFile,
+// hidden files and folders are ignored, onRecord is called after each file if it is not nil
+func ImportFolder(db *gorm.DB, folder string, config ImportConfig, onRecord func(record ImportRecord)) (ImportReport, error) {
+	var report ImportReport
+
+	finished, err := readImportLog(config.LogFile)
+	if err != nil {
+		return report, err
+	}
+
+	var logFile *os.File
+	if config.LogFile != "" {
+		logFile, err = os.OpenFile(config.LogFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return report, err
+		}
+		defer func() {
+			_ = logFile.Close()
+		}()
+	}
+
+	finish := func(record ImportRecord) error {
+		switch record.Status {
+		case Imported:
+			report.Imported++
+		case Duplicated:
+			report.Duplicated++
+		case Skipped:
+			report.Skipped++
+		case Failed:
+			report.Failed++
+		}
+
+		if logFile != nil {
+			line, err := json.Marshal(record)
+			if err != nil {
+				return err
+			}
+			_, err = logFile.Write(append(line, '\n'))
+			if err != nil {
+				return err
+			}
+		}
+
+		if onRecord != nil {
+			onRecord(record)
+		}
+
+		return nil
+	}
+
+	err = filepath.WalkDir(folder, func(fullpath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if fullpath == folder {
+				return err
+			}
+			// an unreadable file or folder fails alone, instead of the rest of the import
+			if err := finish(ImportRecord{Path: fullpath, Status: Failed, Error: err.Error()}); err != nil {
+				return err
+			}
+			if entry != nil && entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if strings.HasPrefix(entry.Name(), ".") && fullpath != folder {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		} else if entry.IsDir() {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return finish(ImportRecord{Path: fullpath, Status: Failed, Error: err.Error()})
+		}
+
+		if record, ok := finished[fullpath]; ok && record.Size == info.Size() && record.ModTime.Equal(info.ModTime()) {
+			report.Resumed++
+			return nil
+		}
+
+		return finish(ImportFile(db, fullpath, config.ImportTags))
+	})
+
+	return report, err
+}
+
+// ImportFile imports the file at fullpath as a new song, unless it is not an audio file,
+// or a song with the same digest already exists
+func ImportFile(db *gorm.DB, fullpath string, importTags bool) ImportRecord {
+	record := ImportRecord{Path: fullpath}
+
+	song, err := importFile(db, fullpath, importTags, &record)
+	if err != nil {
+		record.Status = Failed
+		record.Error = err.Error()
+	} else if song != nil {
+		record.Status = Imported
+		record.SongID = song.ID
+	}
+
+	return record
+}
+
+func importFile(db *gorm.DB, fullpath string, importTags bool, record *ImportRecord) (*model.Song, error) {
+	file, err := os.Open(fullpath)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	record.Size = stat.Size()
+	record.ModTime = stat.ModTime()
+
+	head := make([]byte, 262)
+	n, err := io.ReadFull(file, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	if kind, _ := filetype.Match(head[:n]); !strings.HasPrefix(kind.MIME.Type, "audio") && !strings.HasPrefix(kind.MIME.Type, "video") {
+		record.Status = Skipped
+		return nil, nil
+	}
+
+	digest, err := digestOf(file)
+	if err != nil {
+		return nil, err
+	}
+	record.Digest = digest
+
+	var exist model.Song
+	if err := db.Model(&exist).Where("digest = ? AND deleted_at IS NULL", digest).First(&exist).Error; err == nil {
+		record.Status = Duplicated
+		record.SongID = exist.ID
+		return nil, nil
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	_, err = file.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+
+	var song model.Song
+
+	tags, err := SaveFile(&song, filepath.Base(fullpath), file, stat.Size(), importTags)
+	if err != nil {
+		return nil, err
+	}
+
+	if song.Name == "" {
+		song.Name = strings.TrimSuffix(filepath.Base(fullpath), filepath.Ext(fullpath))
+	}
+
+	err = SaveSong(db, &song, tags)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := LinkEmbeddedLyrics(db, &song); err != nil {
+		l.Warn().Printf("failed to extract lyrics of %s: %v", fullpath, err)
+	}
+
+	return &song, nil
+}
+
+// digestOf returns the same digest as gocrud.SaveAsDigestedFile does
+func digestOf(reader io.ReadSeeker) (string, error) {
+	_, err := reader.Seek(0, io.SeekStart)
+	if err != nil {
+		return "", err
+	}
+
+	hasher := sha256.New()
+	_, err = io.Copy(hasher, reader)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+func readImportLog(logFile string) (map[string]ImportRecord, error) {
+	finished := make(map[string]ImportRecord)
+
+	if logFile == "" {
+		return finished, nil
+	}
+
+	file, err := os.Open(logFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return finished, nil
+		}
+		return nil, err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var record ImportRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			l.Warn().Printf("invalid line in import log %s: %v", logFile, err)
+			continue
+		}
+		if record.Status == Failed {
+			delete(finished, record.Path)
+		} else {
+			finished[record.Path] = record
+		}
+	}
+
+	return finished, scanner.Err()
+}
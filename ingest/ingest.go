@@ -0,0 +1,184 @@
+package ingest
+
+import (
+	"bytes"
+	"errors"
+	"github.com/allape/gocrud"
+	"github.com/allape/gogger"
+	"github.com/allape/homesong/env"
+	"github.com/allape/homesong/ffmpeg"
+	"github.com/allape/homesong/model"
+	"github.com/h2non/filetype"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"io"
+	"path"
+	"strings"
+)
+
+var l = gogger.New("ingest")
+
+// SaveFile stores the content of reader into env.StaticFolder as a digested file,
+// then fills song with MIME, ffprobe info and cover extracted from it.
+// Tags are only returned when importTags is true, and they are used to fill empty name and index of song.
+func SaveFile(song *model.Song, filename string, reader io.Reader, size int64, importTags bool) (*ffmpeg.SongTags, error) {
+	saved, digest, err := gocrud.SaveAsDigestedFile(env.StaticFolder, filename, reader, size, "")
+	if err != nil {
+		return nil, err
+	}
+	song.Filename = string(saved)
+	song.Digest = string(digest)
+
+	fullpath := path.Join(env.StaticFolder, string(saved))
+
+	mime, err := filetype.MatchFile(fullpath)
+	if err != nil {
+		return nil, err
+	}
+	song.MIME = mime.MIME.Value
+
+	ffprobe, ffprobeJson, err := ffmpeg.FFProbe(fullpath)
+	if err != nil {
+		return nil, err
+	}
+	song.FFProbeInfo = ffprobeJson
+	FillAudioInfo(song, ffprobe)
+
+	if ffprobe.AudioStream() != nil {
+		// a song without fingerprint is still playable, see FillFingerprint
+		if fingerprint, err := ffmpeg.Fingerprint(fullpath); err != nil {
+			l.Warn().Printf("failed to fingerprint %s: %v", filename, err)
+		} else {
+			song.Fingerprint = fingerprint.String()
+		}
+	}
+
+	var tags *ffmpeg.SongTags
+	if importTags {
+		tags = gocrud.Pointer(ffprobe.SongTags())
+		if song.Name == "" {
+			song.Name = tags.Title
+		}
+		if song.Name == "" {
+			song.Name = strings.TrimSuffix(path.Base(filename), path.Ext(filename))
+		}
+		if song.Index == 0 {
+			song.Index = int32(tags.Track)
+		}
+	}
+
+	if song.Cover == "" {
+		coverBytes, coverExt, err := ffmpeg.ExtractCover(fullpath, ffprobe)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(coverBytes) > 0 {
+			cover, _, err := gocrud.SaveAsDigestedFile(
+				env.StaticFolder,
+				"cover"+ffmpeg.GetExtByCodecName(coverExt),
+				bytes.NewReader(coverBytes),
+				int64(len(coverBytes)),
+				"",
+			)
+			if err != nil {
+				return nil, err
+			}
+			song.Cover = string(cover)
+		}
+	}
+
+	return tags, nil
+}
+
+// SaveSong saves song, and links it to the collections in tags if tags is not nil
+func SaveSong(db *gorm.DB, song *model.Song, tags *ffmpeg.SongTags) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(song).Error; err != nil {
+			return err
+		}
+
+		if song.Fingerprint != "" {
+			if err := SaveFingerprint(tx, song.ID, song.Fingerprint); err != nil {
+				return err
+			}
+		}
+
+		if tags == nil {
+			return nil
+		}
+
+		_, err := LinkSongByTags(tx, song, *tags)
+		return err
+	})
+}
+
+func FillAudioInfo(song *model.Song, ffprobe *ffmpeg.FFProbeJson) {
+	info := ffprobe.AudioInfo()
+	song.Duration = info.Duration
+	song.BitRate = info.BitRate
+	song.SampleRate = info.SampleRate
+	song.Channels = info.Channels
+	song.Codec = string(info.Codec)
+	song.Container = info.Container
+}
+
+// FillFingerprint computes the fingerprint of a saved song, and stores it
+func FillFingerprint(db *gorm.DB, song *model.Song) error {
+	if song.Filename == "" {
+		return errors.New("file not found")
+	}
+
+	fingerprint, err := ffmpeg.Fingerprint(path.Join(env.StaticFolder, song.Filename))
+	if err != nil {
+		return err
+	}
+	song.Fingerprint = fingerprint.String()
+
+	return SaveFingerprint(db, song.ID, song.Fingerprint)
+}
+
+// SaveFingerprint stores the fingerprint of a song, or deletes it if fingerprint is empty
+func SaveFingerprint(db *gorm.DB, songId gocrud.ID, fingerprint string) error {
+	if fingerprint == "" {
+		return db.Where("song_id = ?", songId).Delete(&model.SongFingerprint{}).Error
+	}
+	return db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "song_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"fingerprint", "updated_at"}),
+	}).Create(&model.SongFingerprint{SongID: songId, Fingerprint: fingerprint}).Error
+}
+
+// FindFingerprints returns fingerprints of songs by their ids, songs without fingerprint are absent
+func FindFingerprints(db *gorm.DB, songIds []gocrud.ID) (map[gocrud.ID]string, error) {
+	var records []model.SongFingerprint
+	if err := db.Model(&records).Where("song_id IN ?", songIds).Find(&records).Error; err != nil {
+		return nil, err
+	}
+
+	fingerprints := make(map[gocrud.ID]string, len(records))
+	for _, record := range records {
+		fingerprints[record.SongID] = record.Fingerprint
+	}
+	return fingerprints, nil
+}
+
+// MoveFingerprints moves fingerprints out of the fingerprint column of songs, where they used to be saved,
+// the column is dropped afterward, which recreates the songs table on SQLite
+func MoveFingerprints(db *gorm.DB) error {
+	if !db.Migrator().HasColumn(&model.Song{}, "fingerprint") {
+		return nil
+	}
+
+	err := db.Exec(`
+INSERT INTO song_fingerprints (song_id, fingerprint, updated_at)
+SELECT songs.id, songs.fingerprint, songs.updated_at FROM songs
+WHERE songs.fingerprint IS NOT NULL AND songs.fingerprint != ''
+AND songs.id NOT IN (SELECT song_fingerprints.song_id FROM song_fingerprints)
+	`).Error
+	if err != nil {
+		return err
+	}
+
+	return db.Migrator().DropColumn(&model.Song{}, "fingerprint")
+}
@@ -0,0 +1,91 @@
+package ingest
+
+import (
+	"errors"
+	"github.com/allape/homesong/env"
+	"github.com/allape/homesong/ffmpeg"
+	"github.com/allape/homesong/lyrics"
+	"github.com/allape/homesong/model"
+	"gorm.io/gorm"
+	"path"
+	"strings"
+)
+
+// LinkEmbeddedLyrics extracts lyrics embedded in the file of song, and saves those not linked to song yet as new lyrics of it.
+// Lyrics with malformed LRC timestamps are skipped.
+func LinkEmbeddedLyrics(db *gorm.DB, song *model.Song) ([]model.Lyrics, error) {
+	if song.Filename == "" {
+		return nil, errors.New("file not found")
+	}
+
+	var ffprobe *ffmpeg.FFProbeJson
+	if song.FFProbeInfo != "" {
+		ffprobe, _ = ffmpeg.ParseFFProbeJson(song.FFProbeInfo)
+	}
+
+	embeddedList, err := ffmpeg.ExtractLyrics(path.Join(env.StaticFolder, song.Filename), ffprobe)
+	if err != nil || len(embeddedList) == 0 {
+		return nil, err
+	}
+
+	var exists []model.Lyrics
+	if err := db.Model(&exists).
+		Where("deleted_at IS NULL AND id IN (SELECT song_lyrics.lyrics_id FROM song_lyrics WHERE song_lyrics.song_id = ?)", song.ID).
+		Find(&exists).Error; err != nil {
+		return nil, err
+	}
+
+	var created []model.Lyrics
+	for _, embedded := range embeddedList {
+		if _, _, err := lyrics.Parse(embedded.Content); err != nil {
+			l.Warn().Printf("skipped %s lyrics of song %d: %v", embedded.Source, song.ID, err)
+			continue
+		}
+
+		linked := false
+		for _, exist := range exists {
+			linked = linked || strings.TrimSpace(strings.ReplaceAll(exist.Content, "\r\n", "\n")) == embedded.Content
+		}
+		if linked {
+			continue
+		}
+
+		description := "Embedded " + embedded.Source
+		if embedded.Language != "" {
+			description += " (" + embedded.Language + ")"
+		}
+		if embedded.Description != "" {
+			description += ": " + embedded.Description
+		}
+
+		created = append(created, model.Lyrics{
+			Name:        song.Name,
+			Index:       int32(len(exists) + len(created)),
+			Content:     embedded.Content,
+			Description: description,
+			Language:    lyrics.NormalizeLanguage(embedded.Language),
+			Kind:        model.LyricsKindOriginal,
+		})
+	}
+
+	if len(created) == 0 {
+		return nil, nil
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&created).Error; err != nil {
+			return err
+		}
+
+		songLyrics := make([]model.SongLyrics, len(created))
+		for i, record := range created {
+			songLyrics[i] = model.SongLyrics{SongID: song.ID, LyricsID: record.ID}
+		}
+		return tx.Create(&songLyrics).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return created, nil
+}
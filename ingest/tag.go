@@ -0,0 +1,203 @@
+package ingest
+
+import (
+	"github.com/allape/gocrud"
+	"github.com/allape/homesong/ffmpeg"
+	"github.com/allape/homesong/model"
+	"gorm.io/gorm"
+	"slices"
+	"strings"
+)
+
+// LinkSongByTags creates or gets the artist and album collections mentioned in tags,
+// and links them to song with matching roles, existing links are kept
+func LinkSongByTags(db *gorm.DB, song *model.Song, tags ffmpeg.SongTags) ([]model.CollectionSong, error) {
+	artists := tags.Artists
+	if len(artists) == 0 {
+		artists = tags.AlbumArtists
+	}
+
+	var collectionSongs []model.CollectionSong
+
+	for role, names := range map[model.Role][]string{
+		model.Singer:   artists,
+		model.Composer: tags.Composers,
+		model.Lyricist: tags.Lyricists,
+	} {
+		if len(names) == 0 {
+			continue
+		}
+
+		collections, err := CreateOrGetCollections(db, model.CollectionTypeArtist, names)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, collection := range collections {
+			collectionSongs = append(collectionSongs, model.CollectionSong{
+				SongID:       song.ID,
+				CollectionID: collection.ID,
+				Role:         role,
+			})
+		}
+	}
+
+	if tags.Album != "" {
+		albumArtists := tags.AlbumArtists
+		if len(albumArtists) == 0 {
+			albumArtists = tags.Artists
+		}
+
+		album, err := CreateOrGetAlbum(db, tags.Album, strings.Join(albumArtists, "; "))
+		if err != nil {
+			return nil, err
+		}
+
+		if album.Cover == "" && song.Cover != "" {
+			if err := db.Model(album).UpdateColumn("cover", song.Cover).Error; err != nil {
+				return nil, err
+			}
+		}
+
+		collectionSongs = append(collectionSongs, model.CollectionSong{
+			SongID:       song.ID,
+			CollectionID: album.ID,
+			Role:         model.Reserved,
+			Disc:         int32(tags.Disc),
+			Track:        int32(tags.Track),
+		})
+	}
+
+	var exists []model.CollectionSong
+	if err := db.Model(&exists).Where("song_id = ?", song.ID).Find(&exists).Error; err != nil {
+		return nil, err
+	}
+
+	var missing []model.CollectionSong
+out:
+	for _, collectionSong := range collectionSongs {
+		for _, exist := range exists {
+			if exist.CollectionID == collectionSong.CollectionID && exist.Role == collectionSong.Role {
+				continue out
+			}
+		}
+
+		// other roles of the same song share the position
+		for _, exist := range slices.Concat(exists, missing) {
+			if exist.CollectionID == collectionSong.CollectionID {
+				collectionSong.Position = exist.Position
+				break
+			}
+		}
+		if collectionSong.Position == 0 {
+			position, err := TrackPosition(db, collectionSong.CollectionID, collectionSong.Disc, collectionSong.Track)
+			if err != nil {
+				return nil, err
+			}
+			collectionSong.Position = position
+		}
+
+		missing = append(missing, collectionSong)
+	}
+
+	if len(missing) > 0 {
+		if err := db.Model(&model.CollectionSong{}).Create(&missing).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	return collectionSongs, nil
+}
+
+// NextPosition returns the position after the last song of a collection
+func NextPosition(db *gorm.DB, collectionId gocrud.ID) (int32, error) {
+	var last int32
+	err := db.Model(&model.CollectionSong{}).
+		Select("COALESCE(MAX(position), 0)").
+		Where("collection_id = ?", collectionId).
+		Scan(&last).Error
+	return last + 1, err
+}
+
+// TrackPosition returns the position for a track of an album, before the first song with a greater disc and track number,
+// songs after it are shifted. Songs without a track number are appended like NextPosition.
+func TrackPosition(db *gorm.DB, collectionId gocrud.ID, disc, track int32) (int32, error) {
+	if track == 0 {
+		return NextPosition(db, collectionId)
+	}
+
+	var next int32
+	err := db.Model(&model.CollectionSong{}).
+		Select("COALESCE(MIN(position), 0)").
+		Where("collection_id = ? AND track > 0 AND position > 0 AND (disc > ? OR (disc = ? AND track > ?))", collectionId, disc, disc, track).
+		Scan(&next).Error
+	if err != nil {
+		return 0, err
+	} else if next == 0 {
+		return NextPosition(db, collectionId)
+	}
+
+	err = db.Model(&model.CollectionSong{}).
+		Where("collection_id = ? AND position >= ?", collectionId, next).
+		UpdateColumn("position", gorm.Expr("position + 1")).Error
+	return next, err
+}
+
+// CreateOrGetAlbum gets the album named name of albumArtist, or creates it.
+// An album without album artist, like those imported before album artists were recorded, is claimed by the first album artist.
+func CreateOrGetAlbum(db *gorm.DB, name, albumArtist string) (*model.Collection, error) {
+	var albums []model.Collection
+	if err := db.Model(&albums).
+		Where("type = ? AND name = ? AND (album_artist = ? OR album_artist = '' OR album_artist IS NULL)", model.CollectionTypeAlbum, name, albumArtist).
+		Order("id").
+		Find(&albums).Error; err != nil {
+		return nil, err
+	}
+
+	for _, album := range albums {
+		if album.AlbumArtist == albumArtist {
+			return &album, nil
+		}
+	}
+
+	if len(albums) > 0 {
+		album := albums[0]
+		if err := db.Model(&album).UpdateColumn("album_artist", albumArtist).Error; err != nil {
+			return nil, err
+		}
+		album.AlbumArtist = albumArtist
+		return &album, nil
+	}
+
+	album := model.Collection{Type: model.CollectionTypeAlbum, Name: name, AlbumArtist: albumArtist}
+	if err := db.Model(&album).Create(&album).Error; err != nil {
+		return nil, err
+	}
+	return &album, nil
+}
+
+func CreateOrGetCollections(db *gorm.DB, collectionType model.CollectionType, names []string) ([]model.Collection, error) {
+	var exists []model.Collection
+	if err := db.Model(&exists).Where("type = ? AND name IN ?", collectionType, names).Find(&exists).Error; err != nil {
+		return nil, err
+	}
+
+out:
+	for _, name := range names {
+		for _, exist := range exists {
+			if exist.Name == name {
+				continue out
+			}
+		}
+
+		var collection = model.Collection{Type: collectionType, Name: name}
+
+		if err := db.Model(&collection).Create(&collection).Error; err != nil {
+			return nil, err
+		}
+
+		exists = append(exists, collection)
+	}
+
+	return exists, nil
+}
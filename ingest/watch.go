@@ -0,0 +1,155 @@
+package ingest
+
+import (
+	"fmt"
+	"gorm.io/gorm"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const QuarantineErrorSuffix = ".error.txt"
+
+type inboxFile struct {
+	size    int64
+	modTime time.Time
+}
+
+// WatchInbox polls inbox every interval, and imports files whose size and modification time
+// stayed the same since the last poll, so files still being copied are left alone.
+// Imported and duplicated files are removed from inbox, since their content is in the static folder already,
+// others are moved to quarantine with a sidecar file named after QuarantineErrorSuffix describing the reason.
+func WatchInbox(db *gorm.DB, inbox, quarantine string, interval time.Duration, importTags bool) {
+	l.Info().Printf("Watching inbox %s every %s", inbox, interval)
+
+	seen := make(map[string]inboxFile)
+
+	for {
+		current := make(map[string]inboxFile)
+
+		err := filepath.WalkDir(inbox, func(fullpath string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				if fullpath == inbox {
+					return err
+				}
+				// leave it for the next poll, the rest of inbox goes on
+				l.Warn().Printf("Failed to read %s: %v", fullpath, err)
+				if entry != nil && entry.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+
+			if strings.HasPrefix(entry.Name(), ".") && fullpath != inbox {
+				if entry.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			} else if entry.IsDir() {
+				// quarantine may be a sub folder of inbox
+				if filepath.Clean(fullpath) == filepath.Clean(quarantine) {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+
+			info, err := entry.Info()
+			if err != nil {
+				l.Warn().Printf("Failed to stat %s: %v", fullpath, err)
+				return nil
+			}
+
+			file := inboxFile{size: info.Size(), modTime: info.ModTime()}
+			if last, ok := seen[fullpath]; !ok || last != file {
+				current[fullpath] = file
+				return nil
+			}
+
+			record := ImportFile(db, fullpath, importTags)
+
+			switch record.Status {
+			case Imported, Duplicated:
+				l.Info().Printf("[%s] %s -> song %d", record.Status, fullpath, record.SongID)
+				err = os.Remove(fullpath)
+			default:
+				reason := record.Error
+				if record.Status == Skipped {
+					reason = "not an audio file"
+				}
+				l.Warn().Printf("[%s] %s: %s", record.Status, fullpath, reason)
+				err = moveToQuarantine(inbox, quarantine, fullpath, reason)
+			}
+			if err != nil {
+				l.Error().Printf("Failed to clean up %s: %v", fullpath, err)
+			}
+
+			return nil
+		})
+		if err != nil {
+			l.Error().Printf("Failed to walk inbox %s: %v", inbox, err)
+		}
+
+		seen = current
+
+		time.Sleep(interval)
+	}
+}
+
+func moveToQuarantine(inbox, quarantine, fullpath, reason string) error {
+	rel, err := filepath.Rel(inbox, fullpath)
+	if err != nil {
+		return err
+	}
+
+	target := filepath.Join(quarantine, rel)
+	if _, err := os.Stat(target); err == nil {
+		ext := filepath.Ext(target)
+		target = fmt.Sprintf("%s.%d%s", strings.TrimSuffix(target, ext), time.Now().Unix(), ext)
+	}
+
+	err = os.MkdirAll(filepath.Dir(target), 0755)
+	if err != nil {
+		return err
+	}
+
+	err = moveFile(fullpath, target)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(target+QuarantineErrorSuffix, []byte(reason+"\n"), 0644)
+}
+
+// moveFile falls back to copying when inbox and quarantine are on different devices
+func moveFile(src, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+
+	from, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = from.Close()
+	}()
+
+	to, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(to, from)
+	if closeErr := to.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(dst)
+		return err
+	}
+
+	return os.Remove(src)
+}
@@ -0,0 +1,192 @@
+package lyrics
+
+import (
+	"bytes"
+	"errors"
+	"path"
+	"slices"
+	"strings"
+)
+
+type Format string
+
+const (
+	FormatText Format = "txt"
+	FormatLRC  Format = "lrc"
+	FormatSRT  Format = "srt"
+	FormatVTT  Format = "vtt"
+	FormatTTML Format = "ttml"
+)
+
+// Formats are the formats lyrics can be written as
+var Formats = []Format{FormatLRC, FormatSRT, FormatVTT, FormatTTML}
+
+// LastCueDuration is how long the last line lasts in seconds when it is written as a cue,
+// unless it is followed by an empty line marking its end
+const LastCueDuration = 5.0
+
+var (
+	ErrorUnknownFormat = errors.New("unknown lyrics format")
+	ErrorNotSynced     = errors.New("lyrics are not synced")
+)
+
+// ContentType returns the MIME type of the format
+func (f Format) ContentType() string {
+	switch f {
+	case FormatSRT:
+		return "application/x-subrip; charset=utf-8"
+	case FormatVTT:
+		return "text/vtt; charset=utf-8"
+	case FormatTTML:
+		return "application/ttml+xml; charset=utf-8"
+	}
+	return "text/plain; charset=utf-8"
+}
+
+// DetectFormat tells the format by the extension of filename, or by the content if the extension is unknown
+func DetectFormat(filename string, content []byte) Format {
+	switch strings.ToLower(path.Ext(filename)) {
+	case ".lrc":
+		return FormatLRC
+	case ".srt":
+		return FormatSRT
+	case ".vtt":
+		return FormatVTT
+	case ".ttml", ".dfxp":
+		return FormatTTML
+	}
+
+	head := bytes.TrimSpace(trimBOM(content))
+	switch {
+	case bytes.HasPrefix(head, []byte("WEBVTT")):
+		return FormatVTT
+	case bytes.HasPrefix(head, []byte("<?xml")) || bytes.HasPrefix(head, []byte("<tt")):
+		return FormatTTML
+	case IsLRC(string(head)):
+		return FormatLRC
+	case srtTimingPattern.Match(head):
+		return FormatSRT
+	}
+	return FormatText
+}
+
+// Read parses content of the format into lines, the returned bool tells whether the lines are synced
+func Read(format Format, content []byte) ([]Line, bool, error) {
+	content = trimBOM(content)
+	switch format {
+	case FormatText, FormatLRC:
+		return Parse(string(content))
+	case FormatSRT:
+		lines, err := ParseSRT(string(content))
+		return lines, true, err
+	case FormatVTT:
+		lines, err := ParseVTT(string(content))
+		return lines, true, err
+	case FormatTTML:
+		lines, err := ParseTTML(content)
+		return lines, true, err
+	}
+	return nil, false, ErrorUnknownFormat
+}
+
+// Write renders lines as the format, only LRC and text can be written for lyrics which are not synced
+func Write(format Format, title string, lines []Line, synced bool) ([]byte, error) {
+	if format == FormatText || (format == FormatLRC && !synced) {
+		texts := make([]string, 0, len(lines))
+		for _, line := range lines {
+			// empty lines of synced lyrics only mark ends of lines
+			if !synced || line.Text != "" {
+				texts = append(texts, line.Text)
+			}
+		}
+		return []byte(strings.Join(texts, "\n") + "\n"), nil
+	} else if !synced {
+		return nil, ErrorNotSynced
+	}
+
+	switch format {
+	case FormatLRC:
+		return WriteLRC(title, lines), nil
+	case FormatSRT:
+		return WriteSRT(lines), nil
+	case FormatVTT:
+		return WriteVTT(lines), nil
+	case FormatTTML:
+		return WriteTTML(lines), nil
+	}
+	return nil, ErrorUnknownFormat
+}
+
+// cue is a line with an end time, as subtitle formats have
+type cue struct {
+	Start float64
+	End   float64
+	Text  string
+	Words []Word
+}
+
+// cuesOf ends every line at the next line with a later time, lines without text only mark the end of the previous line
+func cuesOf(lines []Line) []cue {
+	cues := make([]cue, 0, len(lines))
+	for i, line := range lines {
+		if line.Text == "" {
+			continue
+		}
+		end := line.Time + LastCueDuration
+		for _, next := range lines[i+1:] {
+			if next.Time > line.Time {
+				end = next.Time
+				break
+			}
+		}
+		cues = append(cues, cue{Start: line.Time, End: end, Text: line.Text, Words: line.Words})
+	}
+	return cues
+}
+
+// linesOf turns cues into lines, with an empty line where a cue ends before the next one starts
+func linesOf(cues []cue) []Line {
+	slices.SortStableFunc(cues, func(a, b cue) int {
+		switch {
+		case a.Start < b.Start:
+			return -1
+		case a.Start > b.Start:
+			return 1
+		}
+		return 0
+	})
+
+	lines := make([]Line, 0, len(cues))
+	for i, c := range cues {
+		lines = append(lines, Line{Time: c.Start, Text: c.Text, Words: c.Words})
+		if c.End > c.Start && (i+1 == len(cues) || cues[i+1].Start > c.End) {
+			lines = append(lines, Line{Time: c.End})
+		}
+	}
+	return lines
+}
+
+// collapseSpace replaces every run of white spaces with a single space, including the leading and the trailing ones
+func collapseSpace(value string) string {
+	var collapsed strings.Builder
+	space := false
+	for _, r := range value {
+		if r == ' ' || r == '\t' || r == '\n' || r == '\r' {
+			space = true
+			continue
+		}
+		if space {
+			collapsed.WriteByte(' ')
+			space = false
+		}
+		collapsed.WriteRune(r)
+	}
+	if space {
+		collapsed.WriteByte(' ')
+	}
+	return collapsed.String()
+}
+
+func trimBOM(content []byte) []byte {
+	return bytes.TrimPrefix(content, []byte("\xef\xbb\xbf"))
+}
@@ -0,0 +1,61 @@
+package lyrics
+
+import "strings"
+
+// iso6392 maps ISO 639-2 codes of ID3 frames, both bibliographic and terminology ones, to ISO 639-1 codes
+var iso6392 = map[string]string{
+	"ara": "ar",
+	"chi": "zh",
+	"zho": "zh",
+	"cze": "cs",
+	"ces": "cs",
+	"dan": "da",
+	"dut": "nl",
+	"nld": "nl",
+	"eng": "en",
+	"fin": "fi",
+	"fre": "fr",
+	"fra": "fr",
+	"ger": "de",
+	"deu": "de",
+	"gre": "el",
+	"ell": "el",
+	"heb": "he",
+	"hin": "hi",
+	"hun": "hu",
+	"ind": "id",
+	"ita": "it",
+	"jpn": "ja",
+	"kor": "ko",
+	"may": "ms",
+	"msa": "ms",
+	"nor": "no",
+	"per": "fa",
+	"fas": "fa",
+	"pol": "pl",
+	"por": "pt",
+	"rum": "ro",
+	"ron": "ro",
+	"rus": "ru",
+	"spa": "es",
+	"swe": "sv",
+	"tha": "th",
+	"tur": "tr",
+	"ukr": "uk",
+	"vie": "vi",
+}
+
+// NormalizeLanguage turns an ISO 639-2 code into its ISO 639-1 code, like eng into en,
+// undetermined codes into empty, and returns other languages as is
+func NormalizeLanguage(language string) string {
+	language = strings.TrimSpace(language)
+	lower := strings.ToLower(language)
+	switch lower {
+	case "xxx", "und", "zxx", "mis":
+		return ""
+	}
+	if code, ok := iso6392[lower]; ok {
+		return code
+	}
+	return language
+}
@@ -0,0 +1,204 @@
+package lyrics
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+var ErrorInvalidTimestamp = errors.New("invalid timestamp")
+
+// LRC is parsed LRC content, Lines are sorted by time and the offset tag is already applied
+type LRC struct {
+	Tags  map[string]string `json:"tags"` // ID tags like ar, ti, al and offset, keys are lowercased
+	Lines []Line            `json:"lines"`
+}
+
+var (
+	lrcLinePattern      = regexp.MustCompile(`(?m)^\s*\[\d+:\d`)
+	lrcTimestampPattern = regexp.MustCompile(`^(\d+):(\d{1,2})(?:[.:](\d{1,3}))?$`)
+	lrcWordPattern      = regexp.MustCompile(`<([^<>]*)>`)
+)
+
+// IsLRC tells whether content has any line starting with a timestamp like [00:12.34]
+func IsLRC(content string) bool {
+	return lrcLinePattern.MatchString(content)
+}
+
+// ParseLRC reads LRC and enhanced LRC with word timestamps like <00:12.34>,
+// a line may start with more than one timestamp, lines without timestamps are ignored
+func ParseLRC(content string) (*LRC, error) {
+	lrc := &LRC{Tags: map[string]string{}, Lines: []Line{}}
+
+	for i, raw := range strings.Split(content, "\n") {
+		rest := strings.TrimSpace(raw)
+
+		var times []float64
+		for strings.HasPrefix(rest, "[") {
+			end := strings.Index(rest, "]")
+			if end == -1 {
+				break
+			}
+			tag := strings.TrimSpace(rest[1:end])
+
+			if tag != "" && unicode.IsDigit(rune(tag[0])) {
+				time, err := parseLRCTimestamp(tag)
+				if err != nil {
+					return nil, fmt.Errorf("line %d: %w: [%s]", i+1, err, tag)
+				}
+				times = append(times, time)
+			} else if key, value, ok := strings.Cut(tag, ":"); ok && len(times) == 0 && isLRCTagKey(key) {
+				lrc.Tags[strings.ToLower(key)] = strings.TrimSpace(value)
+			} else {
+				// like [Chorus], which is a part of the text
+				break
+			}
+
+			rest = strings.TrimSpace(rest[end+1:])
+		}
+
+		if len(times) == 0 {
+			continue
+		}
+
+		text, words, err := parseLRCWords(rest)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+
+		for _, time := range times {
+			line := Line{Time: time, Text: text}
+			if len(words) > 0 {
+				line.Words = words
+			}
+			lrc.Lines = append(lrc.Lines, line)
+		}
+	}
+
+	if offset, err := strconv.ParseFloat(strings.TrimPrefix(lrc.Tags["offset"], "+"), 64); err == nil && offset != 0 {
+		// a positive offset in milliseconds shows lyrics earlier
+		shift := func(time float64) float64 {
+			return math.Max(0, time-offset/1000)
+		}
+		for i := range lrc.Lines {
+			lrc.Lines[i].Time = shift(lrc.Lines[i].Time)
+			if lrc.Lines[i].Words != nil {
+				words := slices.Clone(lrc.Lines[i].Words)
+				for j := range words {
+					words[j].Time = shift(words[j].Time)
+				}
+				lrc.Lines[i].Words = words
+			}
+		}
+	}
+
+	slices.SortStableFunc(lrc.Lines, func(a, b Line) int {
+		switch {
+		case a.Time < b.Time:
+			return -1
+		case a.Time > b.Time:
+			return 1
+		}
+		return 0
+	})
+
+	return lrc, nil
+}
+
+// parseLRCTimestamp reads mm:ss, mm:ss.xx, mm:ss.xxx and mm:ss:xx into seconds
+func parseLRCTimestamp(value string) (float64, error) {
+	match := lrcTimestampPattern.FindStringSubmatch(value)
+	if match == nil {
+		return 0, ErrorInvalidTimestamp
+	}
+
+	minutes, _ := strconv.Atoi(match[1])
+	seconds, _ := strconv.Atoi(match[2])
+	if seconds >= 60 {
+		return 0, ErrorInvalidTimestamp
+	}
+
+	time := float64(minutes*60 + seconds)
+	if match[3] != "" {
+		fraction, _ := strconv.Atoi(match[3])
+		time += float64(fraction) / math.Pow10(len(match[3]))
+	}
+	return time, nil
+}
+
+// parseLRCWords splits text with word timestamps into words, words are nil if there is no word timestamp
+func parseLRCWords(text string) (string, []Word, error) {
+	matches := lrcWordPattern.FindAllStringSubmatchIndex(text, -1)
+	if len(matches) == 0 {
+		return text, nil, nil
+	}
+
+	var (
+		plain strings.Builder
+		words []Word
+	)
+	plain.WriteString(text[:matches[0][0]])
+	for i, match := range matches {
+		time, err := parseLRCTimestamp(strings.TrimSpace(text[match[2]:match[3]]))
+		if err != nil {
+			return "", nil, fmt.Errorf("%w: %s", err, text[match[0]:match[1]])
+		}
+
+		end := len(text)
+		if i+1 < len(matches) {
+			end = matches[i+1][0]
+		}
+		word := text[match[1]:end]
+		plain.WriteString(word)
+
+		// the last timestamp may only mark the end of the line
+		if strings.TrimSpace(word) != "" {
+			words = append(words, Word{Time: time, Text: word})
+		}
+	}
+
+	return strings.TrimSpace(plain.String()), words, nil
+}
+
+// isLRCTagKey tells whether key is like ar, ti or offset of an ID tag
+func isLRCTagKey(key string) bool {
+	if key == "" {
+		return false
+	}
+	for _, r := range key {
+		if !unicode.IsLetter(r) && r != '#' {
+			return false
+		}
+	}
+	return true
+}
+
+// WriteLRC renders lines as LRC, lines with words as enhanced LRC, and the title as the ti tag if it is not empty
+func WriteLRC(title string, lines []Line) []byte {
+	var lrc strings.Builder
+	if title = strings.TrimSpace(title); title != "" {
+		lrc.WriteString("[ti:" + strings.ReplaceAll(title, "]", "") + "]\n")
+	}
+	for _, line := range lines {
+		lrc.WriteString("[" + formatLRCTimestamp(line.Time) + "]")
+		if len(line.Words) == 0 {
+			lrc.WriteString(line.Text)
+		}
+		for _, word := range line.Words {
+			lrc.WriteString("<" + formatLRCTimestamp(word.Time) + ">" + word.Text)
+		}
+		lrc.WriteString("\n")
+	}
+	return []byte(lrc.String())
+}
+
+// formatLRCTimestamp writes seconds as mm:ss.xx
+func formatLRCTimestamp(time float64) string {
+	centiseconds := int64(math.Round(max(time, 0) * 100))
+	return fmt.Sprintf("%02d:%02d.%02d", centiseconds/6000, centiseconds/100%60, centiseconds%100)
+}
@@ -0,0 +1,65 @@
+package lyrics
+
+import (
+	"sort"
+	"strings"
+)
+
+// Word is a word of an enhanced LRC line, with the time it starts at
+type Word struct {
+	Time float64 `json:"time"` // in seconds
+	Text string  `json:"text"`
+}
+
+// Line is a line of lyrics with the time it starts at, Time is 0 for every line of plain lyrics
+type Line struct {
+	Time  float64 `json:"time"` // in seconds
+	Text  string  `json:"text"`
+	Words []Word  `json:"words,omitempty"`
+}
+
+// Parse reads content as LRC if it is, or as plain lyrics of one line per line.
+// The returned bool tells whether the lines are synced.
+func Parse(content string) ([]Line, bool, error) {
+	if IsLRC(content) {
+		lrc, err := ParseLRC(content)
+		if err != nil {
+			return nil, false, err
+		}
+		return lrc.Lines, true, nil
+	}
+	return PlainLines(content), false, nil
+}
+
+// PlainLines splits content into lines as is, without looking for timestamps
+func PlainLines(content string) []Line {
+	lines := make([]Line, 0)
+	for _, text := range strings.Split(strings.TrimSpace(content), "\n") {
+		lines = append(lines, Line{Text: strings.TrimSpace(text)})
+	}
+	return lines
+}
+
+// LineAt returns the index of the line active at the playback offset at in seconds,
+// which is the last line starting at or before it, or -1 if at is before the first line
+func LineAt(lines []Line, at float64) int {
+	return sort.Search(len(lines), func(i int) bool {
+		return lines[i].Time > at
+	}) - 1
+}
+
+// PlainText returns content without timestamps and tags if it is LRC, otherwise content as is
+func PlainText(content string) string {
+	if !IsLRC(content) {
+		return content
+	}
+	lrc, err := ParseLRC(content)
+	if err != nil {
+		return content
+	}
+	texts := make([]string, len(lrc.Lines))
+	for i, line := range lrc.Lines {
+		texts[i] = line.Text
+	}
+	return strings.Join(texts, "\n")
+}
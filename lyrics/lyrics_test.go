@@ -0,0 +1,157 @@
+package lyrics
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParseLRC(t *testing.T) {
+	content := "[ar:ABBA]\n" +
+		"[ti:Waterloo]\n" +
+		"[offset:+500]\n" +
+		"[00:10.50]My, my\r\n" +
+		"[01:30.00][00:20.5]Waterloo\n" +
+		"[00:30:25]<00:30.25>I <00:31.00>was <00:31.500>defeated <00:33.00>\n"
+
+	lrc, err := ParseLRC(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if lrc.Tags["ar"] != "ABBA" || lrc.Tags["ti"] != "Waterloo" {
+		t.Fatal("unexpected tags", lrc.Tags)
+	}
+
+	expected := []Line{
+		{Time: 10, Text: "My, my"},
+		{Time: 20, Text: "Waterloo"},
+		{Time: 29.75, Text: "I was defeated"},
+		{Time: 89.5, Text: "Waterloo"},
+	}
+	if len(lrc.Lines) != len(expected) {
+		t.Fatal("unexpected lines", lrc.Lines)
+	}
+	for i, line := range expected {
+		if lrc.Lines[i].Time != line.Time || lrc.Lines[i].Text != line.Text {
+			t.Fatal("unexpected line", i, lrc.Lines[i])
+		}
+	}
+
+	words := lrc.Lines[2].Words
+	if len(words) != 3 || words[0].Time != 29.75 || words[2].Time != 31 || words[2].Text != "defeated " {
+		t.Fatal("unexpected words", words)
+	}
+}
+
+func TestParseInvalidTimestamp(t *testing.T) {
+	for _, content := range []string{
+		"[00:10.00]fine\n[00:61.00]seconds overflow",
+		"[00:10.00]fine\n[0a:10]not a number",
+		"[00:10.00]<00:1x>bad word",
+	} {
+		if _, _, err := Parse(content); !errors.Is(err, ErrorInvalidTimestamp) {
+			t.Fatal("expected invalid timestamp for", content, err)
+		}
+	}
+
+	lines, synced, err := Parse("[Chorus]\nMy, my\n")
+	if err != nil || synced || len(lines) != 2 {
+		t.Fatal("unexpected plain lyrics", lines, synced, err)
+	}
+}
+
+func TestLineAt(t *testing.T) {
+	lines := []Line{{Time: 10}, {Time: 20}, {Time: 20}, {Time: 30}}
+
+	for at, expected := range map[float64]int{0: -1, 10: 0, 19.9: 0, 20: 2, 100: 3} {
+		if index := LineAt(lines, at); index != expected {
+			t.Fatal("unexpected index at", at, index)
+		}
+	}
+}
+
+func TestConvert(t *testing.T) {
+	lines, synced, err := Parse("[00:10.00]My, my\n[00:20.00]<00:20.00>At <00:21.50>Water<00:22.00>loo\n[00:25.00]\n")
+	if err != nil || !synced {
+		t.Fatal("unexpected lrc", synced, err)
+	}
+
+	for _, format := range Formats {
+		content, err := Write(format, "Waterloo", lines, synced)
+		if err != nil {
+			t.Fatal(format, err)
+		}
+		if detected := DetectFormat("", content); detected != format {
+			t.Fatal("detected", detected, "for", format, string(content))
+		}
+
+		result, synced, err := Read(format, content)
+		if err != nil || !synced {
+			t.Fatal(format, synced, err, string(content))
+		}
+		if len(result) != len(lines) {
+			t.Fatal("unexpected lines of", format, result)
+		}
+		for i, line := range lines {
+			if result[i].Time != line.Time || result[i].Text != line.Text {
+				t.Fatal("unexpected line of", format, result[i], string(content))
+			}
+		}
+		// SubRip has no word timestamps
+		if format == FormatSRT {
+			continue
+		}
+		if words := result[1].Words; words[1].Time != 21.5 || words[1].Text != "Water" {
+			t.Fatal("unexpected words of", format, words)
+		}
+	}
+
+	srt := string(WriteSRT(lines))
+	if !strings.HasPrefix(srt, "1\n00:00:10,000 --> 00:00:20,000\nMy, my\n\n2\n00:00:20,000 --> 00:00:25,000\nAt Waterloo\n") {
+		t.Fatal("unexpected srt", srt)
+	}
+
+	if _, err := Write(FormatVTT, "", PlainLines("My, my"), false); !errors.Is(err, ErrorNotSynced) {
+		t.Fatal("expected not synced", err)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	original, _, _ := Parse("[00:10.00]Hello\n[00:20.00]World\n[00:25.00]\n[00:30.00]Again\n")
+	translation, _, _ := Parse("[00:10.10]你好\n[00:30.00]再次\n")
+	romanization := PlainLines("Konnichiwa\nSekai")
+
+	merged := Merge(original, translation, romanization)
+	expected := [][]string{{"你好", "Konnichiwa"}, {"", "Sekai"}, {"", ""}, {"再次", ""}}
+	if len(merged) != len(expected) {
+		t.Fatal("unexpected lines", merged)
+	}
+	for i, translations := range expected {
+		if merged[i].Translations[0] != translations[0] || merged[i].Translations[1] != translations[1] {
+			t.Fatal("unexpected translations of line", i, merged[i])
+		}
+	}
+
+	if lines := Flatten(merged); len(lines) != 8 || lines[1].Time != 10 || lines[1].Text != "你好" {
+		t.Fatal("unexpected flattened lines", lines)
+	}
+}
+
+func TestNormalizeLanguage(t *testing.T) {
+	for language, expected := range map[string]string{
+		"eng":     "en",
+		"JPN":     "ja",
+		"chi":     "zh",
+		"zho":     "zh",
+		"xxx":     "",
+		"und":     "",
+		"en":      "en",
+		"zh-Hans": "zh-Hans",
+		"":        "",
+	} {
+		if actual := NormalizeLanguage(language); actual != expected {
+			t.Fatal("unexpected language of", language, actual)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package lyrics
+
+import (
+	"math"
+	"sort"
+)
+
+// MergeTolerance is how far in seconds a line of a translation may start from the original line it belongs to
+const MergeTolerance = 0.5
+
+// MergedLine is a line of the original lyrics with the texts of its translations
+type MergedLine struct {
+	Line
+	Translations []string `json:"translations"` // in the order of the translations, empty if a translation has no such line
+}
+
+// Merge pairs lines of every translation with lines of original by time,
+// or by the order of lines with text if either of them is not synced, which is when all lines start at 0
+func Merge(original []Line, translations ...[]Line) []MergedLine {
+	merged := make([]MergedLine, len(original))
+	for i, line := range original {
+		merged[i] = MergedLine{Line: line, Translations: make([]string, len(translations))}
+	}
+
+	for t, translation := range translations {
+		var texted []Line
+		for _, line := range translation {
+			if line.Text != "" {
+				texted = append(texted, line)
+			}
+		}
+
+		if !isSynced(original) || !isSynced(translation) {
+			k := 0
+			for i := range merged {
+				if merged[i].Text != "" && k < len(texted) {
+					merged[i].Translations[t] = texted[k].Text
+					k++
+				}
+			}
+			continue
+		}
+
+		used := make([]bool, len(texted))
+		for i := range merged {
+			if merged[i].Text == "" {
+				continue
+			}
+			if j := nearestLine(texted, merged[i].Time); j != -1 && !used[j] {
+				merged[i].Translations[t] = texted[j].Text
+				used[j] = true
+			}
+		}
+	}
+
+	return merged
+}
+
+// Flatten puts every translation after its original line with the same time, like bilingual LRC files do
+func Flatten(merged []MergedLine) []Line {
+	lines := make([]Line, 0, len(merged))
+	for _, line := range merged {
+		lines = append(lines, line.Line)
+		for _, text := range line.Translations {
+			if text != "" {
+				lines = append(lines, Line{Time: line.Time, Text: text})
+			}
+		}
+	}
+	return lines
+}
+
+// nearestLine returns the index of the line starting nearest to time within MergeTolerance, or -1
+func nearestLine(lines []Line, time float64) int {
+	index := sort.Search(len(lines), func(i int) bool {
+		return lines[i].Time >= time
+	})
+
+	nearest := -1
+	for _, j := range []int{index - 1, index} {
+		if j < 0 || j >= len(lines) {
+			continue
+		}
+		if distance := math.Abs(lines[j].Time - time); distance <= MergeTolerance &&
+			(nearest == -1 || distance < math.Abs(lines[nearest].Time-time)) {
+			nearest = j
+		}
+	}
+	return nearest
+}
+
+func isSynced(lines []Line) bool {
+	for _, line := range lines {
+		if line.Time > 0 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,358 @@
+package lyrics
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"io"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	srtTimingPattern    = regexp.MustCompile(`(?m)^\s*\d{1,2}:\d{2}:\d{2}[,.]\d{1,3}\s*-->`)
+	subtitleTagPattern  = regexp.MustCompile(`<[^<>]*>|\{\\[^{}]*}`)
+	vttTimestampPattern = regexp.MustCompile(`<(\d[\d:.]*)>`)
+)
+
+// ParseSRT reads SubRip cues, formatting tags like <i> and {\an8} are removed
+func ParseSRT(content string) ([]Line, error) {
+	return parseCues(content, func(text string, _ float64) (string, []Word, error) {
+		return strings.TrimSpace(html.UnescapeString(subtitleTagPattern.ReplaceAllString(text, ""))), nil, nil
+	})
+}
+
+// ParseVTT reads WebVTT cues, timestamps like <00:12.340> in a cue become words
+func ParseVTT(content string) ([]Line, error) {
+	return parseCues(content, parseVTTWords)
+}
+
+// parseCues reads blocks of SRT and WebVTT, blocks without a timing line like headers and notes are skipped,
+// multiple lines of a cue are joined with a space
+func parseCues(content string, parseText func(text string, start float64) (string, []Word, error)) ([]Line, error) {
+	var cues []cue
+
+	rows := strings.Split(strings.ReplaceAll(content, "\r\n", "\n"), "\n")
+	for i := 0; i < len(rows); i++ {
+		timing := rows[i]
+		if !strings.Contains(timing, "-->") {
+			continue
+		}
+
+		startValue, endValue, _ := strings.Cut(timing, "-->")
+		start, err := parseClock(startValue)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w: %s", i+1, err, strings.TrimSpace(startValue))
+		}
+		// WebVTT may have cue settings like "align:start" after the end time
+		endFields := strings.Fields(endValue)
+		if len(endFields) == 0 {
+			return nil, fmt.Errorf("line %d: %w: %s", i+1, ErrorInvalidTimestamp, timing)
+		}
+		end, err := parseClock(endFields[0])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w: %s", i+1, err, endFields[0])
+		}
+
+		var texts []string
+		for i+1 < len(rows) && strings.TrimSpace(rows[i+1]) != "" {
+			i++
+			texts = append(texts, strings.TrimSpace(rows[i]))
+		}
+
+		text, words, err := parseText(strings.Join(texts, " "), start)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+		if text != "" {
+			cues = append(cues, cue{Start: start, End: end, Text: text, Words: words})
+		}
+	}
+
+	return linesOf(cues), nil
+}
+
+// parseVTTWords splits text with timestamps into words, the first word starts with the cue
+func parseVTTWords(text string, start float64) (string, []Word, error) {
+	matches := vttTimestampPattern.FindAllStringSubmatchIndex(text, -1)
+	plain := func(value string) string {
+		return html.UnescapeString(subtitleTagPattern.ReplaceAllString(value, ""))
+	}
+	if len(matches) == 0 {
+		return strings.TrimSpace(plain(text)), nil, nil
+	}
+
+	var words []Word
+	if word := plain(text[:matches[0][0]]); strings.TrimSpace(word) != "" {
+		words = append(words, Word{Time: start, Text: word})
+	}
+	for i, match := range matches {
+		time, err := parseClock(text[match[2]:match[3]])
+		if err != nil {
+			return "", nil, fmt.Errorf("%w: %s", err, text[match[0]:match[1]])
+		}
+		end := len(text)
+		if i+1 < len(matches) {
+			end = matches[i+1][0]
+		}
+		if word := plain(text[match[1]:end]); strings.TrimSpace(word) != "" {
+			words = append(words, Word{Time: time, Text: word})
+		}
+	}
+
+	var joined strings.Builder
+	for _, word := range words {
+		joined.WriteString(word.Text)
+	}
+	return strings.TrimSpace(joined.String()), words, nil
+}
+
+// WriteSRT renders lines as SubRip cues
+func WriteSRT(lines []Line) []byte {
+	var srt strings.Builder
+	for i, c := range cuesOf(lines) {
+		srt.WriteString(strconv.Itoa(i+1) + "\n")
+		srt.WriteString(formatClock(c.Start, ",") + " --> " + formatClock(c.End, ",") + "\n")
+		srt.WriteString(c.Text + "\n\n")
+	}
+	return []byte(srt.String())
+}
+
+// WriteVTT renders lines as WebVTT cues, words are written with timestamps for karaoke style highlighting
+func WriteVTT(lines []Line) []byte {
+	var vtt strings.Builder
+	vtt.WriteString("WEBVTT\n\n")
+	for _, c := range cuesOf(lines) {
+		vtt.WriteString(formatClock(c.Start, ".") + " --> " + formatClock(c.End, ".") + "\n")
+		if len(c.Words) == 0 {
+			vtt.WriteString(escapeVTT(c.Text))
+		}
+		for _, word := range c.Words {
+			// timestamps must be within the cue
+			if word.Time > c.Start && word.Time < c.End {
+				vtt.WriteString("<" + formatClock(word.Time, ".") + ">")
+			}
+			vtt.WriteString(escapeVTT(word.Text))
+		}
+		vtt.WriteString("\n\n")
+	}
+	return []byte(vtt.String())
+}
+
+func escapeVTT(text string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(text)
+}
+
+type ttmlDocument struct {
+	XMLName    xml.Name `xml:"http://www.w3.org/ns/ttml tt"`
+	Paragraphs []ttmlP  `xml:"body>div>p"`
+}
+
+type ttmlP struct {
+	Begin string     `xml:"begin,attr"`
+	End   string     `xml:"end,attr"`
+	Text  string     `xml:",chardata"`
+	Spans []ttmlSpan `xml:"span"`
+}
+
+type ttmlSpan struct {
+	Begin string `xml:"begin,attr"`
+	End   string `xml:"end,attr"`
+	Text  string `xml:",chardata"`
+}
+
+// WriteTTML renders lines as a Timed Text Markup Language document, words are written as timed spans
+func WriteTTML(lines []Line) []byte {
+	var document ttmlDocument
+	for _, c := range cuesOf(lines) {
+		p := ttmlP{Begin: formatClock(c.Start, "."), End: formatClock(c.End, ".")}
+		if len(c.Words) == 0 {
+			p.Text = c.Text
+		}
+		for i, word := range c.Words {
+			end := c.End
+			if i+1 < len(c.Words) {
+				end = c.Words[i+1].Time
+			}
+			p.Spans = append(p.Spans, ttmlSpan{Begin: formatClock(word.Time, "."), End: formatClock(end, "."), Text: word.Text})
+		}
+		document.Paragraphs = append(document.Paragraphs, p)
+	}
+
+	// not indented, as white spaces between spans would become a part of words
+	body, _ := xml.Marshal(document)
+	return append([]byte(xml.Header), append(body, '\n')...)
+}
+
+// ParseTTML reads p elements of a Timed Text Markup Language document, spans with begin times become words
+func ParseTTML(content []byte) ([]Line, error) {
+	var (
+		cues    []cue
+		current *cue
+		text    strings.Builder
+		word    = -1 // index of the word whose span is open
+	)
+
+	decoder := xml.NewDecoder(bytes.NewReader(content))
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		switch element := token.(type) {
+		case xml.StartElement:
+			switch {
+			case element.Name.Local == "p":
+				begin, end, err := ttmlTimes(element, 0)
+				if err != nil {
+					return nil, err
+				}
+				current = &cue{Start: begin, End: end}
+				text.Reset()
+			case element.Name.Local == "span" && current != nil:
+				if attr(element, "begin") != "" {
+					begin, _, err := ttmlTimes(element, current.Start)
+					if err != nil {
+						return nil, err
+					}
+					current.Words = append(current.Words, Word{Time: begin})
+					word = len(current.Words) - 1
+				}
+			case element.Name.Local == "br" && current != nil:
+				text.WriteString(" ")
+			}
+		case xml.CharData:
+			if current == nil {
+				continue
+			}
+			text.Write(element)
+			// spaces between spans belong to the word before them
+			if word >= 0 {
+				current.Words[word].Text += string(element)
+			} else if len(current.Words) > 0 {
+				current.Words[len(current.Words)-1].Text += string(element)
+			}
+		case xml.EndElement:
+			switch {
+			case element.Name.Local == "span":
+				word = -1
+			case element.Name.Local == "p" && current != nil:
+				current.Text = strings.TrimSpace(collapseSpace(text.String()))
+				words := current.Words[:0]
+				for _, w := range current.Words {
+					if w.Text = collapseSpace(w.Text); strings.TrimSpace(w.Text) != "" {
+						words = append(words, w)
+					}
+				}
+				if len(words) == 0 {
+					words = nil
+				}
+				current.Words = words
+				if current.Text != "" {
+					cues = append(cues, *current)
+				}
+				current = nil
+			}
+		}
+	}
+
+	return linesOf(cues), nil
+}
+
+// ttmlTimes reads begin and end (or dur) of an element, begin defaults to parent
+func ttmlTimes(element xml.StartElement, parent float64) (float64, float64, error) {
+	begin := parent
+	if value := attr(element, "begin"); value != "" {
+		var err error
+		if begin, err = parseTTMLTime(value); err != nil {
+			return 0, 0, fmt.Errorf("%w: %s", err, value)
+		}
+	}
+
+	end := begin
+	if value := attr(element, "end"); value != "" {
+		var err error
+		if end, err = parseTTMLTime(value); err != nil {
+			return 0, 0, fmt.Errorf("%w: %s", err, value)
+		}
+	} else if value := attr(element, "dur"); value != "" {
+		duration, err := parseTTMLTime(value)
+		if err != nil {
+			return 0, 0, fmt.Errorf("%w: %s", err, value)
+		}
+		end = begin + duration
+	}
+	return begin, end, nil
+}
+
+// parseTTMLTime reads clock times like 00:01:02.345 and offset times like 62.345s, 1m or 500ms
+func parseTTMLTime(value string) (float64, error) {
+	value = strings.TrimSpace(value)
+	for _, unit := range []struct {
+		suffix  string
+		seconds float64
+	}{{"ms", 0.001}, {"h", 3600}, {"m", 60}, {"s", 1}} {
+		if number, ok := strings.CutSuffix(value, unit.suffix); ok {
+			offset, err := strconv.ParseFloat(number, 64)
+			if err != nil || offset < 0 {
+				return 0, ErrorInvalidTimestamp
+			}
+			return offset * unit.seconds, nil
+		}
+	}
+
+	// hh:mm:ss:frames at the default 30 frames per second
+	if parts := strings.Split(value, ":"); len(parts) == 4 {
+		frames, err := strconv.Atoi(parts[3])
+		if err != nil || frames < 0 {
+			return 0, ErrorInvalidTimestamp
+		}
+		time, err := parseClock(strings.Join(parts[:3], ":"))
+		return time + float64(frames)/30, err
+	}
+	return parseClock(value)
+}
+
+// parseClock reads hh:mm:ss.mmm, mm:ss.mmm or ss.mmm, with a comma or a dot before milliseconds
+func parseClock(value string) (float64, error) {
+	parts := strings.Split(strings.ReplaceAll(strings.TrimSpace(value), ",", "."), ":")
+	if len(parts) > 3 {
+		return 0, ErrorInvalidTimestamp
+	}
+
+	var time float64
+	for i, part := range parts {
+		last := i == len(parts)-1
+		if part == "" || (!last && strings.Contains(part, ".")) {
+			return 0, ErrorInvalidTimestamp
+		}
+		number, err := strconv.ParseFloat(part, 64)
+		if err != nil || number < 0 || math.IsInf(number, 0) || (i > 0 && number >= 60) {
+			return 0, ErrorInvalidTimestamp
+		}
+		time = time*60 + number
+	}
+	return time, nil
+}
+
+// formatClock writes seconds as hh:mm:ss.mmm, separator is put before milliseconds
+func formatClock(time float64, separator string) string {
+	milliseconds := int64(math.Round(max(time, 0) * 1000))
+	return fmt.Sprintf("%02d:%02d:%02d%s%03d",
+		milliseconds/3600000, milliseconds/60000%60, milliseconds/1000%60, separator, milliseconds%1000)
+}
+
+func attr(element xml.StartElement, name string) string {
+	for _, a := range element.Attr {
+		if a.Name.Local == name {
+			return a.Value
+		}
+	}
+	return ""
+}
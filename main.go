@@ -5,9 +5,14 @@ import (
 	"github.com/allape/gocrud"
 	"github.com/allape/gogger"
 	"github.com/allape/homesong/asset"
+	"github.com/allape/homesong/auth"
 	"github.com/allape/homesong/controller"
+	"github.com/allape/homesong/dlna"
 	"github.com/allape/homesong/env"
+	"github.com/allape/homesong/ffmpeg"
+	"github.com/allape/homesong/ingest"
 	"github.com/allape/homesong/model"
+	"github.com/allape/homesong/search"
 	"github.com/gin-gonic/gin"
 	"gorm.io/driver/mysql"
 	"gorm.io/driver/sqlite"
@@ -16,6 +21,7 @@ import (
 	"net/http"
 	"os"
 	"path"
+	"strings"
 	"time"
 )
 
@@ -27,72 +33,126 @@ func main() {
 		l.Error().Fatalf("Failed to init logger: %v", err)
 	}
 
-	var db *gorm.DB
+	// each character is a separator, slashes are not split by default because of names like "AC/DC"
+	ffmpeg.ArtistSeparators = append(ffmpeg.ArtistSeparators, strings.Split(env.ExtraArtistSeparators, "")...)
 
-	gormConfig := &gorm.Config{
-		Logger: logger.New(gogger.New("db").Debug(), logger.Config{
-			SlowThreshold: 200 * time.Millisecond,
-			LogLevel:      logger.Info,
-			Colorful:      true,
-		}),
+	command := gocrud.Pick(os.Args, 1, "")
+
+	// keep commands quiet, SQL logs would bury the per-file output
+	db, searchMode := openDatabase(gocrud.Ternary(command == "", logger.Info, logger.Warn))
+
+	switch command {
+	case "":
+	case "import":
+		runImportCommand(db, os.Args[2:])
+		return
+	case "fingerprint":
+		runFingerprintCommand(db)
+		return
+	case "lyrics":
+		runLyricsCommand(db)
+		return
+	default:
+		l.Error().Fatalf("Unknown command: %s", command)
 	}
 
-	if env.Standalone {
-		l.Info().Println("Standalone mode, using SQLite")
+	engine := gin.Default()
 
-		err = os.MkdirAll(path.Dir(env.StandaloneDatabaseDSN), 0755)
+	if env.EnableCors {
+		engine.Use(gocrud.NewCors())
+	}
+
+	if env.EnableAuth {
+		err = auth.EnsureAdmin(db)
 		if err != nil {
-			l.Error().Fatalf("Failed to create database directory: %v", err)
+			l.Error().Fatalf("Failed to create admin user: %v", err)
 		}
+	}
 
-		db, err = gorm.Open(sqlite.Open(env.StandaloneDatabaseDSN), gormConfig)
-	} else {
-		l.Info().Println("Using MySQL")
-		db, err = gorm.Open(mysql.Open(env.DatabaseDSN), gormConfig)
+	apiGrp := engine.Group("/api")
+
+	err = controller.SetupAuthController(apiGrp.Group("/auth"), db)
+	if err != nil {
+		l.Error().Fatalf("Failed to setup auth controller: %v", err)
 	}
+
+	authedApiGrp := apiGrp.Group("", auth.Authenticate(db))
+
+	err = controller.SetupSongController(authedApiGrp.Group("/song", auth.AdminOnlyWrites()), db)
 	if err != nil {
-		l.Error().Fatalf("Failed to open database: %v", err)
+		l.Error().Fatalf("Failed to setup song controller: %v", err)
 	}
 
-	err = db.AutoMigrate(
-		&model.Song{},
-		&model.Collection{}, &model.CollectionSong{},
-		&model.Lyrics{}, &model.SongLyrics{},
-	)
+	err = controller.SetupCollectionController(authedApiGrp.Group("/collection"), db)
 	if err != nil {
-		l.Error().Fatalf("Failed to auto migrate database: %v", err)
+		l.Error().Fatalf("Failed to setup collection controller: %v", err)
 	}
 
-	engine := gin.Default()
+	err = controller.SetupLyricsController(authedApiGrp.Group("/lyrics", auth.AdminOnlyWrites()), db)
+	if err != nil {
+		l.Error().Fatalf("Failed to setup lyrics controller: %v", err)
+	}
 
-	if env.EnableCors {
-		engine.Use(gocrud.NewCors())
+	err = controller.SetupSearchController(authedApiGrp.Group("/search"), db, searchMode)
+	if err != nil {
+		l.Error().Fatalf("Failed to setup search controller: %v", err)
 	}
 
-	apiGrp := engine.Group("/api")
+	err = controller.SetupDuplicateController(authedApiGrp.Group("/duplicate", auth.AdminOnly()), db)
+	if err != nil {
+		l.Error().Fatalf("Failed to setup duplicate controller: %v", err)
+	}
 
-	err = controller.SetupSongController(apiGrp.Group("/song"), db)
+	err = controller.SetupPlayController(authedApiGrp.Group("/play"), db)
 	if err != nil {
-		l.Error().Fatalf("Failed to setup song controller: %v", err)
+		l.Error().Fatalf("Failed to setup play controller: %v", err)
 	}
 
-	err = controller.SetupCollectionController(apiGrp.Group("/collection"), db)
+	err = controller.SetupUserController(authedApiGrp.Group("/user", auth.AdminOnly()), db)
 	if err != nil {
-		l.Error().Fatalf("Failed to setup collection controller: %v", err)
+		l.Error().Fatalf("Failed to setup user controller: %v", err)
 	}
 
-	err = controller.SetupLyricsController(apiGrp.Group("/lyrics"), db)
+	err = controller.SetupApiTokenController(authedApiGrp.Group("/token"), db)
 	if err != nil {
-		l.Error().Fatalf("Failed to setup lyrics controller: %v", err)
+		l.Error().Fatalf("Failed to setup api token controller: %v", err)
+	}
+
+	err = controller.SetupSubsonicController(engine.Group("/rest"), db)
+	if err != nil {
+		l.Error().Fatalf("Failed to setup subsonic controller: %v", err)
+	}
+
+	var (
+		ssdpServer *dlna.SSDPServer
+		dlnaEngine *gin.Engine
+	)
+	if env.DLNAEnabled {
+		// renderers can not log in, so DLNA is never served on the listener that may be proxied to the internet
+		if env.DLNABindAddr == "" || env.DLNABindAddr == env.BindAddr {
+			l.Error().Fatalf("HOME_SONG_DLNA_BIND_ADDR must be a listener other than HOME_SONG_BIND_ADDR")
+		}
+		dlnaEngine = gin.Default()
+		dlnaGrp := dlnaEngine.Group("/dlna")
+		err = controller.SetupDLNAController(dlnaGrp, db)
+		if err != nil {
+			l.Error().Fatalf("Failed to setup dlna controller: %v", err)
+		}
+		ssdpServer = controller.NewSSDPServer(dlnaGrp.BasePath())
 	}
 
-	err = gocrud.NewHttpFileSystem(engine.Group("/static"), env.StaticFolder, &gocrud.HttpFileSystemConfig{
+	staticGrp := engine.Group("/static", auth.Authenticate(db), auth.AdminOnlyWrites())
+	auth.AcceptScopes(staticGrp, http.MethodPost, "/*filepath", model.TokenScopeUpload)
+	err = gocrud.NewHttpFileSystem(staticGrp, env.StaticFolder, &gocrud.HttpFileSystemConfig{
 		AllowOverwrite: false,
 		AllowUpload:    true,
 		EnableDigest:   true,
 	})
+	if err != nil {
+		l.Error().Fatalf("Failed to setup static file system: %v", err)
+	}
 
-	err = gocrud.NewSingleHTMLServe(engine.Group("/ui"), env.UIFolder, &gocrud.SingleHTMLServeConfig{
+	err = gocrud.NewSingleHTMLServe(engine.Group("/ui", auth.RedirectToLogin(db), auth.AdminOnlyWrites()), env.UIFolder, &gocrud.SingleHTMLServeConfig{
 		AllowReplace: true,
 	})
 	if err != nil {
@@ -105,6 +165,32 @@ func main() {
 	engine.GET("/favicon.ico", func(context *gin.Context) {
 		context.Data(http.StatusOK, asset.FaviconMIME, asset.Favicon)
 	})
+	engine.GET(auth.LoginPath, func(context *gin.Context) {
+		context.Data(http.StatusOK, "text/html; charset=utf-8", asset.LoginHTML)
+	})
+
+	if env.InboxFolder != "" {
+		go ingest.WatchInbox(db, env.InboxFolder, env.QuarantineFolder, time.Duration(env.InboxInterval)*time.Second, env.InboxImportTags)
+	}
+
+	if ssdpServer != nil {
+		go func() {
+			// multicast may be unavailable, like in a container without host network, which should not stop the rest
+			err := ssdpServer.Serve()
+			if err != nil {
+				l.Error().Printf("Failed to serve SSDP: %v", err)
+			}
+		}()
+	}
+
+	if dlnaEngine != nil {
+		go func() {
+			err := dlnaEngine.Run(env.DLNABindAddr)
+			if err != nil {
+				l.Error().Fatalf("Failed to start dlna http server: %v", err)
+			}
+		}()
+	}
 
 	go func() {
 		err := engine.Run(env.BindAddr)
@@ -114,4 +200,79 @@ func main() {
 	}()
 
 	gogger.New("ctrl-c").Info().Println("Exiting with", gocrud.Wait4CtrlC())
+
+	if ssdpServer != nil {
+		ssdpServer.Shutdown()
+	}
+}
+
+// openDatabase migrates the database, and sets up search indexes before anything writes to it,
+// triggers left by a build with FTS5 would fail every write of a build without it
+func openDatabase(logLevel logger.LogLevel) (*gorm.DB, search.Mode) {
+	var (
+		db  *gorm.DB
+		err error
+	)
+
+	gormConfig := &gorm.Config{
+		Logger: logger.New(gogger.New("db").Debug(), logger.Config{
+			SlowThreshold: 200 * time.Millisecond,
+			LogLevel:      logLevel,
+			Colorful:      true,
+		}),
+	}
+
+	if env.Standalone {
+		l.Info().Println("Standalone mode, using SQLite")
+
+		err = os.MkdirAll(path.Dir(env.StandaloneDatabaseDSN), 0755)
+		if err != nil {
+			l.Error().Fatalf("Failed to create database directory: %v", err)
+		}
+
+		db, err = gorm.Open(sqlite.Open(env.StandaloneDatabaseDSN), gormConfig)
+	} else {
+		l.Info().Println("Using MySQL")
+		db, err = gorm.Open(mysql.Open(env.DatabaseDSN), gormConfig)
+	}
+	if err != nil {
+		l.Error().Fatalf("Failed to open database: %v", err)
+	}
+
+	err = db.AutoMigrate(
+		&model.Song{}, &model.SongFingerprint{},
+		&model.Collection{}, &model.CollectionSong{}, &model.UnmatchedEntry{},
+		&model.Lyrics{}, &model.SongLyrics{},
+		&model.PlayEvent{},
+		&model.User{}, &model.Session{}, &model.ApiToken{},
+	)
+	if err != nil {
+		l.Error().Fatalf("Failed to auto migrate database: %v", err)
+	}
+
+	// before search.Setup, which restores triggers of the songs table recreated by dropping the column
+	err = ingest.MoveFingerprints(db)
+	if err != nil {
+		l.Error().Fatalf("Failed to move fingerprints: %v", err)
+	}
+
+	searchMode := search.Setup(db)
+	l.Info().Println("Search mode:", searchMode)
+
+	err = controller.BackfillSongAudioInfo(db)
+	if err != nil {
+		l.Error().Fatalf("Failed to backfill song audio info: %v", err)
+	}
+
+	err = controller.BackfillCollectionPositions(db)
+	if err != nil {
+		l.Error().Fatalf("Failed to backfill collection positions: %v", err)
+	}
+
+	err = controller.BackfillLyricsLanguages(db)
+	if err != nil {
+		l.Error().Fatalf("Failed to backfill lyrics languages: %v", err)
+	}
+
+	return db, searchMode
 }
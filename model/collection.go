@@ -11,8 +11,26 @@ const (
 	CollectionTypeArtist CollectionType = "artist"
 	CollectionTypeAlbum  CollectionType = "album"
 	CollectionTypeSong   CollectionType = "playlist"
+	// CollectionTypeFavorite is a private playlist per user, created on demand
+	CollectionTypeFavorite CollectionType = "favorite"
+	// CollectionTypeSmart is a playlist whose songs are found by its Rules on read
+	CollectionTypeSmart CollectionType = "smart"
 )
 
+type Visibility string
+
+const (
+	VisibilityPrivate Visibility = "private" // only the owner
+	VisibilityShared  Visibility = "shared"  // everyone can see and edit
+	VisibilityPublic  Visibility = "public"  // everyone can see, only the owner edits
+)
+
+var Visibilities = []Visibility{
+	VisibilityPrivate,
+	VisibilityShared,
+	VisibilityPublic,
+}
+
 type Role string
 
 const (
@@ -41,11 +59,53 @@ type Collection struct {
 	Keywords    string         `json:"keywords"`
 	Cover       string         `json:"cover"`
 	Index       int32          `json:"index" gorm:"default:0"`
+	OwnerID     gocrud.ID      `json:"ownerId" gorm:"default:0;index"` // 0 for collections owned by nobody, like artists and albums
+	Visibility  Visibility     `json:"visibility" gorm:"default:'shared'"`
+	Rules       string         `json:"rules"`       // url encoded song search conditions of smart playlists, like in_codec=flac&lte_duration=300
+	AlbumArtist string         `json:"albumArtist"` // album artists as tagged, which tell albums of the same name apart, like "Greatest Hits"
+}
+
+func (c *Collection) IsPlaylist() bool {
+	return c.Type == CollectionTypeSong || c.Type == CollectionTypeFavorite || c.Type == CollectionTypeSmart
+}
+
+func (c *Collection) VisibleTo(user *User) bool {
+	return user.IsAdmin() || c.OwnerID == 0 || c.OwnerID == user.ID || c.Visibility != VisibilityPrivate
+}
+
+// EditableBy tells whether user can change the name or songs of the collection
+func (c *Collection) EditableBy(user *User) bool {
+	if user.IsAdmin() || (c.OwnerID != 0 && c.OwnerID == user.ID) {
+		return true
+	}
+	// playlists created before owners existed are shared
+	return c.IsPlaylist() && (c.OwnerID == 0 || c.Visibility == VisibilityShared)
+}
+
+// ManageableBy tells whether user can delete the collection or change its visibility
+func (c *Collection) ManageableBy(user *User) bool {
+	return user.IsAdmin() || (c.OwnerID != 0 && c.OwnerID == user.ID)
 }
 
 type CollectionSong struct {
 	SongID       gocrud.ID `json:"songId"`
-	CollectionID gocrud.ID `json:"collectionId"`
+	CollectionID gocrud.ID `json:"collectionId" gorm:"index:idx_collection_song_position,priority:1"`
 	Role         Role      `json:"role" gorm:"default:'_'"`
+	Position     int32     `json:"position" gorm:"default:0;index:idx_collection_song_position,priority:2"` // 1 based order in the collection, roles of the same song share it
+	Disc         int32     `json:"disc" gorm:"default:0"`                                                   // disc number in an album, 0 for unknown
+	Track        int32     `json:"track" gorm:"default:0"`                                                  // track number on the disc, 0 for unknown
+	CreatedAt    time.Time `json:"createdAt" gorm:"autoCreateTime;<-:create"`
+}
+
+// UnmatchedEntry is an entry of an imported playlist file which matched no song, kept to be resolved later
+type UnmatchedEntry struct {
+	ID           gocrud.ID `json:"id" gorm:"primaryKey"`
+	CollectionID gocrud.ID `json:"collectionId" gorm:"index"`
+	Line         int       `json:"line"` // line number in the file, or track number for XSPF
+	Location     string    `json:"location"`
+	Title        string    `json:"title"`
+	Artist       string    `json:"artist"`
+	Album        string    `json:"album"`
+	Duration     float64   `json:"duration" gorm:"default:0"`
 	CreatedAt    time.Time `json:"createdAt" gorm:"autoCreateTime;<-:create"`
 }
@@ -4,10 +4,26 @@ import (
 	"github.com/allape/gocrud"
 )
 
+type LyricsKind string
+
+const (
+	LyricsKindOriginal     LyricsKind = "original"
+	LyricsKindTranslation  LyricsKind = "translation"
+	LyricsKindRomanization LyricsKind = "romanization" // like pinyin or romaji of the original
+)
+
+var LyricsKinds = []LyricsKind{
+	LyricsKindOriginal,
+	LyricsKindTranslation,
+	LyricsKindRomanization,
+}
+
 type Lyrics struct {
 	gocrud.Base
-	Name        string `json:"name"`
-	Index       int32  `json:"index" gorm:"default:0"`
-	Content     string `json:"content"`
-	Description string `json:"description"`
+	Name        string     `json:"name"`
+	Index       int32      `json:"index" gorm:"default:0"`
+	Content     string     `json:"content"`
+	Description string     `json:"description"`
+	Language    string     `json:"language"` // language code like en, zh-Hans or jpn, empty if unknown
+	Kind        LyricsKind `json:"kind" gorm:"default:'original'"`
 }
@@ -0,0 +1,28 @@
+package model
+
+import (
+	"github.com/allape/gocrud"
+	"time"
+)
+
+type PlaySource string
+
+const (
+	PlaySourceHotwire PlaySource = "hotwire"
+	PlaySourceStream  PlaySource = "stream"
+	PlaySourceHLS     PlaySource = "hls"
+	PlaySourceReport  PlaySource = "report"
+	PlaySourceDLNA    PlaySource = "dlna"
+)
+
+type PlayEvent struct {
+	ID        gocrud.ID  `json:"id" gorm:"primaryKey"`
+	SongID    gocrud.ID  `json:"songId" gorm:"index"`
+	UserID    gocrud.ID  `json:"userId" gorm:"index"` // 0 when auth is disabled
+	Source    PlaySource `json:"source"`
+	Client    string     `json:"client"`
+	Duration  float64    `json:"duration" gorm:"default:0"` // listened, in seconds
+	Completed bool       `json:"completed" gorm:"default:false"`
+	Skipped   bool       `json:"skipped" gorm:"default:false"`
+	CreatedAt time.Time  `json:"createdAt" gorm:"autoCreateTime;<-:create"`
+}
@@ -15,6 +15,25 @@ type Song struct {
 	FFProbeInfo string `json:"ffprobeInfo"`
 	Description string `json:"description"`
 	Index       int32  `json:"index" gorm:"default:0"`
+
+	// technical info extracted from FFProbeInfo
+	Duration   float64 `json:"duration" gorm:"default:0"` // in seconds
+	BitRate    int64   `json:"bitRate" gorm:"default:0"`  // in bits per second
+	SampleRate int32   `json:"sampleRate" gorm:"default:0"`
+	Channels   int32   `json:"channels" gorm:"default:0"`
+	Codec      string  `json:"codec"`
+	Container  string  `json:"container"`
+
+	// Fingerprint is computed by ingest.SaveFile and stored as SongFingerprint by ingest.SaveSong,
+	// it is empty for songs loaded from database
+	Fingerprint string `json:"-" gorm:"-"`
+}
+
+// SongFingerprint is kept apart from songs, so that the fingerprint of about 14KB is only loaded to compare songs
+type SongFingerprint struct {
+	SongID      gocrud.ID `json:"songId" gorm:"primaryKey;autoIncrement:false"`
+	Fingerprint string    `json:"fingerprint"` // see ffmpeg.AcousticFingerprint
+	UpdatedAt   time.Time `json:"updatedAt"`
 }
 
 type SongLyrics struct {
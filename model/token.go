@@ -0,0 +1,51 @@
+package model
+
+import (
+	"github.com/allape/gocrud"
+	"slices"
+	"strings"
+	"time"
+)
+
+type TokenScope string
+
+const (
+	TokenScopeRead   TokenScope = "read"   // every GET request
+	TokenScopeStream TokenScope = "stream" // audio endpoints and play reports only, for urls pasted into players
+	TokenScopeUpload TokenScope = "upload" // uploading and importing songs
+	TokenScopeAdmin  TokenScope = "admin"  // everything the owner can do
+)
+
+var TokenScopes = []TokenScope{
+	TokenScopeRead,
+	TokenScopeStream,
+	TokenScopeUpload,
+	TokenScopeAdmin,
+}
+
+// ApiToken is a long-lived token for scripts and headless players
+type ApiToken struct {
+	ID         gocrud.ID  `json:"id" gorm:"primaryKey"`
+	UserID     gocrud.ID  `json:"userId" gorm:"index"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix" gorm:"size:16"`        // the beginning of the token, to tell tokens apart
+	Digest     string     `json:"-" gorm:"uniqueIndex;size:64"` // sha256 of the token, the token itself is never stored
+	Scopes     string     `json:"scopes"`                       // comma separated TokenScope
+	ExpiresAt  *time.Time `json:"expiresAt"`                    // nil for never
+	LastUsedAt *time.Time `json:"lastUsedAt"`
+	CreatedAt  time.Time  `json:"createdAt" gorm:"autoCreateTime;<-:create"`
+}
+
+func (t *ApiToken) ScopeList() []TokenScope {
+	var scopes []TokenScope
+	for _, scope := range strings.Split(t.Scopes, ",") {
+		if scope = strings.TrimSpace(scope); scope != "" {
+			scopes = append(scopes, TokenScope(scope))
+		}
+	}
+	return scopes
+}
+
+func (t *ApiToken) HasScope(scope TokenScope) bool {
+	return slices.Contains(t.ScopeList(), scope)
+}
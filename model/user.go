@@ -0,0 +1,39 @@
+package model
+
+import (
+	"github.com/allape/gocrud"
+	"time"
+)
+
+type UserRole string
+
+const (
+	UserRoleAdmin    UserRole = "admin"
+	UserRoleListener UserRole = "listener"
+)
+
+var UserRoles = []UserRole{
+	UserRoleAdmin,
+	UserRoleListener,
+}
+
+type User struct {
+	gocrud.Base
+	Username string   `json:"username" gorm:"uniqueIndex;size:191"`
+	Password string   `json:"-"` // bcrypt hash
+	Role     UserRole `json:"role" gorm:"default:'listener'"`
+	// ExternalID is the OIDC or proxy identity bound to the user, like "https://auth.example.com#<sub>" or "proxy#alice"
+	ExternalID string `json:"externalId" gorm:"index;size:191"`
+}
+
+func (u *User) IsAdmin() bool {
+	return u.Role == UserRoleAdmin
+}
+
+type Session struct {
+	ID        gocrud.ID `json:"id" gorm:"primaryKey"`
+	UserID    gocrud.ID `json:"userId" gorm:"index"`
+	Digest    string    `json:"-" gorm:"uniqueIndex;size:64"` // sha256 of the token, the token itself is never stored
+	ExpiresAt time.Time `json:"expiresAt"`
+	CreatedAt time.Time `json:"createdAt" gorm:"autoCreateTime;<-:create"`
+}
@@ -0,0 +1,336 @@
+package playlist
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+type Format string
+
+const (
+	FormatM3U  Format = "m3u"
+	FormatXSPF Format = "xspf"
+	FormatCSV  Format = "csv"
+)
+
+var ErrorUnknownFormat = errors.New("unknown playlist format")
+
+// DetectFormat tells the format by the extension of filename, or by the content if the extension is unknown
+func DetectFormat(filename string, content []byte) (Format, error) {
+	switch strings.ToLower(path.Ext(filename)) {
+	case ".m3u", ".m3u8":
+		return FormatM3U, nil
+	case ".xspf":
+		return FormatXSPF, nil
+	case ".csv", ".tsv":
+		return FormatCSV, nil
+	}
+
+	head := bytes.TrimSpace(trimBOM(content))
+	switch {
+	case bytes.HasPrefix(head, []byte("#EXTM3U")):
+		return FormatM3U, nil
+	case bytes.HasPrefix(head, []byte("<?xml")) || bytes.HasPrefix(head, []byte("<playlist")):
+		return FormatXSPF, nil
+	}
+	return "", ErrorUnknownFormat
+}
+
+// Parse reads the name and entries of a playlist file, Entry.Line is set to where each entry starts
+func Parse(format Format, content []byte) (string, []Entry, error) {
+	content = trimBOM(content)
+	switch format {
+	case FormatM3U:
+		name, entries := ParseM3U(content)
+		return name, entries, nil
+	case FormatXSPF:
+		return ParseXSPF(content)
+	case FormatCSV:
+		entries, err := ParseCSV(content)
+		return "", entries, err
+	}
+	return "", nil, ErrorUnknownFormat
+}
+
+// ParseM3U reads plain and extended M3U, files which are not UTF-8 are read as Latin-1
+func ParseM3U(content []byte) (string, []Entry) {
+	text := string(content)
+	if !utf8.Valid(content) {
+		runes := make([]rune, len(content))
+		for i, b := range content {
+			runes[i] = rune(b)
+		}
+		text = string(runes)
+	}
+
+	var (
+		name    string
+		entries []Entry
+		current Entry
+	)
+	for i, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		if !strings.HasPrefix(line, "#") {
+			if current.Line == 0 {
+				current.Line = i + 1
+			}
+			current.Location = line
+			entries = append(entries, current)
+			current = Entry{}
+			continue
+		}
+
+		directive, value, _ := strings.Cut(line, ":")
+		directive = strings.ToUpper(directive)
+		if current.Line == 0 && (directive == "#EXTINF" || directive == "#EXTALB" || directive == "#EXTART") {
+			current.Line = i + 1
+		}
+
+		switch directive {
+		case "#PLAYLIST":
+			name = strings.TrimSpace(value)
+		case "#EXTINF":
+			// #EXTINF:231 tvg-id="x",Artist - Title
+			info, title, _ := strings.Cut(value, ",")
+			if fields := strings.Fields(info); len(fields) > 0 {
+				if duration, err := strconv.ParseFloat(fields[0], 64); err == nil && duration > 0 {
+					current.Duration = duration
+				}
+			}
+			current.Artist, current.Title = splitArtistTitle(strings.TrimSpace(title))
+		case "#EXTALB":
+			current.Album = strings.TrimSpace(value)
+		case "#EXTART":
+			current.Artist = strings.TrimSpace(value)
+		}
+	}
+	return name, entries
+}
+
+// ParseXSPF reads an XML Shareable Playlist Format playlist, Entry.Line is the track number
+func ParseXSPF(content []byte) (string, []Entry, error) {
+	var playlist xspfPlaylist
+	if err := xml.Unmarshal(content, &playlist); err != nil {
+		return "", nil, err
+	}
+
+	entries := make([]Entry, len(playlist.TrackList))
+	for i, track := range playlist.TrackList {
+		entries[i] = Entry{
+			Line:     i + 1,
+			Location: strings.TrimSpace(track.Location),
+			Title:    strings.TrimSpace(track.Title),
+			Artist:   strings.TrimSpace(track.Creator),
+			Album:    strings.TrimSpace(track.Album),
+			Duration: float64(track.Duration) / 1000,
+		}
+	}
+	return strings.TrimSpace(playlist.Title), entries, nil
+}
+
+type csvColumn string
+
+const (
+	csvTitle      csvColumn = "title"
+	csvArtist     csvColumn = "artist"
+	csvAlbum      csvColumn = "album"
+	csvLocation   csvColumn = "location"
+	csvDuration   csvColumn = "duration"
+	csvDurationMS csvColumn = "duration_ms"
+)
+
+// csvHeaders are lowercased header names used by common exporters
+var csvHeaders = map[string]csvColumn{
+	"title":           csvTitle,
+	"name":            csvTitle,
+	"track":           csvTitle,
+	"track name":      csvTitle,
+	"song":            csvTitle,
+	"artist":          csvArtist,
+	"artists":         csvArtist,
+	"artist name":     csvArtist,
+	"artist name(s)":  csvArtist,
+	"creator":         csvArtist,
+	"album":           csvAlbum,
+	"album name":      csvAlbum,
+	"location":        csvLocation,
+	"path":            csvLocation,
+	"file":            csvLocation,
+	"filename":        csvLocation,
+	"url":             csvLocation,
+	"duration":        csvDuration,
+	"length":          csvDuration,
+	"duration (ms)":   csvDurationMS,
+	"duration_ms":     csvDurationMS,
+	"track duration":  csvDurationMS,
+	"duration in ms":  csvDurationMS,
+	"duration (secs)": csvDuration,
+}
+
+// ParseCSV reads a comma, semicolon or tab separated file.
+// The first row is a header if it names a title or location column, otherwise columns are title, artist and album.
+func ParseCSV(content []byte) ([]Entry, error) {
+	firstLine, _, _ := bytes.Cut(content, []byte("\n"))
+	comma := ','
+	for _, delimiter := range []rune{';', '\t'} {
+		if bytes.Count(firstLine, []byte(string(delimiter))) > bytes.Count(firstLine, []byte(string(comma))) {
+			comma = delimiter
+		}
+	}
+
+	reader := csv.NewReader(bytes.NewReader(content))
+	reader.Comma = comma
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+
+	columns := []csvColumn{csvTitle, csvArtist, csvAlbum}
+
+	var entries []Entry
+	for row := 0; ; row++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		if row == 0 {
+			header := make([]csvColumn, len(record))
+			named := false
+			for i, field := range record {
+				header[i] = csvHeaders[strings.ToLower(strings.TrimSpace(field))]
+				named = named || header[i] == csvTitle || header[i] == csvLocation
+			}
+			if named {
+				columns = header
+				continue
+			}
+		}
+
+		line, _ := reader.FieldPos(0)
+		entry := Entry{Line: line}
+		for i, field := range record {
+			if i >= len(columns) {
+				break
+			}
+			field = strings.TrimSpace(field)
+			switch columns[i] {
+			case csvTitle:
+				entry.Title = field
+			case csvArtist:
+				entry.Artist = field
+			case csvAlbum:
+				entry.Album = field
+			case csvLocation:
+				entry.Location = field
+			case csvDuration:
+				entry.Duration = parseDuration(field)
+			case csvDurationMS:
+				if ms, err := strconv.ParseFloat(field, 64); err == nil && ms > 0 {
+					entry.Duration = ms / 1000
+				}
+			}
+		}
+		if entry.Title == "" && entry.Location == "" {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// parseDuration reads seconds like 231.4, or clock times like 3:51 and 1:02:03
+func parseDuration(value string) float64 {
+	var seconds float64
+	for _, part := range strings.Split(value, ":") {
+		number, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil || number < 0 {
+			return 0
+		}
+		seconds = seconds*60 + number
+	}
+	return seconds
+}
+
+// splitArtistTitle splits "Artist - Title", the whole value is the title if there is no separator
+func splitArtistTitle(value string) (string, string) {
+	if artist, title, ok := strings.Cut(value, " - "); ok && strings.TrimSpace(artist) != "" && strings.TrimSpace(title) != "" {
+		return strings.TrimSpace(artist), strings.TrimSpace(title)
+	}
+	return "", value
+}
+
+// BaseName returns the file name of the location without extension, for paths, file urls and http urls
+func (e Entry) BaseName() string {
+	location := strings.ReplaceAll(e.Location, "\\", "/")
+	if u, err := url.Parse(location); err == nil && u.Scheme != "" && len(u.Scheme) > 1 {
+		location = u.Path
+	}
+	name := path.Base(location)
+	if name == "." || name == "/" {
+		return ""
+	}
+	return strings.TrimSuffix(name, path.Ext(name))
+}
+
+var leadingTrackNumber = regexp.MustCompile(`^\d{1,3}(\s*[.\-_)]\s*|\s+)`)
+
+// Guess returns the artist and the title of the entry, missing ones are guessed from file names like "01 - Artist - Title.mp3"
+func (e Entry) Guess() (string, string) {
+	if e.Title != "" {
+		return e.Artist, e.Title
+	}
+	name := leadingTrackNumber.ReplaceAllString(e.BaseName(), "")
+	artist, title := splitArtistTitle(strings.ReplaceAll(name, "_", " "))
+	if e.Artist != "" {
+		artist = e.Artist
+	}
+	return artist, title
+}
+
+// Normalize lowercases value and removes everything but letters and digits, for comparing names loosely
+func Normalize(value string) string {
+	var normalized strings.Builder
+	for _, r := range strings.ToLower(value) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			normalized.WriteRune(r)
+		}
+	}
+	return normalized.String()
+}
+
+// Similar tells whether two normalized names are the same, or one contains the other like "dancingqueenremastered",
+// the shorter one must be at least 40% of the longer one to avoid matching short common words
+func Similar(a, b string) bool {
+	if a == "" || b == "" {
+		return false
+	} else if a == b {
+		return true
+	}
+	shorter, longer := utf8.RuneCountInString(a), utf8.RuneCountInString(b)
+	if shorter > longer {
+		shorter, longer = longer, shorter
+	}
+	if shorter < 3 || float64(shorter) < float64(longer)*0.4 {
+		return false
+	}
+	return strings.Contains(a, b) || strings.Contains(b, a)
+}
+
+func trimBOM(content []byte) []byte {
+	return bytes.TrimPrefix(content, []byte("\xef\xbb\xbf"))
+}
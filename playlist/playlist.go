@@ -0,0 +1,92 @@
+package playlist
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Entry is a track of a playlist file
+type Entry struct {
+	Line     int // where the entry starts in an imported file, 1 based
+	Location string
+	Title    string
+	Artist   string
+	Album    string
+	Duration float64 // in seconds, 0 for unknown
+}
+
+// WriteM3U8 writes an extended M3U playlist in UTF-8
+func WriteM3U8(name string, entries []Entry) []byte {
+	var body bytes.Buffer
+	body.WriteString("#EXTM3U\n")
+	if name != "" {
+		body.WriteString("#PLAYLIST:" + oneLine(name) + "\n")
+	}
+	for _, entry := range entries {
+		duration := -1
+		if entry.Duration > 0 {
+			duration = int(math.Round(entry.Duration))
+		}
+
+		title := oneLine(entry.Title)
+		if artist := oneLine(entry.Artist); artist != "" {
+			title = artist + " - " + title
+		}
+
+		_, _ = fmt.Fprintf(&body, "#EXTINF:%d,%s\n", duration, title)
+		if album := oneLine(entry.Album); album != "" {
+			body.WriteString("#EXTALB:" + album + "\n")
+		}
+		body.WriteString(oneLine(entry.Location) + "\n")
+	}
+	return body.Bytes()
+}
+
+type xspfTrack struct {
+	Location string `xml:"location"`
+	Title    string `xml:"title,omitempty"`
+	Creator  string `xml:"creator,omitempty"`
+	Album    string `xml:"album,omitempty"`
+	Duration int64  `xml:"duration,omitempty"` // in milliseconds
+}
+
+type xspfPlaylist struct {
+	XMLName   xml.Name    `xml:"http://xspf.org/ns/0/ playlist"`
+	Version   string      `xml:"version,attr"`
+	Title     string      `xml:"title,omitempty"`
+	TrackList []xspfTrack `xml:"trackList>track"`
+}
+
+// WriteXSPF writes an XML Shareable Playlist Format playlist
+func WriteXSPF(name string, entries []Entry) ([]byte, error) {
+	playlist := xspfPlaylist{
+		Version:   "1",
+		Title:     name,
+		TrackList: make([]xspfTrack, len(entries)),
+	}
+	for i, entry := range entries {
+		playlist.TrackList[i] = xspfTrack{
+			Location: entry.Location,
+			Title:    entry.Title,
+			Creator:  entry.Artist,
+			Album:    entry.Album,
+			Duration: int64(math.Round(entry.Duration * 1000)),
+		}
+	}
+
+	body, err := xml.MarshalIndent(playlist, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), body...), nil
+}
+
+var lineBreaks = strings.NewReplacer("\r\n", " ", "\r", " ", "\n", " ")
+
+// oneLine keeps line breaks in names from breaking line based formats
+func oneLine(value string) string {
+	return strings.TrimSpace(lineBreaks.Replace(value))
+}
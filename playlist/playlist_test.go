@@ -0,0 +1,106 @@
+package playlist
+
+import (
+	"strings"
+	"testing"
+)
+
+var entries = []Entry{
+	{Location: "http://127.0.0.1:8080/api/song/stream/1", Title: "Dancing Queen", Artist: "ABBA", Album: "Arrival", Duration: 231.4},
+	{Location: "http://127.0.0.1:8080/api/song/stream/2", Title: "Line\nBreak"},
+}
+
+func TestWriteM3U8(t *testing.T) {
+	expected := "#EXTM3U\n" +
+		"#PLAYLIST:Mix\n" +
+		"#EXTINF:231,ABBA - Dancing Queen\n" +
+		"#EXTALB:Arrival\n" +
+		"http://127.0.0.1:8080/api/song/stream/1\n" +
+		"#EXTINF:-1,Line Break\n" +
+		"http://127.0.0.1:8080/api/song/stream/2\n"
+
+	if result := string(WriteM3U8("Mix", entries)); result != expected {
+		t.Fatal("unexpected m3u8", result)
+	}
+}
+
+func TestWriteXSPF(t *testing.T) {
+	body, err := WriteXSPF("Rock & Roll", entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := string(body)
+	for _, expected := range []string{
+		`<playlist xmlns="http://xspf.org/ns/0/" version="1">`,
+		`<title>Rock &amp; Roll</title>`,
+		`<location>http://127.0.0.1:8080/api/song/stream/1</location>`,
+		`<creator>ABBA</creator>`,
+		`<duration>231400</duration>`,
+	} {
+		if !strings.Contains(result, expected) {
+			t.Fatal("missing", expected, "in", result)
+		}
+	}
+}
+
+func TestParseM3U(t *testing.T) {
+	content := "\xef\xbb\xbf#EXTM3U\n" +
+		"#PLAYLIST:Mix\n" +
+		"#EXTINF:231,ABBA - Dancing Queen\n" +
+		"#EXTALB:Arrival\n" +
+		"http://127.0.0.1:8080/api/song/stream/1\n" +
+		"\n" +
+		"C:\\Music\\02 - Waterloo.mp3\n"
+
+	format, err := DetectFormat("mix.m3u8", []byte(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	name, result, err := Parse(format, []byte(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "Mix" || len(result) != 2 {
+		t.Fatal("unexpected playlist", name, result)
+	}
+	if entry := result[0]; entry.Line != 3 || entry.Artist != "ABBA" || entry.Title != "Dancing Queen" || entry.Album != "Arrival" || entry.Duration != 231 {
+		t.Fatal("unexpected entry", entry)
+	}
+	if entry := result[1]; entry.Line != 7 || entry.BaseName() != "02 - Waterloo" {
+		t.Fatal("unexpected entry", entry, entry.BaseName())
+	}
+	if artist, title := result[1].Guess(); artist != "" || title != "Waterloo" {
+		t.Fatal("unexpected guess", artist, title)
+	}
+}
+
+func TestParseCSV(t *testing.T) {
+	content := "Track Name;Artist Name(s);Duration (ms)\n" +
+		"Dancing Queen;ABBA;231400\n" +
+		"\"Waterloo; Live\";ABBA;\n"
+
+	result, err := ParseCSV([]byte(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result) != 2 {
+		t.Fatal("unexpected entries", result)
+	}
+	if entry := result[0]; entry.Line != 2 || entry.Title != "Dancing Queen" || entry.Artist != "ABBA" || entry.Duration != 231.4 {
+		t.Fatal("unexpected entry", entry)
+	}
+	if entry := result[1]; entry.Line != 3 || entry.Title != "Waterloo; Live" {
+		t.Fatal("unexpected entry", entry)
+	}
+}
+
+func TestSimilar(t *testing.T) {
+	if !Similar(Normalize("Dancing Queen"), Normalize("dancing queen (Remastered 2011)")) {
+		t.Fatal("remastered should be similar")
+	}
+	if Similar(Normalize("Love"), Normalize("Love Will Tear Us Apart Again")) {
+		t.Fatal("short common words should not be similar")
+	}
+}
@@ -0,0 +1,91 @@
+package search
+
+import (
+	"html"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	MarkOpen  = "<mark>"
+	MarkClose = "</mark>"
+)
+
+// pattern matches any of terms case-insensitively, longer terms first, nil for no terms
+func pattern(terms []string) *regexp.Regexp {
+	if len(terms) == 0 {
+		return nil
+	}
+	quoted := make([]string, len(terms))
+	for i, term := range terms {
+		quoted[i] = regexp.QuoteMeta(term)
+	}
+	slices.SortStableFunc(quoted, func(a, b string) int {
+		return len(b) - len(a)
+	})
+	return regexp.MustCompile("(?i)" + strings.Join(quoted, "|"))
+}
+
+// Highlight escapes text as HTML, and wraps occurrences of terms with MarkOpen and MarkClose
+func Highlight(text string, terms []string) string {
+	re := pattern(terms)
+	if re == nil {
+		return html.EscapeString(text)
+	}
+
+	var builder strings.Builder
+	last := 0
+	for _, match := range re.FindAllStringIndex(text, -1) {
+		builder.WriteString(html.EscapeString(text[last:match[0]]))
+		builder.WriteString(MarkOpen)
+		builder.WriteString(html.EscapeString(text[match[0]:match[1]]))
+		builder.WriteString(MarkClose)
+		last = match[1]
+	}
+	builder.WriteString(html.EscapeString(text[last:]))
+	return builder.String()
+}
+
+// Snippet highlights the line of text where terms first occur, cut to about width runes around the occurrence,
+// returns an empty string if none of terms occurs
+func Snippet(text string, terms []string, width int) string {
+	re := pattern(terms)
+	if re == nil {
+		return ""
+	}
+	match := re.FindStringIndex(text)
+	if match == nil {
+		return ""
+	}
+
+	start := strings.LastIndexByte(text[:match[0]], '\n') + 1
+	end := len(text)
+	if index := strings.IndexByte(text[match[1]:], '\n'); index >= 0 {
+		end = match[1] + index
+	}
+
+	prefix, suffix := "", ""
+	if before := utf8.RuneCountInString(text[start:match[0]]); before > width/2 {
+		for ; before > width/2; before-- {
+			_, size := utf8.DecodeRuneInString(text[start:])
+			start += size
+		}
+		prefix = "…"
+	}
+	if utf8.RuneCountInString(text[start:end]) > width {
+		count := 0
+		for index := range text[start:] {
+			if count == width {
+				end = start + index
+				break
+			}
+			count++
+		}
+		suffix = "…"
+	}
+
+	line := strings.TrimSpace(text[start:end])
+	return prefix + Highlight(line, terms) + suffix
+}
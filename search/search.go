@@ -0,0 +1,215 @@
+package search
+
+import (
+	"fmt"
+	"github.com/allape/gocrud"
+	"github.com/allape/gogger"
+	"gorm.io/gorm"
+	"strings"
+	"unicode/utf8"
+)
+
+var l = gogger.New("search")
+
+const MaxTerms = 8
+
+type Mode string
+
+const (
+	ModeLike     Mode = "like"     // plain LIKE, no index
+	ModeFTS5     Mode = "fts5"     // SQLite FTS5 with the trigram tokenizer
+	ModeFullText Mode = "fulltext" // MySQL FULLTEXT with the ngram parser
+)
+
+// Source is a table to search, Columns[0] is the name column, which weighs more than others
+type Source struct {
+	Table   string
+	Columns []string
+}
+
+var (
+	Songs       = Source{Table: "songs", Columns: []string{"name", "description"}}
+	Collections = Source{Table: "collections", Columns: []string{"name", "keywords"}}
+	Lyrics      = Source{Table: "lyrics", Columns: []string{"name", "content"}}
+	Sources     = []Source{Songs, Collections, Lyrics}
+)
+
+type Hit struct {
+	ID    gocrud.ID
+	Score float64 // higher is better
+}
+
+// Setup creates full-text indexes of Sources, and returns ModeLike if the database does not support them
+func Setup(db *gorm.DB) Mode {
+	var mode Mode
+	var setup func(*gorm.DB, Source) error
+	switch db.Dialector.Name() {
+	case "sqlite":
+		mode, setup = ModeFTS5, setupFTS5
+	case "mysql":
+		mode, setup = ModeFullText, setupFullText
+	default:
+		return ModeLike
+	}
+
+	for _, source := range Sources {
+		if err := setup(db, source); err != nil {
+			l.Warn().Printf("full-text search is unavailable, fall back to LIKE: %v", err)
+			if mode == ModeFTS5 {
+				dropFTS5Triggers(db)
+			}
+			return ModeLike
+		}
+	}
+
+	return mode
+}
+
+// setupFTS5 creates an external content FTS5 table of source, kept in sync by triggers, and rebuilds it,
+// because migrations of SQLite may recreate the source table without the triggers
+func setupFTS5(db *gorm.DB, source Source) error {
+	fts := source.Table + "_fts"
+	columns := strings.Join(source.Columns, ", ")
+	newColumns := "new." + strings.Join(source.Columns, ", new.")
+	oldColumns := "old." + strings.Join(source.Columns, ", old.")
+
+	statements := []string{
+		fmt.Sprintf("CREATE VIRTUAL TABLE IF NOT EXISTS %s USING fts5(%s, content='%s', content_rowid='id', tokenize='trigram')", fts, columns, source.Table),
+		fmt.Sprintf("CREATE TRIGGER IF NOT EXISTS %[1]s_ai AFTER INSERT ON %[2]s BEGIN INSERT INTO %[1]s(rowid, %[3]s) VALUES (new.id, %[4]s); END", fts, source.Table, columns, newColumns),
+		fmt.Sprintf("CREATE TRIGGER IF NOT EXISTS %[1]s_ad AFTER DELETE ON %[2]s BEGIN INSERT INTO %[1]s(%[1]s, rowid, %[3]s) VALUES ('delete', old.id, %[4]s); END", fts, source.Table, columns, oldColumns),
+		fmt.Sprintf("CREATE TRIGGER IF NOT EXISTS %[1]s_au AFTER UPDATE ON %[2]s BEGIN INSERT INTO %[1]s(%[1]s, rowid, %[3]s) VALUES ('delete', old.id, %[4]s); INSERT INTO %[1]s(rowid, %[3]s) VALUES (new.id, %[5]s); END", fts, source.Table, columns, oldColumns, newColumns),
+		fmt.Sprintf("INSERT INTO %[1]s(%[1]s) VALUES ('rebuild')", fts),
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, statement := range statements {
+			if err := tx.Exec(statement).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// dropFTS5Triggers drops triggers left by a build with FTS5, which would fail every write to the source tables
+func dropFTS5Triggers(db *gorm.DB) {
+	for _, source := range Sources {
+		for _, suffix := range []string{"ai", "ad", "au"} {
+			if err := db.Exec(fmt.Sprintf("DROP TRIGGER IF EXISTS %s_fts_%s", source.Table, suffix)).Error; err != nil {
+				l.Warn().Printf("failed to drop trigger of %s: %v", source.Table, err)
+			}
+		}
+	}
+}
+
+// setupFullText creates a FULLTEXT index with the ngram parser, which tokenizes CJK text without spaces
+func setupFullText(db *gorm.DB, source Source) error {
+	name := "ft_" + source.Table
+	if db.Migrator().HasIndex(source.Table, name) {
+		return nil
+	}
+	return db.Exec(fmt.Sprintf("CREATE FULLTEXT INDEX %s ON %s (%s) WITH PARSER ngram", name, source.Table, strings.Join(source.Columns, ", "))).Error
+}
+
+// minTermLength is the shortest term the index can find, 3 for trigrams and 2 for the default ngram_token_size
+func (m Mode) minTermLength() int {
+	switch m {
+	case ModeFTS5:
+		return 3
+	case ModeFullText:
+		return 2
+	default:
+		return 1
+	}
+}
+
+// Terms splits q into at most MaxTerms distinct terms, with query operators removed
+func Terms(q string) []string {
+	q = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`"'*+-()<>~@^:`, r) {
+			return ' '
+		}
+		return r
+	}, q)
+
+	var terms []string
+	for _, term := range strings.Fields(q) {
+		duplicated := false
+		for _, exist := range terms {
+			duplicated = duplicated || strings.EqualFold(exist, term)
+		}
+		if !duplicated {
+			terms = append(terms, term)
+		}
+		if len(terms) == MaxTerms {
+			break
+		}
+	}
+	return terms
+}
+
+// Match finds rows of source containing all terms, db may be scoped with conditions on source.Table.
+// A term shorter than the index can find falls back to LIKE.
+func (m Mode) Match(db *gorm.DB, source Source, terms []string, limit int) ([]Hit, error) {
+	var hits []Hit
+	if len(terms) == 0 {
+		return hits, nil
+	}
+
+	mode := m
+	for _, term := range terms {
+		if utf8.RuneCountInString(term) < m.minTermLength() {
+			mode = ModeLike
+		}
+	}
+
+	var err error
+	switch mode {
+	case ModeFTS5:
+		fts := source.Table + "_fts"
+		weights := "10.0" + strings.Repeat(", 1.0", len(source.Columns)-1)
+		err = db.Table(fts).
+			Select(fmt.Sprintf("%s.id AS id, -bm25(%s, %s) AS score", source.Table, fts, weights)).
+			Joins(fmt.Sprintf("JOIN %[1]s ON %[1]s.id = %[2]s.rowid", source.Table, fts)).
+			Where(fmt.Sprintf("%s MATCH ?", fts), `"`+strings.Join(terms, `" "`)+`"`).
+			Order("score DESC").Order(source.Table + ".id DESC").
+			Limit(limit).
+			Scan(&hits).Error
+	case ModeFullText:
+		match := fmt.Sprintf("MATCH(%s.%s) AGAINST (? IN BOOLEAN MODE)", source.Table, strings.Join(source.Columns, ", "+source.Table+"."))
+		against := `+"` + strings.Join(terms, `" +"`) + `"`
+		err = db.Table(source.Table).
+			Select(fmt.Sprintf("%s.id AS id, %s AS score", source.Table, match), against).
+			Where(match, against).
+			Order("score DESC").Order(source.Table + ".id DESC").
+			Limit(limit).
+			Scan(&hits).Error
+	default:
+		score := make([]string, len(terms))
+		scoreArgs := make([]any, len(terms))
+		for i, term := range terms {
+			pattern := "%" + escapeLike(term) + "%"
+			score[i] = fmt.Sprintf("(CASE WHEN %s.%s LIKE ? ESCAPE '!' THEN 1 ELSE 0 END)", source.Table, source.Columns[0])
+			scoreArgs[i] = pattern
+
+			conditions := make([]string, len(source.Columns))
+			args := make([]any, len(source.Columns))
+			for j, column := range source.Columns {
+				conditions[j] = fmt.Sprintf("%s.%s LIKE ? ESCAPE '!'", source.Table, column)
+				args[j] = pattern
+			}
+			db = db.Where("("+strings.Join(conditions, " OR ")+")", args...)
+		}
+		err = db.Table(source.Table).
+			Select(fmt.Sprintf("%s.id AS id, 1 + %s AS score", source.Table, strings.Join(score, " + ")), scoreArgs...).
+			Order("score DESC").Order(source.Table + ".id DESC").
+			Limit(limit).
+			Scan(&hits).Error
+	}
+
+	return hits, err
+}
+
+func escapeLike(term string) string {
+	return strings.NewReplacer("!", "!!", "%", "!%", "_", "!_").Replace(term)
+}
@@ -0,0 +1,79 @@
+package search
+
+import (
+	"github.com/allape/homesong/model"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"testing"
+)
+
+func TestTerms(t *testing.T) {
+	terms := Terms(` "Dancing"  queen -abba DANCING 100%_`)
+	if len(terms) != 4 || terms[0] != "Dancing" || terms[1] != "queen" || terms[2] != "abba" || terms[3] != "100%_" {
+		t.Fatal("unexpected terms", terms)
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	if text := Highlight("Dancing <Queen> & queens", []string{"queen", "Q"}); text != "Dancing &lt;<mark>Queen</mark>&gt; &amp; <mark>queen</mark>s" {
+		t.Fatal("unexpected highlight", text)
+	}
+
+	content := "[00:10.00]You can dance\n[00:20.00]Having the time of your life, see that girl, watch that scene, dig in the dancing queen\n"
+	if text := Snippet(content, []string{"dancing"}, 20); text != "…ig in the <mark>dancing</mark> qu…" {
+		t.Fatal("unexpected snippet", text)
+	}
+	if text := Snippet(content, []string{"waterloo"}, 20); text != "" {
+		t.Fatal("unexpected snippet", text)
+	}
+}
+
+func TestMatch(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&model.Song{}, &model.Collection{}, &model.Lyrics{}); err != nil {
+		t.Fatal(err)
+	}
+
+	mode := Setup(db)
+	t.Log("mode", mode)
+
+	songs := []model.Song{
+		{Name: "Dancing Queen", Description: "ABBA"},
+		{Name: "Waterloo", Description: "Dancing"},
+		{Name: "月亮代表我的心", Description: "邓丽君"},
+		{Name: "Queen", Description: "Dancing"},
+	}
+	if err := db.Create(&songs).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Model(&songs[1]).Update("description", "ABBA, 1974").Error; err != nil {
+		t.Fatal(err)
+	}
+
+	for q, expected := range map[string][]int{
+		"dancing":    {0, 3},
+		"1974":       {1},
+		"queen abba": {0},
+		"我的心":        {2},
+		"月亮":         {2},
+		"100%":       {},
+		"abba waltz": {},
+	} {
+		hits, err := mode.Match(db.Where("songs.deleted_at IS NULL"), Songs, Terms(q), 10)
+		if err != nil {
+			t.Fatal(q, err)
+		}
+		if len(hits) != len(expected) {
+			t.Fatal("unexpected hits of", q, hits)
+		}
+		for i, index := range expected {
+			if hits[i].ID != songs[index].ID {
+				t.Fatal("unexpected hits of", q, hits)
+			}
+		}
+	}
+}
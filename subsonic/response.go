@@ -0,0 +1,252 @@
+package subsonic
+
+import (
+	"encoding/xml"
+	"time"
+)
+
+// Response is the envelope of every reply, encoded as XML or as JSON depending on ?f=
+type Response struct {
+	XMLName       xml.Name `xml:"subsonic-response" json:"-"`
+	Xmlns         string   `xml:"xmlns,attr" json:"-"`
+	Status        string   `xml:"status,attr" json:"status"`
+	Version       string   `xml:"version,attr" json:"version"`
+	Type          string   `xml:"type,attr" json:"type"`
+	ServerVersion string   `xml:"serverVersion,attr" json:"serverVersion"`
+	OpenSubsonic  bool     `xml:"openSubsonic,attr" json:"openSubsonic"`
+
+	Error                  *Error             `xml:"error,omitempty" json:"error,omitempty"`
+	License                *License           `xml:"license,omitempty" json:"license,omitempty"`
+	OpenSubsonicExtensions []Extension        `xml:"openSubsonicExtensions,omitempty" json:"openSubsonicExtensions,omitempty"`
+	MusicFolders           *MusicFolders      `xml:"musicFolders,omitempty" json:"musicFolders,omitempty"`
+	Indexes                *Indexes           `xml:"indexes,omitempty" json:"indexes,omitempty"`
+	Directory              *Directory         `xml:"directory,omitempty" json:"directory,omitempty"`
+	Artists                *Indexes           `xml:"artists,omitempty" json:"artists,omitempty"`
+	Artist                 *ArtistWithAlbums  `xml:"artist,omitempty" json:"artist,omitempty"`
+	ArtistInfo             *ArtistInfo        `xml:"artistInfo,omitempty" json:"artistInfo,omitempty"`
+	ArtistInfo2            *ArtistInfo        `xml:"artistInfo2,omitempty" json:"artistInfo2,omitempty"`
+	Album                  *AlbumWithSongs    `xml:"album,omitempty" json:"album,omitempty"`
+	AlbumInfo              *AlbumInfo         `xml:"albumInfo,omitempty" json:"albumInfo,omitempty"`
+	Song                   *Child             `xml:"song,omitempty" json:"song,omitempty"`
+	AlbumList              *AlbumList         `xml:"albumList,omitempty" json:"albumList,omitempty"`
+	AlbumList2             *AlbumList2        `xml:"albumList2,omitempty" json:"albumList2,omitempty"`
+	RandomSongs            *Songs             `xml:"randomSongs,omitempty" json:"randomSongs,omitempty"`
+	SearchResult3          *SearchResult      `xml:"searchResult3,omitempty" json:"searchResult3,omitempty"`
+	Playlists              *Playlists         `xml:"playlists,omitempty" json:"playlists,omitempty"`
+	Playlist               *PlaylistWithSongs `xml:"playlist,omitempty" json:"playlist,omitempty"`
+	Starred                *Starred           `xml:"starred,omitempty" json:"starred,omitempty"`
+	Starred2               *Starred           `xml:"starred2,omitempty" json:"starred2,omitempty"`
+	Lyrics                 *Lyrics            `xml:"lyrics,omitempty" json:"lyrics,omitempty"`
+	LyricsList             *LyricsList        `xml:"lyricsList,omitempty" json:"lyricsList,omitempty"`
+	Genres                 *Genres            `xml:"genres,omitempty" json:"genres,omitempty"`
+	NowPlaying             *NowPlaying        `xml:"nowPlaying,omitempty" json:"nowPlaying,omitempty"`
+	ScanStatus             *ScanStatus        `xml:"scanStatus,omitempty" json:"scanStatus,omitempty"`
+	User                   *User              `xml:"user,omitempty" json:"user,omitempty"`
+}
+
+type Error struct {
+	Code    ErrorCode `xml:"code,attr" json:"code"`
+	Message string    `xml:"message,attr" json:"message"`
+}
+
+type License struct {
+	Valid bool `xml:"valid,attr" json:"valid"`
+}
+
+type Extension struct {
+	Name     string `xml:"name,attr" json:"name"`
+	Versions []int  `xml:"versions" json:"versions"`
+}
+
+type MusicFolders struct {
+	MusicFolder []MusicFolder `xml:"musicFolder" json:"musicFolder"`
+}
+
+type MusicFolder struct {
+	ID   int    `xml:"id,attr" json:"id"`
+	Name string `xml:"name,attr" json:"name"`
+}
+
+// Indexes is used by both getIndexes and getArtists
+type Indexes struct {
+	LastModified    int64   `xml:"lastModified,attr,omitempty" json:"lastModified,omitempty"`
+	IgnoredArticles string  `xml:"ignoredArticles,attr" json:"ignoredArticles"`
+	Index           []Index `xml:"index" json:"index"`
+}
+
+type Index struct {
+	Name   string   `xml:"name,attr" json:"name"`
+	Artist []Artist `xml:"artist" json:"artist"`
+}
+
+type Artist struct {
+	ID         string     `xml:"id,attr" json:"id"`
+	Name       string     `xml:"name,attr" json:"name"`
+	CoverArt   string     `xml:"coverArt,attr,omitempty" json:"coverArt,omitempty"`
+	AlbumCount int        `xml:"albumCount,attr" json:"albumCount"`
+	Starred    *time.Time `xml:"starred,attr,omitempty" json:"starred,omitempty"`
+}
+
+type ArtistWithAlbums struct {
+	Artist
+	Album []Album `xml:"album" json:"album"`
+}
+
+type ArtistInfo struct {
+	Biography string `xml:"biography,omitempty" json:"biography,omitempty"`
+}
+
+type AlbumInfo struct {
+	Notes string `xml:"notes,omitempty" json:"notes,omitempty"`
+}
+
+type Album struct {
+	ID        string     `xml:"id,attr" json:"id"`
+	Name      string     `xml:"name,attr" json:"name"`
+	Artist    string     `xml:"artist,attr,omitempty" json:"artist,omitempty"`
+	ArtistID  string     `xml:"artistId,attr,omitempty" json:"artistId,omitempty"`
+	CoverArt  string     `xml:"coverArt,attr,omitempty" json:"coverArt,omitempty"`
+	SongCount int        `xml:"songCount,attr" json:"songCount"`
+	Duration  int        `xml:"duration,attr" json:"duration"`
+	PlayCount int64      `xml:"playCount,attr,omitempty" json:"playCount,omitempty"`
+	Created   time.Time  `xml:"created,attr" json:"created"`
+	Starred   *time.Time `xml:"starred,attr,omitempty" json:"starred,omitempty"`
+}
+
+type AlbumWithSongs struct {
+	Album
+	Song []Child `xml:"song" json:"song"`
+}
+
+// Child is a song, or a folder like album in folder based browsing
+type Child struct {
+	ID           string     `xml:"id,attr" json:"id"`
+	Parent       string     `xml:"parent,attr,omitempty" json:"parent,omitempty"`
+	IsDir        bool       `xml:"isDir,attr" json:"isDir"`
+	Title        string     `xml:"title,attr" json:"title"`
+	Album        string     `xml:"album,attr,omitempty" json:"album,omitempty"`
+	Artist       string     `xml:"artist,attr,omitempty" json:"artist,omitempty"`
+	Track        int        `xml:"track,attr,omitempty" json:"track,omitempty"`
+	DiscNumber   int        `xml:"discNumber,attr,omitempty" json:"discNumber,omitempty"`
+	CoverArt     string     `xml:"coverArt,attr,omitempty" json:"coverArt,omitempty"`
+	ContentType  string     `xml:"contentType,attr,omitempty" json:"contentType,omitempty"`
+	Suffix       string     `xml:"suffix,attr,omitempty" json:"suffix,omitempty"`
+	Duration     int        `xml:"duration,attr,omitempty" json:"duration,omitempty"`
+	BitRate      int        `xml:"bitRate,attr,omitempty" json:"bitRate,omitempty"` // in kbps
+	SamplingRate int        `xml:"samplingRate,attr,omitempty" json:"samplingRate,omitempty"`
+	ChannelCount int        `xml:"channelCount,attr,omitempty" json:"channelCount,omitempty"`
+	Path         string     `xml:"path,attr,omitempty" json:"path,omitempty"`
+	PlayCount    int64      `xml:"playCount,attr,omitempty" json:"playCount,omitempty"`
+	Created      *time.Time `xml:"created,attr,omitempty" json:"created,omitempty"`
+	Starred      *time.Time `xml:"starred,attr,omitempty" json:"starred,omitempty"`
+	AlbumID      string     `xml:"albumId,attr,omitempty" json:"albumId,omitempty"`
+	ArtistID     string     `xml:"artistId,attr,omitempty" json:"artistId,omitempty"`
+	Type         string     `xml:"type,attr,omitempty" json:"type,omitempty"`
+	MediaType    string     `xml:"mediaType,attr,omitempty" json:"mediaType,omitempty"`
+}
+
+type Directory struct {
+	ID     string  `xml:"id,attr" json:"id"`
+	Parent string  `xml:"parent,attr,omitempty" json:"parent,omitempty"`
+	Name   string  `xml:"name,attr" json:"name"`
+	Child  []Child `xml:"child" json:"child"`
+}
+
+type AlbumList struct {
+	Album []Child `xml:"album" json:"album"`
+}
+
+type AlbumList2 struct {
+	Album []Album `xml:"album" json:"album"`
+}
+
+type Songs struct {
+	Song []Child `xml:"song" json:"song"`
+}
+
+type SearchResult struct {
+	Artist []Artist `xml:"artist" json:"artist"`
+	Album  []Album  `xml:"album" json:"album"`
+	Song   []Child  `xml:"song" json:"song"`
+}
+
+type Playlists struct {
+	Playlist []Playlist `xml:"playlist" json:"playlist"`
+}
+
+type Playlist struct {
+	ID        string    `xml:"id,attr" json:"id"`
+	Name      string    `xml:"name,attr" json:"name"`
+	Comment   string    `xml:"comment,attr,omitempty" json:"comment,omitempty"`
+	Owner     string    `xml:"owner,attr,omitempty" json:"owner,omitempty"`
+	Public    bool      `xml:"public,attr" json:"public"`
+	SongCount int       `xml:"songCount,attr" json:"songCount"`
+	Duration  int       `xml:"duration,attr" json:"duration"`
+	Created   time.Time `xml:"created,attr" json:"created"`
+	Changed   time.Time `xml:"changed,attr" json:"changed"`
+	CoverArt  string    `xml:"coverArt,attr,omitempty" json:"coverArt,omitempty"`
+}
+
+type PlaylistWithSongs struct {
+	Playlist
+	Entry []Child `xml:"entry" json:"entry"`
+}
+
+type Starred struct {
+	Artist []Artist `xml:"artist" json:"artist"`
+	Album  []Album  `xml:"album" json:"album"`
+	Song   []Child  `xml:"song" json:"song"`
+}
+
+type Lyrics struct {
+	Artist string `xml:"artist,attr,omitempty" json:"artist,omitempty"`
+	Title  string `xml:"title,attr,omitempty" json:"title,omitempty"`
+	Value  string `xml:",chardata" json:"value"`
+}
+
+type LyricsList struct {
+	StructuredLyrics []StructuredLyrics `xml:"structuredLyrics" json:"structuredLyrics"`
+}
+
+type StructuredLyrics struct {
+	Lang          string `xml:"lang,attr" json:"lang"`
+	Synced        bool   `xml:"synced,attr" json:"synced"`
+	DisplayArtist string `xml:"displayArtist,attr,omitempty" json:"displayArtist,omitempty"`
+	DisplayTitle  string `xml:"displayTitle,attr,omitempty" json:"displayTitle,omitempty"`
+	Line          []Line `xml:"line" json:"line"`
+}
+
+type Line struct {
+	Start *int64 `xml:"start,attr,omitempty" json:"start,omitempty"` // in milliseconds, nil for unsynced lyrics
+	Value string `xml:",chardata" json:"value"`
+}
+
+type Genres struct {
+	Genre []struct{} `xml:"genre" json:"genre"`
+}
+
+type NowPlaying struct {
+	Entry []Child `xml:"entry" json:"entry"`
+}
+
+type ScanStatus struct {
+	Scanning bool  `xml:"scanning,attr" json:"scanning"`
+	Count    int64 `xml:"count,attr" json:"count"`
+}
+
+type User struct {
+	Username          string `xml:"username,attr" json:"username"`
+	ScrobblingEnabled bool   `xml:"scrobblingEnabled,attr" json:"scrobblingEnabled"`
+	AdminRole         bool   `xml:"adminRole,attr" json:"adminRole"`
+	SettingsRole      bool   `xml:"settingsRole,attr" json:"settingsRole"`
+	DownloadRole      bool   `xml:"downloadRole,attr" json:"downloadRole"`
+	UploadRole        bool   `xml:"uploadRole,attr" json:"uploadRole"`
+	PlaylistRole      bool   `xml:"playlistRole,attr" json:"playlistRole"`
+	CoverArtRole      bool   `xml:"coverArtRole,attr" json:"coverArtRole"`
+	CommentRole       bool   `xml:"commentRole,attr" json:"commentRole"`
+	PodcastRole       bool   `xml:"podcastRole,attr" json:"podcastRole"`
+	StreamRole        bool   `xml:"streamRole,attr" json:"streamRole"`
+	JukeboxRole       bool   `xml:"jukeboxRole,attr" json:"jukeboxRole"`
+	ShareRole         bool   `xml:"shareRole,attr" json:"shareRole"`
+	Folder            []int  `xml:"folder" json:"folder"`
+}
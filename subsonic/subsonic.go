@@ -0,0 +1,184 @@
+package subsonic
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"github.com/allape/gocrud"
+	"github.com/allape/homesong/ffmpeg"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const (
+	Xmlns         = "http://subsonic.org/restapi"
+	Version       = "1.16.1"
+	ServerType    = "homesong"
+	ServerVersion = "dev"
+
+	StatusOK     = "ok"
+	StatusFailed = "failed"
+)
+
+type ErrorCode int
+
+const (
+	ErrorGeneric                   ErrorCode = 0
+	ErrorMissingParameter          ErrorCode = 10
+	ErrorWrongCredentials          ErrorCode = 40
+	ErrorTokenAuthNotSupported     ErrorCode = 41
+	ErrorAuthMechanismNotSupported ErrorCode = 42
+	ErrorConflictingAuth           ErrorCode = 43
+	ErrorInvalidApiKey             ErrorCode = 44
+	ErrorNotAuthorized             ErrorCode = 50
+	ErrorNotFound                  ErrorCode = 70
+)
+
+// Extensions are the supported OpenSubsonic extensions
+var Extensions = []Extension{
+	{Name: "apiKeyAuthentication", Versions: []int{1}},
+	{Name: "formPost", Versions: []int{1}},
+	{Name: "songLyrics", Versions: []int{1}},
+}
+
+func NewResponse() *Response {
+	return &Response{
+		Xmlns:         Xmlns,
+		Status:        StatusOK,
+		Version:       Version,
+		Type:          ServerType,
+		ServerVersion: ServerVersion,
+		OpenSubsonic:  true,
+	}
+}
+
+func NewErrorResponse(code ErrorCode, message string) *Response {
+	response := NewResponse()
+	response.Status = StatusFailed
+	response.Error = &Error{Code: code, Message: message}
+	return response
+}
+
+// Write encodes response as ?f= asks, xml by default, errors are sent with 200 as the protocol requires
+func Write(context *gin.Context, response *Response) {
+	switch context.Request.FormValue("f") {
+	case "json":
+		context.JSON(http.StatusOK, gin.H{"subsonic-response": response})
+	case "jsonp":
+		body, err := json.Marshal(gin.H{"subsonic-response": response})
+		if err != nil {
+			context.Status(http.StatusInternalServerError)
+			return
+		}
+		callback := context.Request.FormValue("callback")
+		context.Data(http.StatusOK, "application/javascript; charset=utf-8", []byte(callback+"("+string(body)+");"))
+	default:
+		body, err := xml.Marshal(response)
+		if err != nil {
+			context.Status(http.StatusInternalServerError)
+			return
+		}
+		context.Data(http.StatusOK, "application/xml; charset=utf-8", append([]byte(xml.Header), body...))
+	}
+}
+
+// Fail writes an error response and aborts the following handlers
+func Fail(context *gin.Context, code ErrorCode, message string) {
+	Write(context, NewErrorResponse(code, message))
+	context.Abort()
+}
+
+// ids of different kinds share one namespace in subsonic, so they are prefixed
+const (
+	KindSong     = "so"
+	KindArtist   = "ar"
+	KindAlbum    = "al"
+	KindPlaylist = "pl"
+)
+
+func NewID(kind string, id gocrud.ID) string {
+	return kind + "-" + strconv.FormatUint(uint64(id), 10)
+}
+
+func SongID(id gocrud.ID) string {
+	return NewID(KindSong, id)
+}
+
+func ArtistID(id gocrud.ID) string {
+	return NewID(KindArtist, id)
+}
+
+func AlbumID(id gocrud.ID) string {
+	return NewID(KindAlbum, id)
+}
+
+func PlaylistID(id gocrud.ID) string {
+	return NewID(KindPlaylist, id)
+}
+
+// ParseID splits an id made by NewID, a bare number is taken as a song id
+func ParseID(id string) (string, gocrud.ID) {
+	kind, value, ok := strings.Cut(id, "-")
+	if !ok {
+		kind, value = KindSong, id
+	}
+	number, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return "", 0
+	}
+	return kind, gocrud.ID(number)
+}
+
+// IndexName returns the index an artist is listed under, "#" for names not starting with a latin letter
+func IndexName(name string) string {
+	for _, r := range strings.TrimSpace(name) {
+		if r < unicode.MaxASCII && unicode.IsLetter(r) {
+			return string(unicode.ToUpper(r))
+		}
+		break
+	}
+	return "#"
+}
+
+var formatProfiles = map[string]ffmpeg.ProfileName{
+	"mp3":  ffmpeg.ProfileMP3,
+	"opus": ffmpeg.ProfileOpus96,
+	"ogg":  ffmpeg.ProfileOpus96,
+	"aac":  ffmpeg.ProfileAAC256,
+	"m4a":  ffmpeg.ProfileAAC256,
+	"flac": ffmpeg.ProfileFLAC,
+}
+
+// PickProfile chooses a transcoding profile for ?format= and ?maxBitRate= (in kbps) of stream,
+// false means the original file fits and should be sent as is
+func PickProfile(format string, maxBitRate int, bitRate int64, codec string) (ffmpeg.ProfileName, bool) {
+	format = strings.ToLower(strings.TrimSpace(format))
+	if format == "raw" {
+		return "", false
+	}
+
+	tooLarge := maxBitRate > 0 && (bitRate == 0 || bitRate > int64(maxBitRate)*1000)
+
+	profile, ok := formatProfiles[format]
+	if !ok {
+		if !tooLarge {
+			return "", false
+		}
+		profile = ffmpeg.ProfileMP3
+	} else if !tooLarge && ffmpeg.Profiles[profile].Codec == ffmpeg.CodecName(codec) {
+		return "", false
+	}
+
+	if tooLarge && (profile == ffmpeg.ProfileMP3 || profile == ffmpeg.ProfileFLAC) {
+		profile = ffmpeg.ProfileMP3At128
+		if maxBitRate >= 320 {
+			profile = ffmpeg.ProfileMP3At320
+		}
+	} else if tooLarge && profile == ffmpeg.ProfileAAC256 && maxBitRate < 256 {
+		profile = ffmpeg.ProfileOpus96
+	}
+
+	return profile, true
+}
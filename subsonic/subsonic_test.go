@@ -0,0 +1,58 @@
+package subsonic
+
+import (
+	"github.com/allape/homesong/ffmpeg"
+	"testing"
+)
+
+func TestParseID(t *testing.T) {
+	if kind, id := ParseID(AlbumID(12)); kind != KindAlbum || id != 12 {
+		t.Fatal("unexpected album id", kind, id)
+	}
+	if kind, id := ParseID("34"); kind != KindSong || id != 34 {
+		t.Fatal("unexpected song id", kind, id)
+	}
+	if kind, id := ParseID("ar-abc"); kind != "" || id != 0 {
+		t.Fatal("unexpected invalid id", kind, id)
+	}
+}
+
+func TestIndexName(t *testing.T) {
+	for name, expected := range map[string]string{
+		"abba":    "A",
+		" Queen":  "Q",
+		"2Pac":    "#",
+		"周杰伦":     "#",
+		"":        "#",
+		"Élodie":  "#",
+		"the who": "T",
+	} {
+		if result := IndexName(name); result != expected {
+			t.Fatal("unexpected index of", name, result)
+		}
+	}
+}
+
+func TestPickProfile(t *testing.T) {
+	if _, ok := PickProfile("", 0, 320000, "mp3"); ok {
+		t.Fatal("original file should be sent without format and max bit rate")
+	}
+	if _, ok := PickProfile("raw", 128, 1411000, "flac"); ok {
+		t.Fatal("raw should never be transcoded")
+	}
+	if _, ok := PickProfile("mp3", 0, 320000, "mp3"); ok {
+		t.Fatal("mp3 should not be transcoded to mp3")
+	}
+	if profile, ok := PickProfile("mp3", 0, 1411000, "flac"); !ok || profile != ffmpeg.ProfileMP3 {
+		t.Fatal("flac should be transcoded to mp3", profile)
+	}
+	if profile, ok := PickProfile("", 128, 1411000, "flac"); !ok || profile != ffmpeg.ProfileMP3At128 {
+		t.Fatal("flac should be transcoded to 128kbps mp3", profile)
+	}
+	if profile, ok := PickProfile("mp3", 320, 1411000, "flac"); !ok || profile != ffmpeg.ProfileMP3At320 {
+		t.Fatal("flac should be transcoded to 320kbps mp3", profile)
+	}
+	if profile, ok := PickProfile("aac", 128, 1411000, "flac"); !ok || profile != ffmpeg.ProfileOpus96 {
+		t.Fatal("aac under 256kbps should fall back to opus", profile)
+	}
+}
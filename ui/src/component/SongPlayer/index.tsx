@@ -50,7 +50,7 @@ function modifySong(s: ISongWithCollections): IModifiedSong {
   return {
     ...s,
     _url: s.mime
-      ? `${config.SERVER_STATIC_URL}${s.filename}`
+      ? `${config.SERVER_URL}/song/stream/${s.id}`
       : `${config.SERVER_URL}/song/hotwire/${s.id}`,
     _cover: s.cover ? `${config.SERVER_STATIC_URL}${s.cover}` : undefined,
     _name: `${s._singerNames ? `${s._singerNames} - ` : ""}${s.name}`,